@@ -13,11 +13,14 @@ import (
 	"inquisitive-grimalkin/utils"
 	"log"
 	"os"
+	"strings"
 	"sync"
 	"time"
-	// "golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type LikeAction int
@@ -37,6 +40,25 @@ var questionsByUserTableDDL = `CREATE TABLE IF NOT EXISTS main.questions_by_user
 var qAndAByUserTableDDL = `CREATE TABLE IF NOT EXISTS main.q_and_a_users (asked text, question_id timeuuid, asker text, is_anon boolean, question text, answer text,
 								PRIMARY KEY ((asked), question_id));`
 
+/*
+ * The asker of an anonymous question is never written to questions_by_user or any of the q&a tables, it is only kept here keyed by the question so that
+ * moderators can still find who is behind an abusive question. Nothing that serves the inbox or the timelines reads from this table.
+ */
+var anonymousAskersByQuestionDDL = `CREATE TABLE IF NOT EXISTS main.anonymous_askers_by_question (question_id timeuuid, asker text, PRIMARY KEY ((question_id)));`
+
+/*
+ * The q&as are partitioned by the user who answered them, this finds that user from the id of a q&a alone, like when someone likes it and the owner has
+ * to be notified. It is written in the same batch as the q&a and deleted with it.
+ */
+var qAndAOwnersDDL = `CREATE TABLE IF NOT EXISTS main.q_and_a_owners (question_id timeuuid, asked text, PRIMARY KEY ((question_id)));`
+
+/*
+ * Every question, anonymous or not, is also indexed under the person who asked it so that they can be found again when the asker exports or erases
+ * their data. The partition is only ever read on behalf of its owner.
+ */
+var questionsByAskerDDL = `CREATE TABLE IF NOT EXISTS main.questions_by_asker (asker text, question_id timeuuid, asked text, is_anon boolean, question text, answered boolean,
+								PRIMARY KEY ((asker), question_id));`
+
 /*
   - A problem that needs to be solved is showing the answered questions of the users that one person follows.
   - The implementation is inspired by what was mentioned in Martin Kleppmann's Designing Data-Intensive Application (P11-13) about Twitter handling the kkjkjdisplay of
@@ -70,13 +92,43 @@ var qAndAByFollowerDDL = `CREATE TABLE IF NOT EXISTS main.q_and_a_followers (fol
 							PRIMARY KEY ((follower), question_id));`
 var qAndALikesDDL = `CREATE TABLE IF NOT EXISTS main.q_and_a_likes (question_id timeuuid, likes counter, PRIMARY KEY ((question_id)));`
 
-var usersDDL = `CREATE TABLE IF NOT EXISTS main.users (username text, email text, first_name text, last_name text, password text, created_on timeuuid, PRIMARY KEY ((username)));`
+var usersDDL = `CREATE TABLE IF NOT EXISTS main.users (username text, email text, first_name text, last_name text, password text, created_on timeuuid, pending_email text, email_verified boolean, suspended_until timestamp, banned boolean, suspension_reason text, roles set<text>, PRIMARY KEY ((username)));`
+
+/*
+ * Cassandra cannot enforce uniqueness on a regular column, so every email is reserved in its own partition with a lightweight transaction before the user
+ * is inserted, the emails are lowercased before they are used as a key
+ */
+var usersByEmailDDL = `CREATE TABLE IF NOT EXISTS main.users_by_email (email text, username text, PRIMARY KEY ((email)));`
+
+/*
+ * The usernames are reserved the same way, lowercased, so that two usernames that only differ by their case, which look alike, cannot both be registered
+ */
+var usersByLowercaseUsernameDDL = `CREATE TABLE IF NOT EXISTS main.users_by_lowercase_username (lowercase_username text, username text,
+									PRIMARY KEY ((lowercase_username)));`
+
+/*
+ * Backs the typeahead search, every prefix of the username and of the names of a user is a partition holding that user, see utils.SearchPrefixes. The
+ * names are copied here so that a search is answered without going back to the users table.
+ */
+var usersByPrefixDDL = `CREATE TABLE IF NOT EXISTS main.users_by_prefix (prefix text, username text, first_name text, last_name text,
+						PRIMARY KEY ((prefix), username));`
+
+// The rows of a prefix are read this many at a time, a short prefix can hold a large part of the users
+const searchPageSize = 500
+
+// The followers of the users found are counted this many at a time
+const maxFollowerCountsPerQuery = 100
 
 /*
  * This table will have the user as the partition key and the followers i.e. other users as clustering keys
  */
 var userByFollowersDDL = `CREATE TABLE IF NOT EXISTS main.followers_by_user (followed text, follower text, PRIMARY KEY ((followed), follower));`
 
+/*
+ * The mirror of followers_by_user, the user is the partition key and the people they follow are the clustering keys
+ */
+var followingByUserDDL = `CREATE TABLE IF NOT EXISTS main.following_by_user (follower text, followed text, PRIMARY KEY ((follower), followed));`
+
 /*
   - Instead of using grouping by and counting the number of those who the user follows and who follow him, we will create two counter tables each for following
     and followers
@@ -110,15 +162,34 @@ func init() {
 
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatalf("something went wrong in loading the variables %s\n", err)
+		log.Printf("no .env was loaded, the variables are taken from the environment %s\n", err)
 	}
 	cassandraRemoteUri = os.Getenv("CASSANDRA_REMOTE_URI")
 	cassandraClientId = os.Getenv("CASSANDRA_CLIENT_ID")
 	cassandraClientSecret = os.Getenv("CASSANDRA_CLIENT_SECRET")
 	cassandraBearerToken = os.Getenv("CASSANDRA_BEARER_TOKEN")
 
+}
+
+/*
+ * Creates the tables that do not exist yet and adds the columns they are missing, the server calls it once before serving. Every statement is
+ * idempotent so it runs on each start, whether the keyspace is new or was created by an older version.
+ */
+func CreateTables() {
+	if cassandraRemoteUri == "" {
+		log.Fatalf("CASSANDRA_REMOTE_URI is not set, the tables cannot be created\n")
+	}
+
+	// The owners of the q&as answered before q_and_a_owners existed are copied into it once, when it is first created
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+	qAndAOwnersExisted, err := tableExists(cassandraClient, "q_and_a_owners")
+	if err != nil {
+		log.Fatalf("failed to look for the q_and_a_owners table %s\n", err)
+	}
+
 	tableCreationSynchronizer := sync.WaitGroup{}
-	tableCreationSynchronizer.Add(4)
+	tableCreationSynchronizer.Add(13)
 
 	go func() {
 		cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
@@ -131,6 +202,21 @@ func init() {
 		if err != nil {
 			log.Fatalf("failed to create q_and_a_user table %s\n", err)
 		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: anonymousAskersByQuestionDDL})
+		if err != nil {
+			log.Fatalf("failed to create anonymous_askers_by_question table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: questionsByAskerDDL})
+		if err != nil {
+			log.Fatalf("failed to create questions_by_asker table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: qAndAOwnersDDL})
+		if err != nil {
+			log.Fatalf("failed to create q_and_a_owners table %s\n", err)
+		}
 		tableCreationSynchronizer.Done()
 	}()
 
@@ -161,6 +247,36 @@ func init() {
 		if err != nil {
 			log.Fatalf("failed to create users_by_followers table %s\n", err)
 		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: usersByEmailDDL})
+		if err != nil {
+			log.Fatalf("failed to create users_by_email table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: usersByLowercaseUsernameDDL})
+		if err != nil {
+			log.Fatalf("failed to create users_by_lowercase_username table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: followingByUserDDL})
+		if err != nil {
+			log.Fatalf("failed to create following_by_user table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: sessionRevocationsDDL})
+		if err != nil {
+			log.Fatalf("failed to create session_revocations table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: accountTokensDDL})
+		if err != nil {
+			log.Fatalf("failed to create account_tokens table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: usersByPrefixDDL})
+		if err != nil {
+			log.Fatalf("failed to create users_by_prefix table %s\n", err)
+		}
 		tableCreationSynchronizer.Done()
 
 	}()
@@ -180,10 +296,249 @@ func init() {
 		tableCreationSynchronizer.Done()
 
 	}()
+
+	go func() {
+		cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+		defer cassandraConnectionClient.Put(cassandraClient)
+		_, err := cassandraClient.ExecuteQuery(&proto.Query{Cql: userSettingsDDL})
+		if err != nil {
+			log.Fatalf("failed to create user settings table %s\n", err)
+		}
+		tableCreationSynchronizer.Done()
+	}()
+
+	go func() {
+		cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+		defer cassandraConnectionClient.Put(cassandraClient)
+		_, err := cassandraClient.ExecuteQuery(&proto.Query{Cql: erasureRecordsDDL})
+		if err != nil {
+			log.Fatalf("failed to create erasure records table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: dataExportsByUserDDL})
+		if err != nil {
+			log.Fatalf("failed to create data exports table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: sharedQAndAsDDL})
+		if err != nil {
+			log.Fatalf("failed to create shared q&as table %s\n", err)
+		}
+		tableCreationSynchronizer.Done()
+	}()
+
+	go func() {
+		cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+		defer cassandraConnectionClient.Put(cassandraClient)
+		_, err := cassandraClient.ExecuteQuery(&proto.Query{Cql: linkedAccountsByUserDDL})
+		if err != nil {
+			log.Fatalf("failed to create linked accounts table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: shareJobsByUserDDL})
+		if err != nil {
+			log.Fatalf("failed to create share jobs table %s\n", err)
+		}
+		tableCreationSynchronizer.Done()
+	}()
+
+	go func() {
+		cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+		defer cassandraConnectionClient.Put(cassandraClient)
+		_, err := cassandraClient.ExecuteQuery(&proto.Query{Cql: moderationHoldsDDL})
+		if err != nil {
+			log.Fatalf("failed to create moderation holds table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: reportsDDL})
+		if err != nil {
+			log.Fatalf("failed to create reports table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: reportsByStatusDDL})
+		if err != nil {
+			log.Fatalf("failed to create reports by status table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: moderationAuditLogDDL})
+		if err != nil {
+			log.Fatalf("failed to create moderation audit log table %s\n", err)
+		}
+		tableCreationSynchronizer.Done()
+	}()
+
+	go func() {
+		cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+		defer cassandraConnectionClient.Put(cassandraClient)
+		_, err := cassandraClient.ExecuteQuery(&proto.Query{Cql: rateLimitBucketsDDL})
+		if err != nil {
+			log.Fatalf("failed to create rate limit buckets table %s\n", err)
+		}
+		tableCreationSynchronizer.Done()
+	}()
+
+	go func() {
+		cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+		defer cassandraConnectionClient.Put(cassandraClient)
+		_, err := cassandraClient.ExecuteQuery(&proto.Query{Cql: recentQuestionsByAskerDDL})
+		if err != nil {
+			log.Fatalf("failed to create recent questions by asker table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: quarantinedQuestionsByUserDDL})
+		if err != nil {
+			log.Fatalf("failed to create quarantined questions table %s\n", err)
+		}
+		tableCreationSynchronizer.Done()
+	}()
+
+	go func() {
+		cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+		defer cassandraConnectionClient.Put(cassandraClient)
+		_, err := cassandraClient.ExecuteQuery(&proto.Query{Cql: notificationsByUserDDL})
+		if err != nil {
+			log.Fatalf("failed to create notifications table %s\n", err)
+		}
+
+		_, err = cassandraClient.ExecuteQuery(&proto.Query{Cql: likeNotificationsByQAndADDL})
+		if err != nil {
+			log.Fatalf("failed to create like notifications table %s\n", err)
+		}
+		tableCreationSynchronizer.Done()
+	}()
+
+	go func() {
+		cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+		defer cassandraConnectionClient.Put(cassandraClient)
+		_, err := cassandraClient.ExecuteQuery(&proto.Query{Cql: pushSubscriptionsByUserDDL})
+		if err != nil {
+			log.Fatalf("failed to create push subscriptions table %s\n", err)
+		}
+		tableCreationSynchronizer.Done()
+	}()
+	go func() {
+		cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+		defer cassandraConnectionClient.Put(cassandraClient)
+		_, err := cassandraClient.ExecuteQuery(&proto.Query{Cql: emailDigestsDDL})
+		if err != nil {
+			log.Fatalf("failed to create email digests table %s\n", err)
+		}
+		tableCreationSynchronizer.Done()
+	}()
 	tableCreationSynchronizer.Wait()
+
+	err = addMissingColumns(cassandraClient)
+	if err != nil {
+		log.Fatalf("failed to add the missing columns %s\n", err)
+	}
+	if !qAndAOwnersExisted {
+		err = backfillQAndAOwners(cassandraClient)
+		if err != nil {
+			log.Fatalf("failed to fill the q_and_a_owners table %s\n", err)
+		}
+	}
 	log.Printf("successfully created all tables")
 }
 
+/*
+ * CREATE TABLE IF NOT EXISTS leaves a table that already exists as it is, so every column added to a table after it was first created is listed here
+ * and added when the table does not have it yet
+ */
+var addedColumns = []struct {
+	table   string
+	column  string
+	cqlType string
+}{
+	{table: "users", column: "pending_email", cqlType: "text"},
+	{table: "users", column: "email_verified", cqlType: "boolean"},
+	{table: "users", column: "suspended_until", cqlType: "timestamp"},
+	{table: "users", column: "banned", cqlType: "boolean"},
+	{table: "users", column: "suspension_reason", cqlType: "text"},
+	{table: "users", column: "roles", cqlType: "set<text>"},
+	{table: "like_notifications_by_q_and_a", column: "likers", cqlType: "set<text>"},
+}
+
+func tableExists(cassandraClient *client.StargateClient, table string) (bool, error) {
+	res, err := cassandraClient.ExecuteQuery(&proto.Query{
+		Cql: `SELECT table_name FROM system_schema.tables WHERE keyspace_name = 'main' AND table_name = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_String_{String_: table}},
+			},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(res.GetResultSet().GetRows()) > 0, nil
+}
+
+func backfillQAndAOwners(cassandraClient *client.StargateClient) error {
+	readOwnersQuery := &proto.Query{
+		Cql:        `SELECT asked, question_id FROM main.q_and_a_users;`,
+		Parameters: &proto.QueryParameters{PageSize: wrapperspb.Int32(searchPageSize)},
+	}
+	for {
+		res, err := cassandraClient.ExecuteQuery(readOwnersQuery)
+		if err != nil {
+			return fmt.Errorf("failed to read the q&as %s", err)
+		}
+		ownersBatchQuery := []*proto.BatchQuery{}
+		for _, row := range res.GetResultSet().Rows {
+			ownersBatchQuery = append(ownersBatchQuery, qAndAOwnerBatchQuery(row.Values[1].GetUuid(), row.Values[0].GetString_()))
+		}
+		if len(ownersBatchQuery) > 0 {
+			_, err = cassandraClient.ExecuteBatch(&proto.Batch{Type: proto.Batch_UNLOGGED, Queries: ownersBatchQuery})
+			if err != nil {
+				return fmt.Errorf("failed to write the owners of the q&as %s", err)
+			}
+		}
+		pagingState := res.GetResultSet().GetPagingState()
+		if len(pagingState.GetValue()) == 0 {
+			return nil
+		}
+		readOwnersQuery.Parameters.PagingState = pagingState
+	}
+}
+
+func addMissingColumns(cassandraClient *client.StargateClient) error {
+	columnsByTable := map[string]map[string]bool{}
+	for _, added := range addedColumns {
+		columns, ok := columnsByTable[added.table]
+		if !ok {
+			res, err := cassandraClient.ExecuteQuery(&proto.Query{
+				Cql: `SELECT column_name FROM system_schema.columns WHERE keyspace_name = 'main' AND table_name = ?;`,
+				Values: &proto.Values{
+					Values: []*proto.Value{
+						{Inner: &proto.Value_String_{String_: added.table}},
+					},
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to read the columns of %s %s", added.table, err)
+			}
+			columns = map[string]bool{}
+			for _, row := range res.GetResultSet().GetRows() {
+				columns[row.Values[0].GetString_()] = true
+			}
+			columnsByTable[added.table] = columns
+		}
+		if columns[added.column] {
+			continue
+		}
+
+		_, err := cassandraClient.ExecuteQuery(&proto.Query{
+			Cql: fmt.Sprintf(`ALTER TABLE main.%s ADD %s %s;`, added.table, added.column, added.cqlType),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add %s to %s %s", added.column, added.table, err)
+		}
+		columns[added.column] = true
+		log.Printf("added the column %s to %s\n", added.column, added.table)
+	}
+	return nil
+}
+
 func NewCassandraQuestionsRepository() CassandraQuestionsRepository {
 	return CassandraQuestionsRepository{}
 }
@@ -191,14 +546,45 @@ func NewCassandraQuestionsRepository() CassandraQuestionsRepository {
 type CassandraQuestionsRepository struct {
 }
 
-func (c *CassandraQuestionsRepository) UpdateAnswerToFollowersHomefeed(ctx context.Context, a models.QAndA, user ...models.User) error {
-	//TODO implement me
-	panic("implement me")
+/*
+ * The rows of the homefeeds are written with every column of the q&a, so updating them is the same as posting them again, Cassandra upserts them
+ */
+func (c *CassandraQuestionsRepository) UpdateAnswerToFollowersHomefeed(ctx context.Context, a models.QAndA, users ...models.User) error {
+	return c.PostAnswerToFollowersHomefeed(ctx, a, users...)
 }
 
-func (c *CassandraQuestionsRepository) DeleteAnswerFromFollowersHomefeed(ctx context.Context, a models.QAndA, user ...models.User) error {
-	//TODO implement me
-	panic("implement me")
+func (c *CassandraQuestionsRepository) DeleteAnswerFromFollowersHomefeed(ctx context.Context, a models.QAndA, users ...models.User) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantUuid, err := googleUuidToCassandraUuid(a.QuestionId)
+	if err != nil {
+		return fmt.Errorf("failed to delete the q&a from the homefeeds %s", err)
+	}
+
+	deleteFromTimeLineBatchQuery := []*proto.BatchQuery{}
+	for _, u := range users {
+		deleteFromTimeLineBatchQuery = append(deleteFromTimeLineBatchQuery, &proto.BatchQuery{
+			Cql: `DELETE FROM main.q_and_a_followers WHERE follower = ? AND question_id = ?;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: u.Username}},
+					&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantUuid}},
+				},
+			},
+		})
+	}
+
+	if len(deleteFromTimeLineBatchQuery) == 0 {
+		return nil
+	}
+
+	_, err = cassandraClient.ExecuteBatchWithContext(&proto.Batch{Type: proto.Batch_LOGGED, Queries: deleteFromTimeLineBatchQuery}, ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete the q&a from the homefeeds %s", err)
+	}
+
+	return nil
 }
 
 func (c *CassandraQuestionsRepository) GetUnansweredQuestionsForUser(context context.Context, askedUser string) ([]models.Question, error) {
@@ -213,7 +599,7 @@ func (c *CassandraQuestionsRepository) GetUnansweredQuestionsForUser(context con
 		Cql: "SELECT * FROM main.questions_by_user WHERE asked = ?;",
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				{Inner: &proto.Value_String_{askedUser}},
+				{Inner: &proto.Value_String_{String_: askedUser}},
 			},
 		},
 	}
@@ -246,11 +632,6 @@ func (c *CassandraQuestionsRepository) Ask(ctx context.Context, q models.Questio
 	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
 	defer cassandraConnectionClient.Put(cassandraClient)
 
-	err := utils.ValidateQuestion(q)
-	if err != nil {
-		return models.Question{}, fmt.Errorf("failed to validate the question %s", err)
-	}
-
 	generatedQuestionId, err := uuid.NewUUID()
 	if err != nil {
 		return models.Question{}, fmt.Errorf("failed to ask this question %s", err)
@@ -261,23 +642,31 @@ func (c *CassandraQuestionsRepository) Ask(ctx context.Context, q models.Questio
 		return models.Question{}, fmt.Errorf("failed to ask this question %s, try again later", err)
 	}
 
-	AskQuery := &proto.Query{Cql: `INSERT INTO main.questions_by_user (asked , question_id , asker , is_anon , question) 
-									VALUES (?, ?, ?, ?, ?);`,
-		Values: &proto.Values{
-			Values: []*proto.Value{
-				{Inner: &proto.Value_String_{q.Asked}},
-				{Inner: &proto.Value_Uuid{cassandraCompliantUuid}},
-				{Inner: &proto.Value_String_{q.Asker}},
-				{Inner: &proto.Value_Boolean{q.IsAnon}},
-				{Inner: &proto.Value_String_{q.Question}},
+	askBatchQuery := []*proto.BatchQuery{
+		{
+			Cql: `INSERT INTO main.questions_by_user (asked , question_id , asker , is_anon , question) 
+					VALUES (?, ?, ?, ?, ?);`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					{Inner: &proto.Value_String_{String_: q.Asked}},
+					{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantUuid}},
+					{Inner: &proto.Value_String_{String_: visibleAsker(q.IsAnon, q.Asker)}},
+					{Inner: &proto.Value_Boolean{Boolean: q.IsAnon}},
+					{Inner: &proto.Value_String_{String_: q.Question}},
+				},
 			},
 		},
 	}
+	askBatchQuery = append(askBatchQuery, questionsByAskerBatchQuery(cassandraCompliantUuid, q, false))
+	if q.IsAnon {
+		askBatchQuery = append(askBatchQuery, anonymousAskerBatchQuery(cassandraCompliantUuid, q.Asker))
+	}
 
 	/*
 	 * Resposne is deliberatly ignored because as per Cassandra Standard, inserted values do not get returned as part of response just like SQL
+	 * The batch is logged so that an anonymous question never lands in the inbox without its asker being recorded for the moderators
 	 */
-	_, err = cassandraClient.ExecuteQuery(AskQuery)
+	_, err = cassandraClient.ExecuteBatch(&proto.Batch{Type: proto.Batch_LOGGED, Queries: askBatchQuery})
 	if err != nil {
 		return models.Question{}, err
 	}
@@ -289,7 +678,7 @@ func (c *CassandraQuestionsRepository) Ask(ctx context.Context, q models.Questio
 		IsAnon:     q.IsAnon,
 		Question:   q.Question,
 	}
-	log.Printf("successfully persisted the question %s at %s\n", persistedQuestion, time.Now())
+	log.Printf("successfully persisted the question %s at %s\n", persistedQuestion.QuestionId, time.Now())
 
 	return persistedQuestion, nil
 }
@@ -298,24 +687,28 @@ func (c *CassandraQuestionsRepository) AnswerQuestion(ctx context.Context, quest
 	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
 	defer cassandraConnectionClient.Put(cassandraClient)
 
-	cassandraCompliantQAndAUuid, err := googleUuidToCassandraUuid(questionId)
+	cassandraCompliantQuestionUuid, err := googleUuidToCassandraUuid(questionId)
 	if err != nil {
 		return models.QAndA{}, fmt.Errorf("failed to answer the question and parsing the uuid %s", err)
 	}
 
-	// TODO: Refactor the deletion into a separate table
-	deleteTheQuestionWithoutAnswerQuery := `delete FROM main.questions_by_user WHERE asked = ? AND question_id = ?;`
-	_, err = cassandraClient.ExecuteQuery(&proto.Query{
-		Cql: deleteTheQuestionWithoutAnswerQuery,
-		Values: &proto.Values{
-			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_String_{qAndA.Asked}},
-				&proto.Value{Inner: &proto.Value_Uuid{cassandraCompliantQAndAUuid}},
-			},
-		},
-	})
+	/*
+	 * Step 1 fetch the question as it was asked, the asker, the anonymity and the question itself are taken from what was stored and never from what the
+	 * client sent along with the answer
+	 */
+	question, err := c.getUnansweredQuestion(ctx, cassandraClient, qAndA.Asked, questionId)
 	if err != nil {
-		return models.QAndA{}, fmt.Errorf("failed to answer the question, unable to delete the question before reposting %s", err)
+		return models.QAndA{}, err
+	}
+	qAndA.Asker = question.Asker
+	qAndA.IsAnon = question.IsAnon
+	qAndA.Question = question.Question
+	if question.IsAnon {
+		anonymousAsker, err := c.RevealAnonymousAsker(ctx, questionId)
+		if err != nil {
+			return models.QAndA{}, fmt.Errorf("failed to answer the question, unable to carry over the anonymous asker %s", err)
+		}
+		qAndA.Asker = anonymousAsker.Asker
 	}
 
 	qAndAUuid, err := uuid.NewUUID()
@@ -323,34 +716,63 @@ func (c *CassandraQuestionsRepository) AnswerQuestion(ctx context.Context, quest
 		return models.QAndA{}, fmt.Errorf("failed to generate new uuid for answer to question%s", err)
 	}
 
-	cassandraCompliantQAndAUuid, err = googleUuidToCassandraUuid(qAndAUuid)
+	cassandraCompliantQAndAUuid, err := googleUuidToCassandraUuid(qAndAUuid)
 	if err != nil {
 		return models.QAndA{}, fmt.Errorf("failed to parse a cassandra compliant uuid for the answer %s", err)
 	}
 
-	// Step 2 insert the q&a into the table that will appear to the asked person
-	insertAnsweredQuestionQuery := `insert INTO main.q_and_a_users 
-									(asked , question_id , answer , asker , is_anon , question ) 
-									VALUES (?, ? ,?, ?, ?, ?);`
-	_, err = cassandraClient.ExecuteQuery(&proto.Query{
-		Cql: insertAnsweredQuestionQuery,
-		Values: &proto.Values{
-			Values: []*proto.Value{
-				{Inner: &proto.Value_String_{qAndA.Asked}},
-				{Inner: &proto.Value_Uuid{cassandraCompliantQAndAUuid}},
-				{Inner: &proto.Value_String_{qAndA.Answer}},
-				{Inner: &proto.Value_String_{qAndA.Asker}},
-				{Inner: &proto.Value_Boolean{qAndA.IsAnon}},
-				{Inner: &proto.Value_String_{qAndA.Question}},
+	// Step 2 move the question out of the inbox and insert the q&a into the table that will appear to the asked person, all in one logged batch
+	insertAnsweredQuestionBatchQuery := []*proto.BatchQuery{
+		{
+			Cql: `delete FROM main.questions_by_user WHERE asked = ? AND question_id = ?;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: qAndA.Asked}},
+					&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQuestionUuid}},
+				},
+			},
+		},
+		{
+			Cql: `insert INTO main.q_and_a_users 
+					(asked , question_id , answer , asker , is_anon , question ) 
+					VALUES (?, ? ,?, ?, ?, ?);`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					{Inner: &proto.Value_String_{String_: qAndA.Asked}},
+					{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
+					{Inner: &proto.Value_String_{String_: qAndA.Answer}},
+					{Inner: &proto.Value_String_{String_: visibleAsker(qAndA.IsAnon, qAndA.Asker)}},
+					{Inner: &proto.Value_Boolean{Boolean: qAndA.IsAnon}},
+					{Inner: &proto.Value_String_{String_: qAndA.Question}},
+				},
 			},
 		},
-	})
-	if err != nil {
-		return models.QAndA{}, fmt.Errorf("failed to save the answer to the question %s", err)
 	}
 
-	qAndA.QuestionId = qAndAUuid
-	// qAndA.Asked =
+	// The answered question gets a new id, so the index of the asker and the protected asker have to follow it
+	insertAnsweredQuestionBatchQuery = append(insertAnsweredQuestionBatchQuery,
+		deleteFromQuestionsByAskerBatchQuery(cassandraCompliantQuestionUuid, qAndA.Asker),
+		questionsByAskerBatchQuery(cassandraCompliantQAndAUuid, models.Question{
+			Asked: qAndA.Asked, Asker: qAndA.Asker, IsAnon: qAndA.IsAnon, Question: qAndA.Question,
+		}, true),
+	)
+	if qAndA.IsAnon {
+		insertAnsweredQuestionBatchQuery = append(insertAnsweredQuestionBatchQuery,
+			deleteAnonymousAskerBatchQuery(cassandraCompliantQuestionUuid),
+			anonymousAskerBatchQuery(cassandraCompliantQAndAUuid, qAndA.Asker))
+	}
+	insertAnsweredQuestionBatchQuery = append(insertAnsweredQuestionBatchQuery, qAndAOwnerBatchQuery(cassandraCompliantQAndAUuid, qAndA.Asked))
+
+	_, err = cassandraClient.ExecuteBatch(&proto.Batch{Type: proto.Batch_LOGGED, Queries: insertAnsweredQuestionBatchQuery})
+	if err != nil {
+		return models.QAndA{}, fmt.Errorf("failed to save the answer to the question %s", err)
+	}
+
+	qAndA.QuestionId = qAndAUuid
+	if qAndA.IsAnon {
+		qAndA.Asker = ""
+	}
+	// qAndA.Asked =
 	return qAndA, nil
 }
 
@@ -358,19 +780,20 @@ func (c *CassandraQuestionsRepository) UpdateAnswer(ctx context.Context, qAndAUu
 	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
 	defer cassandraConnectionClient.Put(cassandraClient)
 
-	cassandraCompliantQAndAUuid, err := googleUuidToCassandraUuid(qAndA.QuestionId)
+	cassandraCompliantQAndAUuid, err := googleUuidToCassandraUuid(qAndAUuid)
 	if err != nil {
 		return models.QAndA{}, fmt.Errorf("failed to parse the id of the answer that needed to be updated %s", err)
 	}
 
-	deleteQAndAFromUserQuery := `UPDATE main.q_and_a_users SET answer = ? WHERE asked = ? AND question_id = ?;`
+	updateAnswerOfUserQuery := `UPDATE main.q_and_a_users SET answer = ? WHERE asked = ? AND question_id = ?;`
 	_, err = cassandraClient.ExecuteQuery(
 		&proto.Query{
-			Cql: deleteQAndAFromUserQuery,
+			Cql: updateAnswerOfUserQuery,
 			Values: &proto.Values{
 				Values: []*proto.Value{
-					{Inner: &proto.Value_String_{qAndA.Asked}},
-					{Inner: &proto.Value_Uuid{cassandraCompliantQAndAUuid}},
+					{Inner: &proto.Value_String_{String_: qAndA.Answer}},
+					{Inner: &proto.Value_String_{String_: qAndA.Asked}},
+					{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
 				},
 			},
 		},
@@ -382,6 +805,10 @@ func (c *CassandraQuestionsRepository) UpdateAnswer(ctx context.Context, qAndAUu
 	return qAndA, nil
 }
 
+/*
+ * Only the q&a of the asked user and the rows that point to it are deleted here, the copies in the homefeeds of the followers are deleted through
+ * DeleteAnswerFromFollowersHomefeed since they cannot be found without knowing the followers
+ */
 func (c *CassandraQuestionsRepository) DeleteQAndA(context context.Context, qAndA models.QAndA) error {
 	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
 	defer cassandraConnectionClient.Put(cassandraClient)
@@ -391,41 +818,381 @@ func (c *CassandraQuestionsRepository) DeleteQAndA(context context.Context, qAnd
 		return fmt.Errorf("failed to parse the id of the answer that needed to be deleted %s", err)
 	}
 
-	deleteQAndAFromUserQuery := `DELETE FROM main.q_and_a_users WHERE asked = ? AND question_id = ?;`
-	_, err = cassandraClient.ExecuteQuery(
-		&proto.Query{
-			Cql: deleteQAndAFromUserQuery,
+	deleteQAndABatchQuery := []*proto.BatchQuery{
+		{
+			Cql: `DELETE FROM main.q_and_a_users WHERE asked = ? AND question_id = ?;`,
 			Values: &proto.Values{
 				Values: []*proto.Value{
-					{Inner: &proto.Value_String_{qAndA.Asked}},
-					{Inner: &proto.Value_Uuid{cassandraCompliantQAndAUuid}},
+					{Inner: &proto.Value_String_{String_: qAndA.Asked}},
+					{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
 				},
 			},
 		},
-	)
+		deleteShareBatchQuery(cassandraCompliantQAndAUuid),
+		deleteQAndAOwnerBatchQuery(cassandraCompliantQAndAUuid),
+	}
+	deleteQAndABatchQuery, err = c.appendAskerCleanup(context, deleteQAndABatchQuery, cassandraCompliantQAndAUuid, qAndA.QuestionId, qAndA.IsAnon, qAndA.Asker)
+	if err != nil {
+		return fmt.Errorf("failed to delete the q&a %s", err)
+	}
+
+	_, err = cassandraClient.ExecuteBatchWithContext(&proto.Batch{Type: proto.Batch_LOGGED, Queries: deleteQAndABatchQuery}, context)
 	if err != nil {
 		return fmt.Errorf("failed to delete the q&a from the asked user table %s", err)
 	}
 
-	// Not sure if it is possible to say delete any of these followers
-	deleteQAndAFromFollowersTimelineQuery := `DELETE FROM main.q_and_a_followers WHERE follower = * AND question_id = ?;`
-	_, err = cassandraClient.ExecuteQuery(
-		&proto.Query{
-			Cql: deleteQAndAFromFollowersTimelineQuery,
+	return nil
+}
+
+func (c *CassandraQuestionsRepository) DeleteUnansweredQuestion(context context.Context, q models.Question) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantQuestionUuid, err := googleUuidToCassandraUuid(q.QuestionId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the question that needed to be deleted %s", err)
+	}
+
+	deleteQuestionBatchQuery := []*proto.BatchQuery{
+		{
+			Cql: `DELETE FROM main.questions_by_user WHERE asked = ? AND question_id = ?;`,
 			Values: &proto.Values{
 				Values: []*proto.Value{
-					{Inner: &proto.Value_Uuid{cassandraCompliantQAndAUuid}},
+					{Inner: &proto.Value_String_{String_: q.Asked}},
+					{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQuestionUuid}},
 				},
 			},
 		},
-	)
+	}
+	deleteQuestionBatchQuery, err = c.appendAskerCleanup(context, deleteQuestionBatchQuery, cassandraCompliantQuestionUuid, q.QuestionId, q.IsAnon, q.Asker)
+	if err != nil {
+		return fmt.Errorf("failed to delete the question %s", err)
+	}
+
+	_, err = cassandraClient.ExecuteBatchWithContext(&proto.Batch{Type: proto.Batch_LOGGED, Queries: deleteQuestionBatchQuery}, context)
+	if err != nil {
+		return fmt.Errorf("failed to delete the question from the asked user table %s", err)
+	}
+
+	return nil
+}
+
+/*
+ * Whenever a question or a q&a is deleted, it also has to leave the index of its asker and, if it was anonymous, the protected table. The asker of an
+ * anonymous question is not known by the caller so it is looked up here.
+ */
+func (c *CassandraQuestionsRepository) appendAskerCleanup(ctx context.Context, batchQuery []*proto.BatchQuery, cassandraCompliantUuid *proto.Uuid, questionId uuid.UUID, isAnon bool, asker string) ([]*proto.BatchQuery, error) {
+	if isAnon {
+		anonymousAsker, err := c.RevealAnonymousAsker(ctx, questionId)
+		if err != nil {
+			return nil, err
+		}
+		asker = anonymousAsker.Asker
+		batchQuery = append(batchQuery, deleteAnonymousAskerBatchQuery(cassandraCompliantUuid))
+	}
+	if asker != "" {
+		batchQuery = append(batchQuery, deleteFromQuestionsByAskerBatchQuery(cassandraCompliantUuid, asker))
+	}
+	return batchQuery, nil
+}
+
+/*
+ * The answers of a user all live in their partition of q_and_a_users, so they are counted there instead of keeping a counter that could drift from it
+ */
+func (c *CassandraQuestionsRepository) CountAnswersForUser(context context.Context, askedUser string) (int64, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT COUNT(*) FROM main.q_and_a_users WHERE asked = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: askedUser}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the answers of %s %s", askedUser, err)
+	}
+
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0].Values[0].GetInt(), nil
+}
+
+func (c *CassandraQuestionsRepository) GetAnsweredQuestionsForUser(context context.Context, askedUser string) ([]models.QAndA, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	if askedUser == "" {
+		return nil, fmt.Errorf("cannot fetch the answered questions for no one")
+	}
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT asked, question_id, answer, asker, is_anon, question FROM main.q_and_a_users WHERE asked = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_String_{String_: askedUser}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch answered questions for %s %s", askedUser, err)
+	}
+
+	answeredQuestions := []models.QAndA{}
+	for _, row := range res.GetResultSet().Rows {
+		answeredQuestions = append(answeredQuestions, qAndAFromRow(row))
+	}
+
+	return answeredQuestions, nil
+}
+
+// The most recent answers on the timeline of the follower, ordered by when their questions were asked
+func (c *CassandraQuestionsRepository) GetHomefeed(context context.Context, follower string, limit int) ([]models.QAndA, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	if follower == "" {
+		return nil, fmt.Errorf("cannot fetch the timeline of no one")
+	}
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT asked, question_id, answer, asker, is_anon, question FROM main.q_and_a_followers WHERE follower = ? ORDER BY question_id DESC LIMIT ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_String_{String_: follower}},
+				{Inner: &proto.Value_Int{Int: int64(limit)}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the timeline of %s %s", follower, err)
+	}
+
+	qAndAs := []models.QAndA{}
+	for _, row := range res.GetResultSet().Rows {
+		qAndAs = append(qAndAs, qAndAFromRow(row))
+	}
+	return qAndAs, nil
+}
+
+func (c *CassandraQuestionsRepository) GetQuestionsAskedByUser(context context.Context, asker string) ([]models.AskedQuestion, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	if asker == "" {
+		return nil, fmt.Errorf("cannot fetch the questions asked by no one")
+	}
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT question_id, asked, is_anon, question, answered FROM main.questions_by_asker WHERE asker = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_String_{String_: asker}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the questions asked by %s %s", asker, err)
+	}
+
+	askedQuestions := []models.AskedQuestion{}
+	for _, row := range res.GetResultSet().Rows {
+		parsedQuestionUuid, err := cassandraUuidToGoogleUuid(row.Values[0])
+		if err != nil {
+			log.Printf("failed to parse the uuid of one question %s\n ", err)
+		}
+		askedQuestions = append(askedQuestions, models.AskedQuestion{
+			QuestionId: parsedQuestionUuid,
+			Asked:      row.Values[1].GetString_(),
+			IsAnon:     row.Values[2].GetBoolean(),
+			Question:   row.Values[3].GetString_(),
+			Answered:   row.Values[4].GetBoolean(),
+		})
+	}
+
+	return askedQuestions, nil
+}
+
+func (c *CassandraQuestionsRepository) GetQAndA(context context.Context, asked string, qAndAUuid uuid.UUID) (models.QAndA, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantQAndAUuid, err := googleUuidToCassandraUuid(qAndAUuid)
+	if err != nil {
+		return models.QAndA{}, fmt.Errorf("failed to parse the id of the q&a %s", err)
+	}
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT asked, question_id, answer, asker, is_anon, question FROM main.q_and_a_users WHERE asked = ? AND question_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_String_{String_: asked}},
+				{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.QAndA{}, fmt.Errorf("failed to fetch the q&a %s %s", qAndAUuid, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.QAndA{}, ErrQuestionNotFound
+	}
+	return qAndAFromRow(rows[0]), nil
+}
+
+/*
+ * The q&a stays with the person who answered it, only the link to the asker is cut, it is shown from then on as any other anonymous question
+ */
+func (c *CassandraQuestionsRepository) AnonymizeAsker(context context.Context, asked string, qAndAUuid uuid.UUID) (models.QAndA, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantQAndAUuid, err := googleUuidToCassandraUuid(qAndAUuid)
+	if err != nil {
+		return models.QAndA{}, fmt.Errorf("failed to anonymize the asker of the q&a %s", err)
+	}
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT asked, question_id, answer, asker, is_anon, question FROM main.q_and_a_users WHERE asked = ? AND question_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_String_{String_: asked}},
+				{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.QAndA{}, fmt.Errorf("failed to anonymize the asker of the q&a %s", err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.QAndA{}, ErrQuestionNotFound
+	}
+	qAndA := qAndAFromRow(rows[0])
+	qAndA.Asker = ""
+	qAndA.IsAnon = true
+
+	_, err = cassandraClient.ExecuteBatchWithContext(&proto.Batch{
+		Type: proto.Batch_LOGGED,
+		Queries: []*proto.BatchQuery{
+			{
+				Cql: `UPDATE main.q_and_a_users SET asker = ?, is_anon = ? WHERE asked = ? AND question_id = ?;`,
+				Values: &proto.Values{
+					Values: []*proto.Value{
+						{Inner: &proto.Value_String_{String_: ""}},
+						{Inner: &proto.Value_Boolean{Boolean: true}},
+						{Inner: &proto.Value_String_{String_: asked}},
+						{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
+					},
+				},
+			},
+			deleteAnonymousAskerBatchQuery(cassandraCompliantQAndAUuid),
+		},
+	}, context)
+	if err != nil {
+		return models.QAndA{}, fmt.Errorf("failed to anonymize the asker of the q&a %s", err)
+	}
+
+	return qAndA, nil
+}
+
+func (c *CassandraQuestionsRepository) DeleteQuestionsAskedByUser(context context.Context, asker string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `DELETE FROM main.questions_by_asker WHERE asker = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_String_{String_: asker}},
+			},
+		},
+	}, context)
 	if err != nil {
-		return fmt.Errorf("failed to delete the q&a from the follower of the asked user table %s", err)
+		return fmt.Errorf("failed to delete the questions asked by %s %s", asker, err)
 	}
+	return nil
+}
+
+func (c *CassandraQuestionsRepository) DeleteHomefeed(context context.Context, follower string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
 
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `DELETE FROM main.q_and_a_followers WHERE follower = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_String_{String_: follower}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to delete the homefeed of %s %s", follower, err)
+	}
 	return nil
 }
 
+func (c *CassandraQuestionsRepository) GetUnansweredQuestion(ctx context.Context, asked string, questionId uuid.UUID) (models.Question, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	return c.getUnansweredQuestion(ctx, cassandraClient, asked, questionId)
+}
+
+func (c *CassandraQuestionsRepository) getUnansweredQuestion(ctx context.Context, cassandraClient *client.StargateClient, asked string, questionId uuid.UUID) (models.Question, error) {
+	cassandraCompliantUuid, err := googleUuidToCassandraUuid(questionId)
+	if err != nil {
+		return models.Question{}, err
+	}
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT asked, question_id, asker, is_anon, question FROM main.questions_by_user WHERE asked = ? AND question_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_String_{String_: asked}},
+				{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantUuid}},
+			},
+		},
+	}, ctx)
+	if err != nil {
+		return models.Question{}, fmt.Errorf("failed to fetch the question with id %s %s", questionId, err)
+	}
+
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.Question{}, ErrQuestionNotFound
+	}
+	return models.Question{
+		QuestionId: questionId,
+		Asked:      rows[0].Values[0].GetString_(),
+		Asker:      rows[0].Values[2].GetString_(),
+		IsAnon:     rows[0].Values[3].GetBoolean(),
+		Question:   rows[0].Values[4].GetString_(),
+	}, nil
+}
+
+// The row is expected to hold asked, question_id, answer, asker, is_anon and question in that order
+func qAndAFromRow(row *proto.Row) models.QAndA {
+	parsedQAndAUuid, err := cassandraUuidToGoogleUuid(row.Values[1])
+	if err != nil {
+		log.Printf("failed to parse the uuid of one q&a %s\n ", err)
+	}
+	return models.QAndA{
+		QuestionId: parsedQAndAUuid,
+		Asked:      row.Values[0].GetString_(),
+		Answer:     row.Values[2].GetString_(),
+		Asker:      row.Values[3].GetString_(),
+		IsAnon:     row.Values[4].GetBoolean(),
+		Question:   row.Values[5].GetString_(),
+		AnsweredOn: time.Unix(parsedQAndAUuid.Time().UnixTime()),
+	}
+}
+
 func NewCassandraLikesRepository() CassandraLikesRepository {
 	return CassandraLikesRepository{}
 }
@@ -451,7 +1218,7 @@ func (c *CassandraLikesRepository) GetLikesForQAndA(ctx context.Context, qAndAId
 		Cql: `SELECT * FROM main.q_and_a_likes WHERE question_id = ?`,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_Uuid{cassandraCompliantUuid}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantUuid}},
 			},
 		},
 	}
@@ -487,7 +1254,7 @@ func (c *CassandraLikesRepository) CreateLikesEntryForQAndA(context context.Cont
 		Cql: addQAndAToLikesQuery,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				{Inner: &proto.Value_Uuid{cassandraCompliantQAndAUuid}},
+				{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
 			},
 		},
 	})
@@ -555,7 +1322,7 @@ func (c *CassandraLikesRepository) DeleteQAndA(ctx context.Context, qAndAUuid uu
 			Cql: deleteQAndAQuery,
 			Values: &proto.Values{
 				Values: []*proto.Value{
-					&proto.Value{Inner: &proto.Value_Uuid{cassandraCompliantQAndAUuid}},
+					&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
 				},
 			},
 		},
@@ -585,13 +1352,13 @@ func (c *CassandraQuestionsRepository) PostAnswerToFollowersHomefeed(context con
 				   (? , ?, ?, ?, ? , ?, ?);`,
 			Values: &proto.Values{
 				Values: []*proto.Value{
-					&proto.Value{Inner: &proto.Value_String_{u.Username}},
-					&proto.Value{Inner: &proto.Value_Uuid{cassandraCompliantUuid}},
-					&proto.Value{Inner: &proto.Value_String_{qAndA.Answer}},
-					&proto.Value{Inner: &proto.Value_String_{qAndA.Asked}},
-					&proto.Value{Inner: &proto.Value_String_{qAndA.Asker}},
-					&proto.Value{Inner: &proto.Value_Boolean{qAndA.IsAnon}},
-					&proto.Value{Inner: &proto.Value_String_{qAndA.Question}},
+					&proto.Value{Inner: &proto.Value_String_{String_: u.Username}},
+					&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantUuid}},
+					&proto.Value{Inner: &proto.Value_String_{String_: qAndA.Answer}},
+					&proto.Value{Inner: &proto.Value_String_{String_: qAndA.Asked}},
+					&proto.Value{Inner: &proto.Value_String_{String_: visibleAsker(qAndA.IsAnon, qAndA.Asker)}},
+					&proto.Value{Inner: &proto.Value_Boolean{Boolean: qAndA.IsAnon}},
+					&proto.Value{Inner: &proto.Value_String_{String_: qAndA.Question}},
 				},
 			},
 		})
@@ -609,149 +1376,929 @@ func (c *CassandraQuestionsRepository) PostAnswerToFollowersHomefeed(context con
 	return nil
 }
 
-func updateLikesQuery(action LikeAction, uuid uuid.UUID) (*proto.Query, error) {
-	var q string
-	cassandraCompliantUuid, err := googleUuidToCassandraUuid(uuid)
-	if err != nil {
-		return nil, err
-	}
+/*
+ * Only moderators investigating abuse should end up here, the routers guard the endpoint that uses it
+ */
+func (c *CassandraQuestionsRepository) RevealAnonymousAsker(ctx context.Context, questionId uuid.UUID) (models.AnonymousAsker, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
 
-	switch action {
-	case Like:
-		q = `UPDATE main.q_and_a_likes SET likes = likes + 1 WHERE question_id  = ?;`
-	case Dislike:
-		q = `UPDATE main.q_and_a_likes SET likes = likes - 1 WHERE question_id  = ?;`
+	cassandraCompliantUuid, err := googleUuidToCassandraUuid(questionId)
+	if err != nil {
+		return models.AnonymousAsker{}, fmt.Errorf("failed to reveal the asker of question with id %s %s", questionId, err)
 	}
 
-	return &proto.Query{
-		Cql: q,
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT asker FROM main.anonymous_askers_by_question WHERE question_id = ?;`,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_Uuid{cassandraCompliantUuid}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantUuid}},
 			},
 		},
-	}, nil
-}
-
-func cassandraUuidToGoogleUuid(v *proto.Value) (uuid.UUID, error) {
-	id := v.GetUuid().Value
-	parsedQuestionUuid, err := uuid.FromBytes(id)
+	}, ctx)
 	if err != nil {
-		return uuid.UUID{}, fmt.Errorf("failed to parse the uuid of one question %s\n ", err)
+		return models.AnonymousAsker{}, fmt.Errorf("failed to reveal the asker of question with id %s %s", questionId, err)
 	}
-	return parsedQuestionUuid, nil
-}
 
-func googleUuidToCassandraUuid(id uuid.UUID) (*proto.Uuid, error) {
-	generatedQuestionIdInBytes, err := id.MarshalBinary()
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert the google id to cassandra id %s", err)
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.AnonymousAsker{}, fmt.Errorf("no anonymous asker was recorded for question with id %s", questionId)
 	}
-	cassandraCompliantQuestionUuid := &proto.Uuid{Value: generatedQuestionIdInBytes}
-	return cassandraCompliantQuestionUuid, nil
-}
-
-func NewCassandraUsersRepository() UsersRepository {
-	return &CassandraUsersRepository{}
-}
-
-type CassandraUsersRepository struct {
-}
 
-func (c *CassandraUsersRepository) DoesUserExist(context context.Context, u models.User) (bool, error) {
-	panic("not implemented") // TODO: implement
+	return models.AnonymousAsker{QuestionId: questionId, Asker: rows[0].Values[0].GetString_()}, nil
 }
 
-func (c *CassandraUsersRepository) Register(context context.Context, u models.User) (models.User, error) {
+/*
+ * Looks up the askers of many anonymous questions at once, questions without a recorded asker are left out of the map. Meant for checks on the askers,
+ * the askers must never be handed to the users who received the questions.
+ */
+func (c *CassandraQuestionsRepository) RevealAnonymousAskers(ctx context.Context, questionIds []uuid.UUID) (map[uuid.UUID]string, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
 
-	err := utils.ValidateRegistration(u)
-	if err != nil {
-		return models.User{}, fmt.Errorf("failed to register user %s", err)
+	askers := map[uuid.UUID]string{}
+	if len(questionIds) == 0 {
+		return askers, nil
+	}
+
+	placeholders := make([]string, 0, len(questionIds))
+	cassandraCompliantUuids := make([]*proto.Value, 0, len(questionIds))
+	for _, id := range questionIds {
+		cassandraCompliantUuid, err := googleUuidToCassandraUuid(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reveal the askers of the anonymous questions %s", err)
+		}
+		placeholders = append(placeholders, "?")
+		cassandraCompliantUuids = append(cassandraCompliantUuids, &proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantUuid}})
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql:    fmt.Sprintf(`SELECT question_id, asker FROM main.anonymous_askers_by_question WHERE question_id IN (%s);`, strings.Join(placeholders, ", ")),
+		Values: &proto.Values{Values: cassandraCompliantUuids},
+	}, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reveal the askers of the anonymous questions %s", err)
+	}
+	for _, row := range res.GetResultSet().Rows {
+		questionId, err := cassandraUuidToGoogleUuid(row.Values[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to reveal the askers of the anonymous questions %s", err)
+		}
+		askers[questionId] = row.Values[1].GetString_()
+	}
+	return askers, nil
+}
+
+func deleteAnonymousAskerBatchQuery(questionId *proto.Uuid) *proto.BatchQuery {
+	return &proto.BatchQuery{
+		Cql: `DELETE FROM main.anonymous_askers_by_question WHERE question_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: questionId}},
+			},
+		},
+	}
+}
+
+func questionsByAskerBatchQuery(questionId *proto.Uuid, q models.Question, answered bool) *proto.BatchQuery {
+	return &proto.BatchQuery{
+		Cql: `INSERT INTO main.questions_by_asker (asker, question_id, asked, is_anon, question, answered) VALUES (?, ?, ?, ?, ?, ?);`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: q.Asker}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: questionId}},
+				&proto.Value{Inner: &proto.Value_String_{String_: q.Asked}},
+				&proto.Value{Inner: &proto.Value_Boolean{Boolean: q.IsAnon}},
+				&proto.Value{Inner: &proto.Value_String_{String_: q.Question}},
+				&proto.Value{Inner: &proto.Value_Boolean{Boolean: answered}},
+			},
+		},
+	}
+}
+
+func deleteFromQuestionsByAskerBatchQuery(questionId *proto.Uuid, asker string) *proto.BatchQuery {
+	return &proto.BatchQuery{
+		Cql: `DELETE FROM main.questions_by_asker WHERE asker = ? AND question_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: asker}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: questionId}},
+			},
+		},
+	}
+}
+
+func anonymousAskerBatchQuery(questionId *proto.Uuid, asker string) *proto.BatchQuery {
+	return &proto.BatchQuery{
+		Cql: `INSERT INTO main.anonymous_askers_by_question (question_id, asker) VALUES (?, ?);`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: questionId}},
+				&proto.Value{Inner: &proto.Value_String_{String_: asker}},
+			},
+		},
+	}
+}
+
+func qAndAOwnerBatchQuery(qAndAId *proto.Uuid, asked string) *proto.BatchQuery {
+	return &proto.BatchQuery{
+		Cql: `INSERT INTO main.q_and_a_owners (question_id, asked) VALUES (?, ?);`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_Uuid{Uuid: qAndAId}},
+				{Inner: &proto.Value_String_{String_: asked}},
+			},
+		},
+	}
+}
+
+func deleteQAndAOwnerBatchQuery(qAndAId *proto.Uuid) *proto.BatchQuery {
+	return &proto.BatchQuery{
+		Cql: `DELETE FROM main.q_and_a_owners WHERE question_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_Uuid{Uuid: qAndAId}},
+			},
+		},
+	}
+}
+
+/*
+ * The user who answered the q&a, ErrQuestionNotFound when there is no such q&a
+ */
+func (c *CassandraQuestionsRepository) GetQAndAOwner(ctx context.Context, qAndAId uuid.UUID) (string, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantQAndAUuid, err := googleUuidToCassandraUuid(qAndAId)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the id of the q&a %s", err)
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT asked FROM main.q_and_a_owners WHERE question_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
+			},
+		},
+	}, ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to find who answered the q&a %s %s", qAndAId, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return "", ErrQuestionNotFound
+	}
+	return rows[0].Values[0].GetString_(), nil
+}
+
+// The asker that is allowed to be written next to the question in any table that is read by the inbox or the timelines
+func visibleAsker(isAnon bool, asker string) string {
+	if isAnon {
+		return ""
+	}
+	return asker
+}
+
+func updateLikesQuery(action LikeAction, uuid uuid.UUID) (*proto.Query, error) {
+	var q string
+	cassandraCompliantUuid, err := googleUuidToCassandraUuid(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	switch action {
+	case Like:
+		q = `UPDATE main.q_and_a_likes SET likes = likes + 1 WHERE question_id  = ?;`
+	case Dislike:
+		q = `UPDATE main.q_and_a_likes SET likes = likes - 1 WHERE question_id  = ?;`
+	}
+
+	return &proto.Query{
+		Cql: q,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantUuid}},
+			},
+		},
+	}, nil
+}
+
+func cassandraUuidToGoogleUuid(v *proto.Value) (uuid.UUID, error) {
+	id := v.GetUuid().Value
+	parsedQuestionUuid, err := uuid.FromBytes(id)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("failed to parse the uuid of one question %s\n ", err)
+	}
+	return parsedQuestionUuid, nil
+}
+
+func googleUuidToCassandraUuid(id uuid.UUID) (*proto.Uuid, error) {
+	generatedQuestionIdInBytes, err := id.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the google id to cassandra id %s", err)
+	}
+	cassandraCompliantQuestionUuid := &proto.Uuid{Value: generatedQuestionIdInBytes}
+	return cassandraCompliantQuestionUuid, nil
+}
+
+func NewCassandraUsersRepository() UsersRepository {
+	return &CassandraUsersRepository{}
+}
+
+type CassandraUsersRepository struct {
+}
+
+func (c *CassandraUsersRepository) DoesUserExist(context context.Context, u models.User) (bool, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	if u.Username == "" {
+		return false, nil
+	}
+
+	doesUserExistQuery := `SELECT username FROM main.users WHERE username = ?;`
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: doesUserExistQuery,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: u.Username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if the user %s exists %s", u.Username, err)
+	}
+
+	return len(res.GetResultSet().Rows) > 0, nil
+}
+
+/*
+ * Registering a user will have multiple steps:
+ * 1) Reserve the email in users_by_email and the lowercased username in users_by_lowercase_username, the user is only created once nobody else holds
+ *    either of them.
+ * 2) Insert the user with a lightweight transaction, on its own since nothing can take it back once it is applied.
+ * 3) Initialize both of their counters and index them for the search, the writes are independent so they are sent in parallel and the first one to fail
+ *    cancels the other.
+ * 4) If anything failed after the email was reserved, the writes that went through are compensated, so a failed registration never leaves a half created
+ *    account behind. The counters are not compensated, they were only incremented by zero.
+ */
+func (c *CassandraUsersRepository) Register(ctx context.Context, u models.User) (models.User, error) {
+
+	err := utils.ValidateRegistration(u)
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to register user %w", err)
 	}
 
 	userCreationTimeUuid, err := uuid.NewUUID()
 	if err != nil {
-		return models.User{}, err
+		return models.User{}, err
+	}
+	cassandraCompliantUserCreationTimeUuid, err := googleUuidToCassandraUuid(userCreationTimeUuid)
+	if err != nil {
+		return models.User{}, err
+	}
+	hashedPassword, err := hashPassword(u.Password)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	registerUserQuery := &proto.Query{
+		Cql: `INSERT INTO main.users 
+				(username , created_on , email , first_name , last_name , password , email_verified ) 
+				VALUES (? , ? , ?, ?, ?, ?, false) IF NOT EXISTS;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: u.Username}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantUserCreationTimeUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: u.Email}},
+				&proto.Value{Inner: &proto.Value_String_{String_: u.FirstName}},
+				&proto.Value{Inner: &proto.Value_String_{String_: u.LastName}},
+				&proto.Value{Inner: &proto.Value_String_{String_: hashedPassword}},
+			},
+		},
+	}
+	setFollowersToZeroQuery := &proto.BatchQuery{
+		Cql: `UPDATE main.followers_of_user_counter SET followers = followers + 0 WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: u.Username}},
+			},
+		},
+	}
+	setFollowingToZeroQuery := &proto.BatchQuery{
+		Cql: `UPDATE main.following_by_user_counter SET following = following + 0 WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: u.Username}},
+			},
+		},
+	}
+
+	// Step 1 reserve the email, the user is only inserted once nobody else holds it
+	emailReserved, err := reserveEmail(cassandraClient, u.Email, u.Username)
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to save user in database %s", err)
+	}
+	if !emailReserved {
+		return models.User{}, ErrEmailTaken
+	}
+	usernameReserved, err := reserveUsername(cassandraClient, u.Username)
+	if err != nil {
+		releaseEmail(cassandraClient, u.Email, u.Username)
+		return models.User{}, fmt.Errorf("failed to save user in database %s", err)
+	}
+	if !usernameReserved {
+		releaseEmail(cassandraClient, u.Email, u.Username)
+		return models.User{}, ErrUsernameTaken
+	}
+
+	// Step 2 insert the user only if the username is not taken, an existing account must never be overwritten
+	res, err := cassandraClient.ExecuteQueryWithContext(registerUserQuery, ctx)
+	if err != nil {
+		// The insert may have been applied even though it failed, the row is only deleted if it carries our created_on
+		deleteRegisteredUser(cassandraClient, u.Username, cassandraCompliantUserCreationTimeUuid)
+		releaseUsername(cassandraClient, u.Username)
+		releaseEmail(cassandraClient, u.Email, u.Username)
+		return models.User{}, fmt.Errorf("failed to save user in database %s", err)
+	}
+	if !isApplied(res) {
+		releaseUsername(cassandraClient, u.Username)
+		releaseEmail(cassandraClient, u.Email, u.Username)
+		return models.User{}, ErrUsernameTaken
+	}
+
+	// Step 3 the username is ours, initialize the counters and index the user for the search
+	registrationGroup, registrationCtx := errgroup.WithContext(ctx)
+	registrationGroup.Go(func() error {
+		_, err := cassandraClient.ExecuteBatchWithContext(&proto.Batch{
+			Type: proto.Batch_COUNTER,
+			Queries: []*proto.BatchQuery{
+				setFollowersToZeroQuery,
+				setFollowingToZeroQuery,
+			},
+		}, registrationCtx)
+		if err != nil {
+			return fmt.Errorf("failed to initialize the counters of the user %s", err)
+		}
+		return nil
+	})
+	registrationGroup.Go(func() error {
+		_, err := cassandraClient.ExecuteBatchWithContext(&proto.Batch{
+			Type:    proto.Batch_UNLOGGED,
+			Queries: userPrefixesBatchQuery(u.Username, u.FirstName, u.LastName),
+		}, registrationCtx)
+		if err != nil {
+			return fmt.Errorf("failed to index the user for the search %s", err)
+		}
+		return nil
+	})
+
+	// Step 4 compensate if any of the writes failed, the prefixes are all of this user so they can be deleted even if only some were written
+	err = registrationGroup.Wait()
+	if err != nil {
+		deleteUserPrefixes(cassandraClient, u.Username, u.FirstName, u.LastName)
+		deleteRegisteredUser(cassandraClient, u.Username, cassandraCompliantUserCreationTimeUuid)
+		releaseUsername(cassandraClient, u.Username)
+		releaseEmail(cassandraClient, u.Email, u.Username)
+		return models.User{}, err
+	}
+
+	log.Printf("successfully registered the user %s at %s\n", u.Username, time.Now())
+	u.Password = ""
+	u.JoinedOn = time.Unix(userCreationTimeUuid.Time().UnixTime())
+	return u, nil
+}
+
+/*
+ * The user is only deleted if the row is still the one created by the failed registration, which is known by its created_on time uuid
+ */
+func deleteRegisteredUser(cassandraClient *client.StargateClient, username string, createdOn *proto.Uuid) {
+	_, err := cassandraClient.ExecuteQuery(&proto.Query{
+		Cql: `DELETE FROM main.users WHERE username = ? IF created_on = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: createdOn}},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("failed to delete the half registered user %s %s\n", username, err)
+	}
+}
+
+func reserveEmail(cassandraClient *client.StargateClient, email string, username string) (bool, error) {
+	res, err := cassandraClient.ExecuteQuery(&proto.Query{
+		Cql: `INSERT INTO main.users_by_email (email, username) VALUES (?, ?) IF NOT EXISTS;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: normalizeEmail(email)}},
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve the email %s %s", email, err)
+	}
+	return isApplied(res), nil
+}
+
+/*
+ * The email is only released if it is still held by the same username, so a failed registration can never free the email of someone else
+ */
+func releaseEmail(cassandraClient *client.StargateClient, email string, username string) {
+	_, err := cassandraClient.ExecuteQuery(&proto.Query{
+		Cql: `DELETE FROM main.users_by_email WHERE email = ? IF username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: normalizeEmail(email)}},
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("failed to release the email reserved by %s %s\n", username, err)
+	}
+}
+
+func reserveUsername(cassandraClient *client.StargateClient, username string) (bool, error) {
+	res, err := cassandraClient.ExecuteQuery(&proto.Query{
+		Cql: `INSERT INTO main.users_by_lowercase_username (lowercase_username, username) VALUES (?, ?) IF NOT EXISTS;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: strings.ToLower(username)}},
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve the username %s %s", username, err)
+	}
+	return isApplied(res), nil
+}
+
+// Like releaseEmail, a look-alike username held by someone else is never freed
+func releaseUsername(cassandraClient *client.StargateClient, username string) {
+	_, err := cassandraClient.ExecuteQuery(&proto.Query{
+		Cql: `DELETE FROM main.users_by_lowercase_username WHERE lowercase_username = ? IF username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: strings.ToLower(username)}},
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("failed to release the username reserved by %s %s\n", username, err)
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Lightweight transactions answer with a row whose first column is [applied]
+func isApplied(res *proto.Response) bool {
+	rows := res.GetResultSet().GetRows()
+	if len(rows) == 0 || len(rows[0].Values) == 0 {
+		return false
+	}
+	return rows[0].Values[0].GetBoolean()
+}
+
+/*
+ * Checks the password of the user and hands the user back, roles included, without the password. An unknown user and a wrong password are told apart
+ * here, the caller should not tell the client which one it was.
+ */
+func (c *CassandraUsersRepository) Login(context context.Context, u models.User) (models.User, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT password FROM main.users WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: u.Username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to fetch the password of %s %s", u.Username, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.User{}, ErrUserNotFound
+	}
+	if !passwordMatches(rows[0].Values[0].GetString_(), u.Password) {
+		return models.User{}, ErrWrongPassword
+	}
+	return c.GetUser(context, u.Username)
+}
+
+/*
+ * Deletes the account itself and frees its email and username, the rest of what the user left behind (questions, answers, follows) is erased by the erasure job
+ */
+func (c *CassandraUsersRepository) Delete(context context.Context, u models.User) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT email, pending_email, first_name, last_name FROM main.users WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: u.Username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the user %s before deleting them %s", u.Username, err)
+	}
+
+	deleteUserBatchQuery := []*proto.BatchQuery{
+		{
+			Cql: `DELETE FROM main.users WHERE username = ?;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: u.Username}},
+				},
+			},
+		},
+		{
+			Cql: `DELETE FROM main.users_by_lowercase_username WHERE lowercase_username = ?;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: strings.ToLower(u.Username)}},
+				},
+			},
+		},
+	}
+	// Both the email and the one waiting to be verified are held in users_by_email
+	for _, row := range res.GetResultSet().Rows {
+		for _, email := range []string{row.Values[0].GetString_(), row.Values[1].GetString_()} {
+			if email == "" {
+				continue
+			}
+			deleteUserBatchQuery = append(deleteUserBatchQuery, &proto.BatchQuery{
+				Cql: `DELETE FROM main.users_by_email WHERE email = ?;`,
+				Values: &proto.Values{
+					Values: []*proto.Value{
+						&proto.Value{Inner: &proto.Value_String_{String_: normalizeEmail(email)}},
+					},
+				},
+			})
+		}
+		deleteUserBatchQuery = append(deleteUserBatchQuery,
+			deleteUserPrefixesBatchQuery(u.Username, utils.SearchPrefixes(u.Username, row.Values[2].GetString_(), row.Values[3].GetString_()))...)
+	}
+
+	_, err = cassandraClient.ExecuteBatchWithContext(&proto.Batch{Type: proto.Batch_LOGGED, Queries: deleteUserBatchQuery}, context)
+	if err != nil {
+		return fmt.Errorf("failed to delete the user %s %s", u.Username, err)
 	}
-	cassandraCompliantUserCreationTimeUuid, err := googleUuidToCassandraUuid(userCreationTimeUuid)
+	return nil
+}
+
+/*
+ * Counter tables only allow their rows to be deleted as a whole, this is only meant to be used once the user is gone and both counters are back to zero
+ */
+func (c *CassandraUsersRepository) DeleteCounters(context context.Context, username string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	for _, q := range []string{
+		`DELETE FROM main.followers_of_user_counter WHERE username = ?;`,
+		`DELETE FROM main.following_by_user_counter WHERE username = ?;`,
+	} {
+		_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+			Cql: q,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				},
+			},
+		}, context)
+		if err != nil {
+			return fmt.Errorf("failed to delete the counters of %s %s", username, err)
+		}
+	}
+	return nil
+}
+
+/*
+ * Updates the first and last name of the user and stages a new email, any of them left empty keeps its current value. The new email is not used until
+ * it is verified, until then it is only reserved in users_by_email so that nobody else can register with it, and kept in pending_email:
+ * 1) A new email is reserved, and the email that was pending before it is released.
+ * 2) Sending the current email again cancels the pending one.
+ * 3) The user is updated, if that fails the email that was just reserved is released again.
+ */
+func (c *CassandraUsersRepository) UpdateLoginDetails(context context.Context, u models.User) (models.User, error) {
+	current, err := c.GetUser(context, u.Username)
 	if err != nil {
 		return models.User{}, err
 	}
-	// TODO: Password hashing?
-	// hashedPassword := bcrypt.GenerateFromPassword()
 
 	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
 	defer cassandraConnectionClient.Put(cassandraClient)
 
-	registerUserQuery := &proto.Query{
-		Cql: `INSERT INTO main.users 
-				(username , created_on , email , first_name , last_name , password ) 
-				VALUES (? , ? , ?, ?, ?, ?);`,
+	updated := current
+	if u.FirstName != "" {
+		updated.FirstName = u.FirstName
+	}
+	if u.LastName != "" {
+		updated.LastName = u.LastName
+	}
+
+	reservedEmail, releasedEmail := "", ""
+	switch newEmail := normalizeEmail(u.Email); {
+	case newEmail == "" || newEmail == normalizeEmail(current.PendingEmail):
+	case newEmail == normalizeEmail(current.Email):
+		releasedEmail = current.PendingEmail
+		updated.PendingEmail = ""
+	default:
+		emailReserved, err := reserveEmail(cassandraClient, u.Email, u.Username)
+		if err != nil {
+			return models.User{}, err
+		}
+		if !emailReserved {
+			return models.User{}, ErrEmailTaken
+		}
+		reservedEmail, releasedEmail = u.Email, current.PendingEmail
+		updated.PendingEmail = u.Email
+	}
+
+	pendingEmailValue := &proto.Value{Inner: &proto.Value_Null_{Null: &proto.Value_Null{}}}
+	if updated.PendingEmail != "" {
+		pendingEmailValue = &proto.Value{Inner: &proto.Value_String_{String_: updated.PendingEmail}}
+	}
+	_, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `UPDATE main.users SET first_name = ?, last_name = ?, pending_email = ? WHERE username = ?;`,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_String_{u.Username}},
-				&proto.Value{Inner: &proto.Value_Uuid{cassandraCompliantUserCreationTimeUuid}},
-				&proto.Value{Inner: &proto.Value_String_{u.Email}},
-				&proto.Value{Inner: &proto.Value_String_{u.FirstName}},
-				&proto.Value{Inner: &proto.Value_String_{u.LastName}},
-				&proto.Value{Inner: &proto.Value_String_{u.Password}},
+				&proto.Value{Inner: &proto.Value_String_{String_: updated.FirstName}},
+				&proto.Value{Inner: &proto.Value_String_{String_: updated.LastName}},
+				pendingEmailValue,
+				&proto.Value{Inner: &proto.Value_String_{String_: u.Username}},
 			},
 		},
+	}, context)
+	if err != nil {
+		if reservedEmail != "" {
+			releaseEmail(cassandraClient, reservedEmail, u.Username)
+		}
+		return models.User{}, fmt.Errorf("failed to update the login details of %s %s", u.Username, err)
 	}
-	setFollowersToZeroQuery := &proto.BatchQuery{
-		Cql: `UPDATE main.followers_of_user_counter SET followers = followers + 0 WHERE username = ?;`,
+
+	if releasedEmail != "" {
+		releaseEmail(cassandraClient, releasedEmail, u.Username)
+	}
+
+	if updated.FirstName != current.FirstName || updated.LastName != current.LastName {
+		err = reindexUserPrefixes(context, cassandraClient, current, updated)
+		if err != nil {
+			return models.User{}, err
+		}
+	}
+	return updated, nil
+}
+
+/*
+ * A delete and an insert of the same row in one batch share a timestamp and the delete would win, so only the prefixes that the new names no longer
+ * have are deleted and all the new ones are upserted
+ */
+func reindexUserPrefixes(context context.Context, cassandraClient *client.StargateClient, current models.User, updated models.User) error {
+	newPrefixes := map[string]bool{}
+	for _, prefix := range utils.SearchPrefixes(updated.Username, updated.FirstName, updated.LastName) {
+		newPrefixes[prefix] = true
+	}
+	stalePrefixes := []string{}
+	for _, prefix := range utils.SearchPrefixes(current.Username, current.FirstName, current.LastName) {
+		if !newPrefixes[prefix] {
+			stalePrefixes = append(stalePrefixes, prefix)
+		}
+	}
+
+	reindexBatchQuery := append(deleteUserPrefixesBatchQuery(updated.Username, stalePrefixes),
+		userPrefixesBatchQuery(updated.Username, updated.FirstName, updated.LastName)...)
+	_, err := cassandraClient.ExecuteBatchWithContext(&proto.Batch{Type: proto.Batch_LOGGED, Queries: reindexBatchQuery}, context)
+	if err != nil {
+		return fmt.Errorf("failed to update the search index of %s %s", updated.Username, err)
+	}
+	return nil
+}
+
+func userPrefixesBatchQuery(username string, firstName string, lastName string) []*proto.BatchQuery {
+	queries := []*proto.BatchQuery{}
+	for _, prefix := range utils.SearchPrefixes(username, firstName, lastName) {
+		queries = append(queries, &proto.BatchQuery{
+			Cql: `INSERT INTO main.users_by_prefix (prefix, username, first_name, last_name) VALUES (?, ?, ?, ?);`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: prefix}},
+					&proto.Value{Inner: &proto.Value_String_{String_: username}},
+					&proto.Value{Inner: &proto.Value_String_{String_: firstName}},
+					&proto.Value{Inner: &proto.Value_String_{String_: lastName}},
+				},
+			},
+		})
+	}
+	return queries
+}
+
+func deleteUserPrefixesBatchQuery(username string, prefixes []string) []*proto.BatchQuery {
+	queries := []*proto.BatchQuery{}
+	for _, prefix := range prefixes {
+		queries = append(queries, &proto.BatchQuery{
+			Cql: `DELETE FROM main.users_by_prefix WHERE prefix = ? AND username = ?;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: prefix}},
+					&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				},
+			},
+		})
+	}
+	return queries
+}
+
+func deleteUserPrefixes(cassandraClient *client.StargateClient, username string, firstName string, lastName string) {
+	_, err := cassandraClient.ExecuteBatch(&proto.Batch{
+		Type:    proto.Batch_UNLOGGED,
+		Queries: deleteUserPrefixesBatchQuery(username, utils.SearchPrefixes(username, firstName, lastName)),
+	})
+	if err != nil {
+		log.Printf("failed to remove the half registered user %s from the search %s\n", username, err)
+	}
+}
+
+/*
+ * The current password has to match before it is replaced, the repository is the only place that ever sees the hash of the password
+ */
+func (c *CassandraUsersRepository) UpdatePassword(context context.Context, username string, currentPassword string, newPassword string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT password FROM main.users WHERE username = ?;`,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_String_{u.Username}},
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
 			},
 		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the password of %s %s", username, err)
 	}
-	setFollowingToZeroQuery := &proto.BatchQuery{
-		Cql: `UPDATE main.followed_by_user_counter SET followering = followering + 0 WHERE username = ?;`,
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return ErrUserNotFound
+	}
+	storedPassword := rows[0].Values[0].GetString_()
+	if !passwordMatches(storedPassword, currentPassword) {
+		return ErrWrongPassword
+	}
+
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	// The password is only replaced if nobody changed it since it was checked
+	res, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `UPDATE main.users SET password = ? WHERE username = ? IF password = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: hashedPassword}},
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				&proto.Value{Inner: &proto.Value_String_{String_: storedPassword}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to update the password of %s %s", username, err)
+	}
+	if !isApplied(res) {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+/*
+ * Replaces the password without knowing the current one, the caller must already have proven the user owns the account, such as with a reset token
+ */
+func (c *CassandraUsersRepository) ResetPassword(context context.Context, username string, newPassword string) error {
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `UPDATE main.users SET password = ? WHERE username = ? IF EXISTS;`,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_String_{u.Username}},
+				&proto.Value{Inner: &proto.Value_String_{String_: hashedPassword}},
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
 			},
 		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to reset the password of %s %s", username, err)
 	}
+	if !isApplied(res) {
+		return ErrUserNotFound
+	}
+	return nil
+}
 
-	//TODO: Use cancel here with context if any of them fail
-	go func() {
-		_, err := cassandraClient.ExecuteQuery(registerUserQuery)
+/*
+ * Marks the email as verified, the email has to be one the user still holds:
+ * 1) If it is the current email of the user it is only marked as verified.
+ * 2) If it is the pending email it replaces the current one, which is then released so that others can register with it.
+ * 3) Any other email was replaced in the meantime and the token that proved it is no longer valid.
+ */
+func (c *CassandraUsersRepository) VerifyEmail(context context.Context, username string, email string) error {
+	current, err := c.GetUser(context, username)
+	if err != nil {
+		return err
+	}
+
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	switch normalizeEmail(email) {
+	case normalizeEmail(current.Email):
+		_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+			Cql: `UPDATE main.users SET email_verified = true WHERE username = ?;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				},
+			},
+		}, context)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("failed to verify the email of %s %s", username, err)
 		}
-	}()
-
-	//TODO: Use cancel here with context if any of them fail
-	go func() {
-		_, err := cassandraClient.ExecuteBatch(&proto.Batch{
-			Type: proto.Batch_COUNTER,
-			Queries: []*proto.BatchQuery{
-				setFollowersToZeroQuery,
-				setFollowingToZeroQuery,
+		return nil
+	case normalizeEmail(current.PendingEmail):
+		res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+			Cql: `UPDATE main.users SET email = ?, pending_email = null, email_verified = true WHERE username = ? IF pending_email = ?;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: current.PendingEmail}},
+					&proto.Value{Inner: &proto.Value_String_{String_: username}},
+					&proto.Value{Inner: &proto.Value_String_{String_: current.PendingEmail}},
+				},
 			},
-		})
+		}, context)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("failed to verify the email of %s %s", username, err)
 		}
-	}()
-	if err != nil {
-		return models.User{}, fmt.Errorf("failed to save user in database %s", err)
+		if !isApplied(res) {
+			return ErrTokenNotFound
+		}
+		releaseEmail(cassandraClient, current.Email, username)
+		return nil
+	default:
+		return ErrTokenNotFound
 	}
-	return u, nil
 }
 
-func (c *CassandraUsersRepository) Login(_ context.Context, _ models.User) (models.User, error) {
-	panic("not implemented") // TODO: Implement
+func (c *CassandraUsersRepository) FindUsernameByEmail(context context.Context, email string) (string, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT username FROM main.users_by_email WHERE email = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: normalizeEmail(email)}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the user with the email %s %s", email, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return "", ErrUserNotFound
+	}
+	return rows[0].Values[0].GetString_(), nil
 }
 
-func (c *CassandraUsersRepository) Delete(_ context.Context, _ models.User) error {
-	panic("not implemented") // TODO: Implement
+func hashPassword(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash the password %s", err)
+	}
+	return string(hashedPassword), nil
 }
 
-func (c *CassandraUsersRepository) UpdateLoginDetails(_ context.Context, _ models.User) (models.User, error) {
-	panic("not implemented") // TODO: Implement
+func passwordMatches(hashedPassword string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
 }
 
 func (c *CassandraUsersRepository) Follow(context context.Context, follower string, followed string) error {
@@ -760,11 +2307,13 @@ func (c *CassandraUsersRepository) Follow(context context.Context, follower stri
 
 	followUserQuery := `INSERT INTO main.followers_by_user (followed, follower) 
 						VALUES (?, ?);`
+	followingUserQuery := `INSERT INTO main.following_by_user (follower, followed) 
+						VALUES (?, ?);`
 	incrementFollowersTableBatchQuery := &proto.BatchQuery{
 		Cql: `UPDATE main.followers_of_user_counter SET followers = followers + 1 WHERE username = ?;`,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_String_{followed}},
+				&proto.Value{Inner: &proto.Value_String_{String_: followed}},
 			},
 		},
 	}
@@ -772,17 +2321,32 @@ func (c *CassandraUsersRepository) Follow(context context.Context, follower stri
 		Cql: `UPDATE main.following_by_user_counter SET following = following + 1 WHERE username = ?;`,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_String_{follower}},
+				&proto.Value{Inner: &proto.Value_String_{String_: follower}},
 			},
 		},
 	}
 
-	_, err := cassandraClient.ExecuteQuery(&proto.Query{
-		Cql: followUserQuery,
-		Values: &proto.Values{
-			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_String_{followed}},
-				&proto.Value{Inner: &proto.Value_String_{follower}},
+	// Both sides of the relationship are written together so that followers_by_user and following_by_user never disagree
+	_, err := cassandraClient.ExecuteBatch(&proto.Batch{
+		Type: proto.Batch_LOGGED,
+		Queries: []*proto.BatchQuery{
+			{
+				Cql: followUserQuery,
+				Values: &proto.Values{
+					Values: []*proto.Value{
+						&proto.Value{Inner: &proto.Value_String_{String_: followed}},
+						&proto.Value{Inner: &proto.Value_String_{String_: follower}},
+					},
+				},
+			},
+			{
+				Cql: followingUserQuery,
+				Values: &proto.Values{
+					Values: []*proto.Value{
+						&proto.Value{Inner: &proto.Value_String_{String_: follower}},
+						&proto.Value{Inner: &proto.Value_String_{String_: followed}},
+					},
+				},
 			},
 		},
 	})
@@ -808,13 +2372,15 @@ func (c *CassandraUsersRepository) Unfollow(context context.Context, follower st
 	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
 	defer cassandraConnectionClient.Put(cassandraClient)
 
-	followUserQuery := `DELETE from followers_by_user 
+	followUserQuery := `DELETE from main.followers_by_user 
 						WHERE followed = ? AND follower = ?;`
+	followingUserQuery := `DELETE from main.following_by_user 
+						WHERE follower = ? AND followed = ?;`
 	incrementFollowersTableBatchQuery := &proto.BatchQuery{
 		Cql: `UPDATE main.followers_of_user_counter SET followers = followers - 1 WHERE username = ?;`,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_String_{followed}},
+				&proto.Value{Inner: &proto.Value_String_{String_: followed}},
 			},
 		},
 	}
@@ -822,17 +2388,32 @@ func (c *CassandraUsersRepository) Unfollow(context context.Context, follower st
 		Cql: `UPDATE main.following_by_user_counter SET following = following - 1 WHERE username = ?;`,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_String_{follower}},
+				&proto.Value{Inner: &proto.Value_String_{String_: follower}},
 			},
 		},
 	}
 
-	_, err := cassandraClient.ExecuteQuery(&proto.Query{
-		Cql: followUserQuery,
-		Values: &proto.Values{
-			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_String_{followed}},
-				&proto.Value{Inner: &proto.Value_String_{follower}},
+	// Both sides of the relationship are written together so that followers_by_user and following_by_user never disagree
+	_, err := cassandraClient.ExecuteBatch(&proto.Batch{
+		Type: proto.Batch_LOGGED,
+		Queries: []*proto.BatchQuery{
+			{
+				Cql: followUserQuery,
+				Values: &proto.Values{
+					Values: []*proto.Value{
+						&proto.Value{Inner: &proto.Value_String_{String_: followed}},
+						&proto.Value{Inner: &proto.Value_String_{String_: follower}},
+					},
+				},
+			},
+			{
+				Cql: followingUserQuery,
+				Values: &proto.Values{
+					Values: []*proto.Value{
+						&proto.Value{Inner: &proto.Value_String_{String_: follower}},
+						&proto.Value{Inner: &proto.Value_String_{String_: followed}},
+					},
+				},
 			},
 		},
 	})
@@ -854,32 +2435,148 @@ func (c *CassandraUsersRepository) Unfollow(context context.Context, follower st
 	return nil
 }
 
-func (c *CassandraUsersRepository) SearchForUsername(context context.Context, username string) ([]models.User, error) {
+/*
+ * Finds the users whose username or names start with the key, which has to be made with utils.SearchKey, give or take the typos utils.SearchDistance
+ * allows. Every row of the users_by_prefix partition of utils.SearchCandidatesPrefix is read, page by page, so none of the users is left out before
+ * the caller ranks them, and every one of them comes back with their number of followers.
+ */
+func (c *CassandraUsersRepository) SearchForUsername(context context.Context, key string) ([]models.PublicUser, error) {
 	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
 	defer cassandraConnectionClient.Put(cassandraClient)
 
-	searchForUsernameQuery := `SELECT * FROM main.users WHERE username = ?;`
-	res, err :=	cassandraClient.ExecuteQuery(&proto.Query{
-		Cql: searchForUsernameQuery,
+	searchForUsernameQuery := &proto.Query{
+		Cql: `SELECT username, first_name, last_name FROM main.users_by_prefix WHERE prefix = ?;`,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				 &proto.Value{Inner: &proto.Value_String_{username}},
+				{Inner: &proto.Value_String_{String_: utils.SearchCandidatesPrefix(key)}},
 			},
 		},
-	})
+		Parameters: &proto.QueryParameters{PageSize: wrapperspb.Int32(searchPageSize)},
+	}
+	foundUsers := []models.PublicUser{}
+	for {
+		res, err := cassandraClient.ExecuteQueryWithContext(searchForUsernameQuery, context)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search for username %s %s", key, err)
+		}
+		for _, row := range res.GetResultSet().Rows {
+			u := models.PublicUser{
+				Username:  row.Values[0].GetString_(),
+				FirstName: row.Values[1].GetString_(),
+				LastName:  row.Values[2].GetString_(),
+			}
+			// Only the first characters of the key are indexed, the rest of it is matched here
+			if _, ok := utils.SearchDistance(key, u.Username, u.FirstName, u.LastName); ok {
+				foundUsers = append(foundUsers, u)
+			}
+		}
+		pagingState := res.GetResultSet().GetPagingState()
+		if len(pagingState.GetValue()) == 0 {
+			break
+		}
+		searchForUsernameQuery.Parameters.PagingState = pagingState
+	}
+
+	followers := map[string]int64{}
+	for start := 0; start < len(foundUsers); start += maxFollowerCountsPerQuery {
+		end := start + maxFollowerCountsPerQuery
+		if end > len(foundUsers) {
+			end = len(foundUsers)
+		}
+		placeholders := make([]string, 0, end-start)
+		usernames := make([]*proto.Value, 0, end-start)
+		for _, u := range foundUsers[start:end] {
+			placeholders = append(placeholders, "?")
+			usernames = append(usernames, &proto.Value{Inner: &proto.Value_String_{String_: u.Username}})
+		}
+		res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+			Cql:    fmt.Sprintf(`SELECT username, followers FROM main.followers_of_user_counter WHERE username IN (%s);`, strings.Join(placeholders, ", ")),
+			Values: &proto.Values{Values: usernames},
+		}, context)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count the followers of the users found for %s %s", key, err)
+		}
+		for _, row := range res.GetResultSet().Rows {
+			followers[row.Values[0].GetString_()] = row.Values[1].GetInt()
+		}
+	}
+	for i := range foundUsers {
+		foundUsers[i].Followers = followers[foundUsers[i].Username]
+	}
+
+	return foundUsers, nil
+}
+
+/*
+ * Fetches everything about the user except their password, which never leaves the repository
+ */
+func (c *CassandraUsersRepository) GetUser(context context.Context, username string) (models.User, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
 
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT username, email, first_name, last_name, created_on, pending_email, email_verified, roles FROM main.users WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search for username %s %s", err)
+		return models.User{}, fmt.Errorf("failed to fetch the user %s %s", username, err)
 	}
 
-	foundUsers := []models.User{}
-	for _, row := range res.GetResultSet().Rows {
-		foundUsers = append(foundUsers, models.User{
-			Username: row.Values[0].GetString_(),
-		})	
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.User{}, ErrUserNotFound
 	}
 
-	return foundUsers, nil
+	createdOn, err := cassandraUuidToGoogleUuid(rows[0].Values[4])
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to parse when the user %s joined %s", username, err)
+	}
+	roles := []string{}
+	for _, role := range rows[0].Values[7].GetCollection().GetElements() {
+		roles = append(roles, role.GetString_())
+	}
+	return models.User{
+		Username:      rows[0].Values[0].GetString_(),
+		Email:         rows[0].Values[1].GetString_(),
+		FirstName:     rows[0].Values[2].GetString_(),
+		LastName:      rows[0].Values[3].GetString_(),
+		JoinedOn:      time.Unix(createdOn.Time().UnixTime()),
+		PendingEmail:  rows[0].Values[5].GetString_(),
+		EmailVerified: rows[0].Values[6].GetBoolean(),
+		Roles:         roles,
+	}, nil
+}
+
+// A user who was never followed and never followed anyone may have no counter rows, they are counted as zero
+func (c *CassandraUsersRepository) GetFollowCounts(context context.Context, username string) (int64, int64, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	counts := []int64{0, 0}
+	for i, q := range []string{
+		`SELECT followers FROM main.followers_of_user_counter WHERE username = ?;`,
+		`SELECT following FROM main.following_by_user_counter WHERE username = ?;`,
+	} {
+		res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+			Cql: q,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				},
+			},
+		}, context)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to fetch the counters of %s %s", username, err)
+		}
+		if rows := res.GetResultSet().Rows; len(rows) > 0 {
+			counts[i] = rows[0].Values[0].GetInt()
+		}
+	}
+	return counts[0], counts[1], nil
 }
 
 func (c *CassandraUsersRepository) FindFollowersOfUser(context context.Context, username string) ([]models.User, error) {
@@ -892,7 +2589,7 @@ func (c *CassandraUsersRepository) FindFollowersOfUser(context context.Context,
 		Cql: followersOfUsersQuery,
 		Values: &proto.Values{
 			Values: []*proto.Value{
-				&proto.Value{Inner: &proto.Value_String_{username}},
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
 			},
 		},
 	})
@@ -911,3 +2608,50 @@ func (c *CassandraUsersRepository) FindFollowersOfUser(context context.Context,
 
 	return followers, nil
 }
+
+func (c *CassandraUsersRepository) FindFollowingOfUser(context context.Context, username string) ([]models.User, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQuery(&proto.Query{
+		Cql: `SELECT followed FROM main.following_by_user WHERE follower = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the users followed by %s %s", username, err)
+	}
+
+	following := []models.User{}
+	for _, r := range res.GetResultSet().Rows {
+		following = append(following, models.User{
+			Username: r.Values[0].GetString_(),
+		})
+	}
+
+	return following, nil
+}
+
+func (c *CassandraUsersRepository) IsFollowing(context context.Context, follower string, followed string) (bool, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	isFollowingQuery := `SELECT follower FROM main.followers_by_user WHERE followed = ? AND follower = ?;`
+	res, err := cassandraClient.ExecuteQuery(&proto.Query{
+		Cql: isFollowingQuery,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: followed}},
+				&proto.Value{Inner: &proto.Value_String_{String_: follower}},
+			},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check if the user %s follows the user %s %s", follower, followed, err)
+	}
+
+	return len(res.GetResultSet().Rows) > 0, nil
+}
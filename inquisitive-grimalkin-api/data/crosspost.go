@@ -0,0 +1,327 @@
+package data
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+var (
+	ErrLinkedAccountNotFound = errors.New("the account is not linked")
+	ErrShareJobNotFound      = errors.New("the share job does not exist")
+)
+
+/*
+ * The tokens are sealed with AES-GCM before they are written, the username and the provider are bound to the ciphertext so that a token copied to the
+ * row of someone else cannot be opened there
+ */
+var linkedAccountsByUserDDL = `CREATE TABLE IF NOT EXISTS main.linked_accounts_by_user (username text, provider text, handle text, instance_url text,
+								access_token text, refresh_token text, expires_on timestamp, linked_on timestamp, PRIMARY KEY ((username), provider));`
+
+// The jobs are only kept for a month, long enough for the user to see what happened to what they shared
+var shareJobsByUserDDL = `CREATE TABLE IF NOT EXISTS main.share_jobs_by_user (username text, job_id timeuuid, provider text, question_id timeuuid, asked text,
+							status text, attempts int, last_error text, remote_url text, PRIMARY KEY ((username), job_id))
+							WITH default_time_to_live = 2592000;`
+
+var linkedAccountsCipher cipher.AEAD
+
+/*
+ * LINKED_ACCOUNTS_KEY is a base64 encoded 32 bytes key, without it accounts cannot be linked at all rather than having their tokens stored in the clear
+ */
+func init() {
+	godotenv.Load()
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("LINKED_ACCOUNTS_KEY"))
+	if err != nil || len(key) != 32 {
+		log.Printf("LINKED_ACCOUNTS_KEY is not a base64 encoded 32 bytes key, accounts on other networks cannot be linked\n")
+		return
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Printf("failed to create the cipher of the linked accounts %s\n", err)
+		return
+	}
+	linkedAccountsCipher, err = cipher.NewGCM(block)
+	if err != nil {
+		log.Printf("failed to create the cipher of the linked accounts %s\n", err)
+	}
+}
+
+func sealToken(token string, username string, provider string) (string, error) {
+	if linkedAccountsCipher == nil {
+		return "", fmt.Errorf("no key is configured to encrypt the tokens of linked accounts")
+	}
+	if token == "" {
+		return "", nil
+	}
+	nonce := make([]byte, linkedAccountsCipher.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate a nonce %s", err)
+	}
+	sealed := linkedAccountsCipher.Seal(nonce, nonce, []byte(token), []byte(username+":"+provider))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openToken(sealedToken string, username string, provider string) (string, error) {
+	if linkedAccountsCipher == nil {
+		return "", fmt.Errorf("no key is configured to decrypt the tokens of linked accounts")
+	}
+	if sealedToken == "" {
+		return "", nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(sealedToken)
+	if err != nil || len(sealed) < linkedAccountsCipher.NonceSize() {
+		return "", fmt.Errorf("the token of the %s account of %s is corrupted", provider, username)
+	}
+	nonce, ciphertext := sealed[:linkedAccountsCipher.NonceSize()], sealed[linkedAccountsCipher.NonceSize():]
+	token, err := linkedAccountsCipher.Open(nil, nonce, ciphertext, []byte(username+":"+provider))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt the token of the %s account of %s %s", provider, username, err)
+	}
+	return string(token), nil
+}
+
+func NewCassandraCrossPostRepository() CassandraCrossPostRepository {
+	return CassandraCrossPostRepository{}
+}
+
+type CassandraCrossPostRepository struct {
+}
+
+// Linking the same provider again replaces the account that was linked before
+func (c *CassandraCrossPostRepository) SaveLinkedAccount(context context.Context, a models.LinkedAccount) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	sealedAccessToken, err := sealToken(a.AccessToken, a.Username, a.Provider)
+	if err != nil {
+		return err
+	}
+	sealedRefreshToken, err := sealToken(a.RefreshToken, a.Username, a.Provider)
+	if err != nil {
+		return err
+	}
+
+	_, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `INSERT INTO main.linked_accounts_by_user (username, provider, handle, instance_url, access_token, refresh_token, expires_on, linked_on)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?);`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: a.Username}},
+				&proto.Value{Inner: &proto.Value_String_{String_: a.Provider}},
+				&proto.Value{Inner: &proto.Value_String_{String_: a.Handle}},
+				&proto.Value{Inner: &proto.Value_String_{String_: a.InstanceUrl}},
+				&proto.Value{Inner: &proto.Value_String_{String_: sealedAccessToken}},
+				&proto.Value{Inner: &proto.Value_String_{String_: sealedRefreshToken}},
+				timestampValue(a.ExpiresOn),
+				timestampValue(a.LinkedOn),
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to link the %s account of %s %s", a.Provider, a.Username, err)
+	}
+	return nil
+}
+
+// Comes back with the tokens decrypted, it must only be used to post on behalf of the user
+func (c *CassandraCrossPostRepository) GetLinkedAccount(context context.Context, username string, provider string) (models.LinkedAccount, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT username, provider, handle, instance_url, access_token, refresh_token, expires_on, linked_on
+				FROM main.linked_accounts_by_user WHERE username = ? AND provider = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				&proto.Value{Inner: &proto.Value_String_{String_: provider}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.LinkedAccount{}, fmt.Errorf("failed to fetch the %s account of %s %s", provider, username, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.LinkedAccount{}, ErrLinkedAccountNotFound
+	}
+
+	a := linkedAccountFromRow(rows[0])
+	a.AccessToken, err = openToken(rows[0].Values[4].GetString_(), username, provider)
+	if err != nil {
+		return models.LinkedAccount{}, err
+	}
+	a.RefreshToken, err = openToken(rows[0].Values[5].GetString_(), username, provider)
+	if err != nil {
+		return models.LinkedAccount{}, err
+	}
+	return a, nil
+}
+
+// Lists the accounts without their tokens
+func (c *CassandraCrossPostRepository) GetLinkedAccounts(context context.Context, username string) ([]models.LinkedAccount, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT username, provider, handle, instance_url, access_token, refresh_token, expires_on, linked_on
+				FROM main.linked_accounts_by_user WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the linked accounts of %s %s", username, err)
+	}
+
+	accounts := []models.LinkedAccount{}
+	for _, row := range res.GetResultSet().Rows {
+		accounts = append(accounts, linkedAccountFromRow(row))
+	}
+	return accounts, nil
+}
+
+func (c *CassandraCrossPostRepository) DeleteLinkedAccount(context context.Context, username string, provider string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `DELETE FROM main.linked_accounts_by_user WHERE username = ? AND provider = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				&proto.Value{Inner: &proto.Value_String_{String_: provider}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to unlink the %s account of %s %s", provider, username, err)
+	}
+	return nil
+}
+
+func (c *CassandraCrossPostRepository) DeleteLinkedAccounts(context context.Context, username string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `DELETE FROM main.linked_accounts_by_user WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to unlink the accounts of %s %s", username, err)
+	}
+	return nil
+}
+
+func linkedAccountFromRow(row *proto.Row) models.LinkedAccount {
+	return models.LinkedAccount{
+		Username:    row.Values[0].GetString_(),
+		Provider:    row.Values[1].GetString_(),
+		Handle:      row.Values[2].GetString_(),
+		InstanceUrl: row.Values[3].GetString_(),
+		ExpiresOn:   timestampFromValue(row.Values[6]),
+		LinkedOn:    timestampFromValue(row.Values[7]),
+	}
+}
+
+func (c *CassandraCrossPostRepository) SaveShareJob(context context.Context, j models.ShareJob) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantJobUuid, err := googleUuidToCassandraUuid(j.JobId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the share job %s", err)
+	}
+	cassandraCompliantQAndAUuid, err := googleUuidToCassandraUuid(j.QuestionId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the shared q&a %s", err)
+	}
+
+	_, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `INSERT INTO main.share_jobs_by_user (username, job_id, provider, question_id, asked, status, attempts, last_error, remote_url)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: j.Username}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantJobUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: j.Provider}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: j.Asked}},
+				&proto.Value{Inner: &proto.Value_String_{String_: j.Status}},
+				&proto.Value{Inner: &proto.Value_Int{Int: int64(j.Attempts)}},
+				&proto.Value{Inner: &proto.Value_String_{String_: j.LastError}},
+				&proto.Value{Inner: &proto.Value_String_{String_: j.RemoteUrl}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to save the share job %s %s", j.JobId, err)
+	}
+	return nil
+}
+
+func (c *CassandraCrossPostRepository) GetShareJob(context context.Context, username string, jobId uuid.UUID) (models.ShareJob, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantJobUuid, err := googleUuidToCassandraUuid(jobId)
+	if err != nil {
+		return models.ShareJob{}, fmt.Errorf("failed to parse the id of the share job %s", err)
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT username, job_id, provider, question_id, asked, status, attempts, last_error, remote_url
+				FROM main.share_jobs_by_user WHERE username = ? AND job_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantJobUuid}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.ShareJob{}, fmt.Errorf("failed to fetch the share job %s %s", jobId, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.ShareJob{}, ErrShareJobNotFound
+	}
+
+	row := rows[0]
+	questionId, err := cassandraUuidToGoogleUuid(row.Values[3])
+	if err != nil {
+		return models.ShareJob{}, fmt.Errorf("failed to parse the id of the shared q&a %s", err)
+	}
+	return models.ShareJob{
+		JobId:      jobId,
+		Username:   row.Values[0].GetString_(),
+		Provider:   row.Values[2].GetString_(),
+		QuestionId: questionId,
+		Asked:      row.Values[4].GetString_(),
+		Status:     row.Values[5].GetString_(),
+		Attempts:   int(row.Values[6].GetInt()),
+		LastError:  row.Values[7].GetString_(),
+		RemoteUrl:  row.Values[8].GetString_(),
+		CreatedOn:  time.Unix(jobId.Time().UnixTime()),
+	}, nil
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"testing"
+)
+
+func useTestLinkedAccountsKey(t *testing.T) {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := linkedAccountsCipher
+	linkedAccountsCipher, err = cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { linkedAccountsCipher = previous })
+}
+
+func TestSealToken(t *testing.T) {
+	useTestLinkedAccountsKey(t)
+
+	sealed, err := sealToken("access-token", "grimalkin", "twitter")
+	if err != nil {
+		t.Fatalf("failed to seal the token %s", err)
+	}
+	if sealed == "access-token" {
+		t.Fatalf("the token was stored in the clear")
+	}
+	token, err := openToken(sealed, "grimalkin", "twitter")
+	if err != nil || token != "access-token" {
+		t.Errorf("opened %q %v, want access-token", token, err)
+	}
+
+	// A sealed token only opens in the row it was written to
+	_, err = openToken(sealed, "someone", "twitter")
+	if err == nil {
+		t.Errorf("the token of grimalkin opened for someone else")
+	}
+	_, err = openToken(sealed, "grimalkin", "mastodon")
+	if err == nil {
+		t.Errorf("the twitter token of grimalkin opened for mastodon")
+	}
+	_, err = openToken(sealed[:len(sealed)-4]+"AAAA", "grimalkin", "twitter")
+	if err == nil {
+		t.Errorf("a tampered token opened")
+	}
+}
+
+func TestSealTokenEmpty(t *testing.T) {
+	useTestLinkedAccountsKey(t)
+
+	sealed, err := sealToken("", "grimalkin", "twitter")
+	if err != nil || sealed != "" {
+		t.Errorf("sealed an empty token as %q %v, want it left empty", sealed, err)
+	}
+	token, err := openToken("", "grimalkin", "twitter")
+	if err != nil || token != "" {
+		t.Errorf("opened an empty token as %q %v, want it left empty", token, err)
+	}
+}
+
+func TestSealTokenWithoutKey(t *testing.T) {
+	previous := linkedAccountsCipher
+	linkedAccountsCipher = nil
+	t.Cleanup(func() { linkedAccountsCipher = previous })
+
+	_, err := sealToken("access-token", "grimalkin", "twitter")
+	if err == nil {
+		t.Errorf("a token was sealed without a key")
+	}
+}
@@ -0,0 +1,235 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"time"
+
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+/*
+ * One row per user who ever opted in to the email digest, opting out only clears opted_in so that opting in again does not send what was already sent
+ */
+var emailDigestsDDL = `CREATE TABLE IF NOT EXISTS main.email_digests (username text, opted_in boolean, last_sent_on timestamp, claimed_until timestamp,
+						PRIMARY KEY ((username)));`
+
+// How much of the timeline of a user is looked at for a digest, the most recent answers first
+const digestHomefeedLimit = 500
+
+func NewCassandraDigestRepository() CassandraDigestRepository {
+	return CassandraDigestRepository{}
+}
+
+type CassandraDigestRepository struct {
+}
+
+func digestStateFromRow(row *proto.Row) models.DigestState {
+	return models.DigestState{
+		Username:     row.Values[0].GetString_(),
+		OptedIn:      row.Values[1].GetBoolean(),
+		LastSentOn:   timestampFromValue(row.Values[2]),
+		ClaimedUntil: timestampFromValue(row.Values[3]),
+	}
+}
+
+// A user who never opted in gets a state that is not opted in
+func (c *CassandraDigestRepository) GetDigestState(context context.Context, username string) (models.DigestState, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT username, opted_in, last_sent_on, claimed_until FROM main.email_digests WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.DigestState{}, fmt.Errorf("failed to fetch the digest state of %s %s", username, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.DigestState{Username: username}, nil
+	}
+	return digestStateFromRow(rows[0]), nil
+}
+
+/*
+ * Goes through the whole table, which only holds the users who opted in at some point. It is only read by the digest job and never on a request.
+ */
+func (c *CassandraDigestRepository) GetDigestStates(context context.Context) ([]models.DigestState, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT username, opted_in, last_sent_on, claimed_until FROM main.email_digests;`,
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the digest states %s", err)
+	}
+	states := []models.DigestState{}
+	for _, row := range res.GetResultSet().Rows {
+		states = append(states, digestStateFromRow(row))
+	}
+	return states, nil
+}
+
+/*
+ * The first opt in starts the digest from now, the users are not sent everything that happened before they asked for it. Later changes only flip the
+ * flag and keep when the last digest was sent.
+ */
+func (c *CassandraDigestRepository) SetDigestOptIn(context context.Context, username string, optedIn bool, now time.Time) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `INSERT INTO main.email_digests (username, opted_in, last_sent_on) VALUES (?, ?, ?) IF NOT EXISTS;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				&proto.Value{Inner: &proto.Value_Boolean{Boolean: optedIn}},
+				timestampValue(now),
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to save the digest choice of %s %s", username, err)
+	}
+	if isApplied(res) {
+		return nil
+	}
+
+	_, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `UPDATE main.email_digests SET opted_in = ? WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_Boolean{Boolean: optedIn}},
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to save the digest choice of %s %s", username, err)
+	}
+	return nil
+}
+
+/*
+ * Claims the digest of the user until the given time, it only succeeds when nobody changed the claim since the state was read, so of two instances
+ * going through the same users only one sends each digest. A claim that is never released expires on its own.
+ */
+func (c *CassandraDigestRepository) ClaimDigest(context context.Context, state models.DigestState, until time.Time) (bool, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `UPDATE main.email_digests SET claimed_until = ? WHERE username = ? IF opted_in = true AND claimed_until = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				timestampValue(until),
+				&proto.Value{Inner: &proto.Value_String_{String_: state.Username}},
+				timestampValue(state.ClaimedUntil),
+			},
+		},
+	}, context)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim the digest of %s %s", state.Username, err)
+	}
+	return isApplied(res), nil
+}
+
+// Releases the claim and moves the start of the next digest to sentOn
+func (c *CassandraDigestRepository) MarkDigestSent(context context.Context, username string, sentOn time.Time) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `UPDATE main.email_digests SET last_sent_on = ? WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				timestampValue(sentOn),
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to record the digest sent to %s %s", username, err)
+	}
+	return nil
+}
+
+// A state deleted while it was claimed is not brought back
+func (c *CassandraDigestRepository) ReleaseDigestClaim(context context.Context, username string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `UPDATE main.email_digests SET claimed_until = null WHERE username = ? IF EXISTS;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to release the digest claim of %s %s", username, err)
+	}
+	return nil
+}
+
+func (c *CassandraDigestRepository) DeleteDigestState(context context.Context, username string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `DELETE FROM main.email_digests WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to delete the digest state of %s %s", username, err)
+	}
+	return nil
+}
+
+/*
+ * The timeline is ordered by when the questions were asked, an old question can be answered long after. The write time of the answer is when it
+ * reached the timeline, so that is what is compared to since, an answer that was edited counts as new again.
+ */
+func (c *CassandraQuestionsRepository) GetHomefeedAnsweredSince(context context.Context, follower string, since time.Time) ([]models.QAndA, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT asked, question_id, answer, asker, is_anon, question, WRITETIME(answer) FROM main.q_and_a_followers WHERE follower = ?
+				ORDER BY question_id DESC LIMIT ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: follower}},
+				&proto.Value{Inner: &proto.Value_Int{Int: digestHomefeedLimit}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the timeline of %s %s", follower, err)
+	}
+
+	qAndAs := []models.QAndA{}
+	for _, row := range res.GetResultSet().Rows {
+		answeredOn := time.UnixMicro(row.Values[6].GetInt())
+		if !answeredOn.After(since) {
+			continue
+		}
+		qAndA := qAndAFromRow(row)
+		qAndA.AnsweredOn = answeredOn
+		qAndAs = append(qAndAs, qAndA)
+	}
+	return qAndAs, nil
+}
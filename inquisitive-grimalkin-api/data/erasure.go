@@ -0,0 +1,62 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/models"
+
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+/*
+ * Every erasure leaves a record behind so that it can be shown later that it was requested and carried out, the subject is a hash of the username since
+ * the username itself is part of what was erased
+ */
+var erasureRecordsDDL = `CREATE TABLE IF NOT EXISTS main.erasure_records (erasure_id timeuuid, subject text, status text, requested_on timestamp,
+						completed_on timestamp, steps list<text>, PRIMARY KEY ((erasure_id)));`
+
+func NewCassandraErasureRepository() CassandraErasureRepository {
+	return CassandraErasureRepository{}
+}
+
+type CassandraErasureRepository struct {
+}
+
+/*
+ * The record is written as a whole every time, once when the erasure is requested and once when it is completed or failed
+ */
+func (c *CassandraErasureRepository) SaveErasureRecord(context context.Context, r models.ErasureRecord) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantErasureUuid, err := googleUuidToCassandraUuid(r.ErasureId)
+	if err != nil {
+		return fmt.Errorf("failed to save the erasure record %s", err)
+	}
+
+	steps := []*proto.Value{}
+	for _, step := range r.Steps {
+		steps = append(steps, &proto.Value{Inner: &proto.Value_String_{String_: step}})
+	}
+
+	saveErasureRecordQuery := `INSERT INTO main.erasure_records (erasure_id, subject, status, requested_on, completed_on, steps)
+								VALUES (?, ?, ?, ?, ?, ?);`
+	_, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: saveErasureRecordQuery,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantErasureUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: r.Subject}},
+				&proto.Value{Inner: &proto.Value_String_{String_: r.Status}},
+				timestampValue(r.RequestedOn),
+				timestampValue(r.CompletedOn),
+				&proto.Value{Inner: &proto.Value_Collection{Collection: &proto.Collection{Elements: steps}}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to save the erasure record %s %s", r.ErasureId, err)
+	}
+	return nil
+}
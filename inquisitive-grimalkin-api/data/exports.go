@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+/*
+ * The exports of a user are kept for a week, which is longer than any download link is valid, after that the rows expire on their own
+ */
+var dataExportsByUserDDL = `CREATE TABLE IF NOT EXISTS main.data_exports_by_user (username text, export_id timeuuid, status text, requested_on timestamp,
+							completed_on timestamp, expires_on timestamp, PRIMARY KEY ((username), export_id)) WITH default_time_to_live = 604800;`
+
+func NewCassandraExportsRepository() CassandraExportsRepository {
+	return CassandraExportsRepository{}
+}
+
+type CassandraExportsRepository struct {
+}
+
+func (c *CassandraExportsRepository) SaveExport(context context.Context, e models.DataExport) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantExportUuid, err := googleUuidToCassandraUuid(e.ExportId)
+	if err != nil {
+		return fmt.Errorf("failed to save the export %s", err)
+	}
+
+	saveExportQuery := `INSERT INTO main.data_exports_by_user (username, export_id, status, requested_on, completed_on, expires_on)
+						VALUES (?, ?, ?, ?, ?, ?);`
+	_, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: saveExportQuery,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: e.Username}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantExportUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: e.Status}},
+				timestampValue(e.RequestedOn),
+				timestampValue(e.CompletedOn),
+				timestampValue(e.ExpiresOn),
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to save the export %s %s", e.ExportId, err)
+	}
+	return nil
+}
+
+func (c *CassandraExportsRepository) GetExport(context context.Context, username string, exportId uuid.UUID) (models.DataExport, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantExportUuid, err := googleUuidToCassandraUuid(exportId)
+	if err != nil {
+		return models.DataExport{}, fmt.Errorf("failed to fetch the export %s", err)
+	}
+
+	getExportQuery := `SELECT username, export_id, status, requested_on, completed_on, expires_on
+						FROM main.data_exports_by_user WHERE username = ? AND export_id = ?;`
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: getExportQuery,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantExportUuid}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.DataExport{}, fmt.Errorf("failed to fetch the export %s %s", exportId, err)
+	}
+
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.DataExport{}, ErrExportNotFound
+	}
+	return models.DataExport{
+		ExportId:    exportId,
+		Username:    rows[0].Values[0].GetString_(),
+		Status:      rows[0].Values[2].GetString_(),
+		RequestedOn: timestampFromValue(rows[0].Values[3]),
+		CompletedOn: timestampFromValue(rows[0].Values[4]),
+		ExpiresOn:   timestampFromValue(rows[0].Values[5]),
+	}, nil
+}
+
+// Timestamps travel as milliseconds since the epoch, a zero time is stored as null
+func timestampValue(t time.Time) *proto.Value {
+	if t.IsZero() {
+		return &proto.Value{Inner: &proto.Value_Null_{Null: &proto.Value_Null{}}}
+	}
+	return &proto.Value{Inner: &proto.Value_Int{Int: t.UnixMilli()}}
+}
+
+func timestampFromValue(v *proto.Value) time.Time {
+	if v.GetNull() != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(v.GetInt())
+}
@@ -0,0 +1,164 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+var ErrHoldNotFound = errors.New("the held content does not exist")
+
+/*
+ * Everything held for review sits in one partition, there are only ever as many rows as the moderators have not gone through yet. The payload is the
+ * JSON of what would have been saved, a question or a q&a, so that approving it saves it exactly as it was sent.
+ */
+var moderationHoldsDDL = `CREATE TABLE IF NOT EXISTS main.moderation_holds (bucket text, hold_id timeuuid, kind text, username text, payload text, reasons text,
+							PRIMARY KEY ((bucket), hold_id)) WITH CLUSTERING ORDER BY (hold_id ASC);`
+
+const pendingHoldsBucket = "pending"
+
+func NewCassandraModerationRepository() CassandraModerationRepository {
+	return CassandraModerationRepository{}
+}
+
+type CassandraModerationRepository struct {
+}
+
+func (c *CassandraModerationRepository) SaveHold(context context.Context, h models.HeldContent) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantHoldUuid, err := googleUuidToCassandraUuid(h.HoldId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the held content %s", err)
+	}
+
+	_, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `INSERT INTO main.moderation_holds (bucket, hold_id, kind, username, payload, reasons) VALUES (?, ?, ?, ?, ?, ?);`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: pendingHoldsBucket}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantHoldUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: h.Kind}},
+				&proto.Value{Inner: &proto.Value_String_{String_: h.Username}},
+				&proto.Value{Inner: &proto.Value_String_{String_: string(h.Payload)}},
+				&proto.Value{Inner: &proto.Value_String_{String_: strings.Join(h.Reasons, "\n")}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to hold the %s of %s for review %s", h.Kind, h.Username, err)
+	}
+	return nil
+}
+
+// The oldest holds come first, they have been waiting the longest
+func (c *CassandraModerationRepository) GetHolds(context context.Context, limit int) ([]models.HeldContent, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT hold_id, kind, username, payload, reasons FROM main.moderation_holds WHERE bucket = ? LIMIT ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: pendingHoldsBucket}},
+				&proto.Value{Inner: &proto.Value_Int{Int: int64(limit)}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the held content %s", err)
+	}
+
+	holds := []models.HeldContent{}
+	for _, row := range res.GetResultSet().Rows {
+		h, err := heldContentFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+	return holds, nil
+}
+
+func (c *CassandraModerationRepository) GetHold(context context.Context, holdId uuid.UUID) (models.HeldContent, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantHoldUuid, err := googleUuidToCassandraUuid(holdId)
+	if err != nil {
+		return models.HeldContent{}, fmt.Errorf("failed to parse the id of the held content %s", err)
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT hold_id, kind, username, payload, reasons FROM main.moderation_holds WHERE bucket = ? AND hold_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: pendingHoldsBucket}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantHoldUuid}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.HeldContent{}, fmt.Errorf("failed to fetch the held content %s %s", holdId, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.HeldContent{}, ErrHoldNotFound
+	}
+	return heldContentFromRow(rows[0])
+}
+
+/*
+ * Deleting with IF EXISTS makes sure that two moderators going through the same hold cannot both act on it, only the one whose delete applied goes on
+ */
+func (c *CassandraModerationRepository) ReleaseHold(context context.Context, holdId uuid.UUID) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantHoldUuid, err := googleUuidToCassandraUuid(holdId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the held content %s", err)
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `DELETE FROM main.moderation_holds WHERE bucket = ? AND hold_id = ? IF EXISTS;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: pendingHoldsBucket}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantHoldUuid}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to release the held content %s %s", holdId, err)
+	}
+	if !isApplied(res) {
+		return ErrHoldNotFound
+	}
+	return nil
+}
+
+func heldContentFromRow(row *proto.Row) (models.HeldContent, error) {
+	holdId, err := cassandraUuidToGoogleUuid(row.Values[0])
+	if err != nil {
+		return models.HeldContent{}, fmt.Errorf("failed to parse the id of the held content %s", err)
+	}
+	reasons := []string{}
+	if row.Values[4].GetString_() != "" {
+		reasons = strings.Split(row.Values[4].GetString_(), "\n")
+	}
+	return models.HeldContent{
+		HoldId:   holdId,
+		Kind:     row.Values[1].GetString_(),
+		Username: row.Values[2].GetString_(),
+		Payload:  []byte(row.Values[3].GetString_()),
+		Reasons:  reasons,
+		HeldOn:   time.Unix(holdId.Time().UnixTime()),
+	}, nil
+}
@@ -0,0 +1,302 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+/*
+ * The most recent notifications come first. Every notification expires notificationsTTL after it was created, including the cells written when it is
+ * marked read, so that nothing of it is left behind.
+ */
+var notificationsByUserDDL = `CREATE TABLE IF NOT EXISTS main.notifications_by_user (username text, notification_id timeuuid, kind text, actor text,
+							question_id uuid, count int, read boolean, PRIMARY KEY ((username), notification_id)) WITH CLUSTERING ORDER BY (notification_id DESC);`
+
+// Points at the notification the likes of a q&a are being gathered in, along with who they came from so that nobody is counted twice
+var likeNotificationsByQAndADDL = `CREATE TABLE IF NOT EXISTS main.like_notifications_by_q_and_a (username text, question_id uuid, notification_id timeuuid,
+							likers set<text>, PRIMARY KEY ((username), question_id));`
+
+const notificationsTTL = 30 * 24 * time.Hour
+
+func NewCassandraNotificationsRepository() CassandraNotificationsRepository {
+	return CassandraNotificationsRepository{}
+}
+
+type CassandraNotificationsRepository struct {
+}
+
+// The time left before a notification expires, in seconds, it is 0 or less for notifications that are already gone
+func remainingNotificationTTL(notificationId uuid.UUID) int64 {
+	createdOn := time.Unix(notificationId.Time().UnixTime())
+	return int64((notificationsTTL - time.Since(createdOn)).Seconds())
+}
+
+/*
+ * Saves the notification and, when replacedId is not the zero uuid, deletes the one it replaces in the same batch. A like notification is also
+ * recorded as the one the next likes of its q&a are gathered in.
+ */
+func (c *CassandraNotificationsRepository) SaveNotification(context context.Context, n models.Notification, replacedId uuid.UUID) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantNotificationUuid, err := googleUuidToCassandraUuid(n.NotificationId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the notification %s", err)
+	}
+	cassandraCompliantQuestionUuid, err := googleUuidToCassandraUuid(n.QuestionId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the q&a of the notification %s", err)
+	}
+	ttl := &proto.Value{Inner: &proto.Value_Int{Int: int64(notificationsTTL.Seconds())}}
+
+	saveNotificationBatchQuery := []*proto.BatchQuery{
+		{
+			Cql: `INSERT INTO main.notifications_by_user (username, notification_id, kind, actor, question_id, count, read) VALUES (?, ?, ?, ?, ?, ?, ?) USING TTL ?;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: n.Username}},
+					&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantNotificationUuid}},
+					&proto.Value{Inner: &proto.Value_String_{String_: n.Kind}},
+					&proto.Value{Inner: &proto.Value_String_{String_: n.Actor}},
+					&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQuestionUuid}},
+					&proto.Value{Inner: &proto.Value_Int{Int: int64(n.Count)}},
+					&proto.Value{Inner: &proto.Value_Boolean{Boolean: n.Read}},
+					ttl,
+				},
+			},
+		},
+	}
+	if n.Kind == models.NotificationLike {
+		likers := []*proto.Value{}
+		for _, liker := range n.Likers {
+			likers = append(likers, &proto.Value{Inner: &proto.Value_String_{String_: liker}})
+		}
+		saveNotificationBatchQuery = append(saveNotificationBatchQuery, &proto.BatchQuery{
+			Cql: `INSERT INTO main.like_notifications_by_q_and_a (username, question_id, notification_id, likers) VALUES (?, ?, ?, ?) USING TTL ?;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: n.Username}},
+					&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQuestionUuid}},
+					&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantNotificationUuid}},
+					&proto.Value{Inner: &proto.Value_Collection{Collection: &proto.Collection{Elements: likers}}},
+					ttl,
+				},
+			},
+		})
+	}
+	if replacedId != (uuid.UUID{}) {
+		cassandraCompliantReplacedUuid, err := googleUuidToCassandraUuid(replacedId)
+		if err != nil {
+			return fmt.Errorf("failed to parse the id of the replaced notification %s", err)
+		}
+		saveNotificationBatchQuery = append(saveNotificationBatchQuery, &proto.BatchQuery{
+			Cql: `DELETE FROM main.notifications_by_user WHERE username = ? AND notification_id = ?;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: n.Username}},
+					&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantReplacedUuid}},
+				},
+			},
+		})
+	}
+
+	_, err = cassandraClient.ExecuteBatchWithContext(&proto.Batch{Type: proto.Batch_LOGGED, Queries: saveNotificationBatchQuery}, context)
+	if err != nil {
+		return fmt.Errorf("failed to notify %s %s", n.Username, err)
+	}
+	return nil
+}
+
+// The bool is false when the likes of the q&a are not being gathered anywhere, because there were none yet or their notification expired
+func (c *CassandraNotificationsRepository) GetLikeNotification(context context.Context, username string, questionId uuid.UUID) (models.Notification, bool, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantQuestionUuid, err := googleUuidToCassandraUuid(questionId)
+	if err != nil {
+		return models.Notification{}, false, fmt.Errorf("failed to parse the id of the q&a %s", err)
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT notification_id, likers FROM main.like_notifications_by_q_and_a WHERE username = ? AND question_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQuestionUuid}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.Notification{}, false, fmt.Errorf("failed to fetch the like notification of %s %s", questionId, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.Notification{}, false, nil
+	}
+	likers := []string{}
+	for _, liker := range rows[0].Values[1].GetCollection().GetElements() {
+		likers = append(likers, liker.GetString_())
+	}
+
+	res, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT notification_id, kind, actor, question_id, count, read FROM main.notifications_by_user WHERE username = ? AND notification_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				rows[0].Values[0],
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.Notification{}, false, fmt.Errorf("failed to fetch the like notification of %s %s", questionId, err)
+	}
+	rows = res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.Notification{}, false, nil
+	}
+	n, err := notificationFromRow(username, rows[0])
+	if err != nil {
+		return models.Notification{}, false, err
+	}
+	n.Likers = likers
+	return n, true, nil
+}
+
+func (c *CassandraNotificationsRepository) GetNotifications(context context.Context, username string, limit int) ([]models.Notification, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT notification_id, kind, actor, question_id, count, read FROM main.notifications_by_user WHERE username = ? LIMIT ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				&proto.Value{Inner: &proto.Value_Int{Int: int64(limit)}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the notifications of %s %s", username, err)
+	}
+
+	notifications := []models.Notification{}
+	for _, row := range res.GetResultSet().Rows {
+		n, err := notificationFromRow(username, row)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+// Filtering is only ever done within the partition of the user, which is kept small by the TTL
+func (c *CassandraNotificationsRepository) CountUnreadNotifications(context context.Context, username string) (int64, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT COUNT(*) FROM main.notifications_by_user WHERE username = ? AND read = false ALLOW FILTERING;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the unread notifications of %s %s", username, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0].Values[0].GetInt(), nil
+}
+
+/*
+ * The read flag is written with what is left of the TTL of each notification, a notification that already expired is skipped rather than brought
+ * back as a row with nothing but the flag
+ */
+func (c *CassandraNotificationsRepository) MarkNotificationsRead(context context.Context, username string, notificationIds []uuid.UUID) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	markReadBatchQuery := []*proto.BatchQuery{}
+	for _, notificationId := range notificationIds {
+		ttl := remainingNotificationTTL(notificationId)
+		if ttl <= 0 {
+			continue
+		}
+		cassandraCompliantNotificationUuid, err := googleUuidToCassandraUuid(notificationId)
+		if err != nil {
+			return fmt.Errorf("failed to parse the id of the notification %s", err)
+		}
+		markReadBatchQuery = append(markReadBatchQuery, &proto.BatchQuery{
+			Cql: `UPDATE main.notifications_by_user USING TTL ? SET read = true WHERE username = ? AND notification_id = ? IF EXISTS;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_Int{Int: ttl}},
+					&proto.Value{Inner: &proto.Value_String_{String_: username}},
+					&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantNotificationUuid}},
+				},
+			},
+		})
+	}
+	if len(markReadBatchQuery) == 0 {
+		return nil
+	}
+
+	_, err := cassandraClient.ExecuteBatchWithContext(&proto.Batch{Type: proto.Batch_LOGGED, Queries: markReadBatchQuery}, context)
+	if err != nil {
+		return fmt.Errorf("failed to mark the notifications of %s as read %s", username, err)
+	}
+	return nil
+}
+
+func (c *CassandraNotificationsRepository) DeleteNotifications(context context.Context, username string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	deleteNotificationsBatchQuery := []*proto.BatchQuery{}
+	for _, table := range []string{"notifications_by_user", "like_notifications_by_q_and_a"} {
+		deleteNotificationsBatchQuery = append(deleteNotificationsBatchQuery, &proto.BatchQuery{
+			Cql: fmt.Sprintf(`DELETE FROM main.%s WHERE username = ?;`, table),
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				},
+			},
+		})
+	}
+	_, err := cassandraClient.ExecuteBatchWithContext(&proto.Batch{Type: proto.Batch_LOGGED, Queries: deleteNotificationsBatchQuery}, context)
+	if err != nil {
+		return fmt.Errorf("failed to delete the notifications of %s %s", username, err)
+	}
+	return nil
+}
+
+func notificationFromRow(username string, row *proto.Row) (models.Notification, error) {
+	notificationId, err := cassandraUuidToGoogleUuid(row.Values[0])
+	if err != nil {
+		return models.Notification{}, fmt.Errorf("failed to parse the id of the notification %s", err)
+	}
+	questionId, err := cassandraUuidToGoogleUuid(row.Values[3])
+	if err != nil {
+		return models.Notification{}, fmt.Errorf("failed to parse the id of the q&a of the notification %s", err)
+	}
+	return models.Notification{
+		NotificationId: notificationId,
+		Username:       username,
+		Kind:           row.Values[1].GetString_(),
+		Actor:          row.Values[2].GetString_(),
+		QuestionId:     questionId,
+		Count:          int(row.Values[4].GetInt()),
+		Read:           row.Values[5].GetBoolean(),
+		CreatedOn:      time.Unix(notificationId.Time().UnixTime()),
+	}, nil
+}
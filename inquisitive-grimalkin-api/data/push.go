@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/models"
+
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+/*
+ * One row per browser the user allowed to receive push messages, the endpoint identifies the browser so subscribing it again replaces its keys
+ */
+var pushSubscriptionsByUserDDL = `CREATE TABLE IF NOT EXISTS main.push_subscriptions_by_user (username text, endpoint text, p256dh text, auth text,
+							created_on timestamp, PRIMARY KEY ((username), endpoint));`
+
+func NewCassandraPushRepository() CassandraPushRepository {
+	return CassandraPushRepository{}
+}
+
+type CassandraPushRepository struct {
+}
+
+func (c *CassandraPushRepository) SavePushSubscription(context context.Context, sub models.PushSubscription) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `INSERT INTO main.push_subscriptions_by_user (username, endpoint, p256dh, auth, created_on) VALUES (?, ?, ?, ?, ?);`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: sub.Username}},
+				&proto.Value{Inner: &proto.Value_String_{String_: sub.Endpoint}},
+				&proto.Value{Inner: &proto.Value_String_{String_: sub.Keys.P256dh}},
+				&proto.Value{Inner: &proto.Value_String_{String_: sub.Keys.Auth}},
+				timestampValue(sub.CreatedOn),
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to save the push subscription of %s %s", sub.Username, err)
+	}
+	return nil
+}
+
+func (c *CassandraPushRepository) GetPushSubscriptions(context context.Context, username string) ([]models.PushSubscription, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT endpoint, p256dh, auth, created_on FROM main.push_subscriptions_by_user WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the push subscriptions of %s %s", username, err)
+	}
+
+	subs := []models.PushSubscription{}
+	for _, row := range res.GetResultSet().Rows {
+		subs = append(subs, models.PushSubscription{
+			Username: username,
+			Endpoint: row.Values[0].GetString_(),
+			Keys: models.PushSubscriptionKeys{
+				P256dh: row.Values[1].GetString_(),
+				Auth:   row.Values[2].GetString_(),
+			},
+			CreatedOn: timestampFromValue(row.Values[3]),
+		})
+	}
+	return subs, nil
+}
+
+func (c *CassandraPushRepository) DeletePushSubscription(context context.Context, username string, endpoint string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `DELETE FROM main.push_subscriptions_by_user WHERE username = ? AND endpoint = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				&proto.Value{Inner: &proto.Value_String_{String_: endpoint}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to delete the push subscription of %s %s", username, err)
+	}
+	return nil
+}
+
+func (c *CassandraPushRepository) DeletePushSubscriptions(context context.Context, username string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `DELETE FROM main.push_subscriptions_by_user WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to delete the push subscriptions of %s %s", username, err)
+	}
+	return nil
+}
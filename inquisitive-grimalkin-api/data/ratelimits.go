@@ -0,0 +1,92 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"time"
+
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+/*
+ * The buckets are shared by every instance, a bucket expires once it would be full again since a missing bucket is treated as a full one anyway
+ */
+var rateLimitBucketsDDL = `CREATE TABLE IF NOT EXISTS main.rate_limit_buckets (bucket_key text PRIMARY KEY, tokens double, updated_on timestamp);`
+
+func NewCassandraRateLimitRepository() CassandraRateLimitRepository {
+	return CassandraRateLimitRepository{}
+}
+
+type CassandraRateLimitRepository struct {
+}
+
+// The bool is false when there is no bucket for the key
+func (c *CassandraRateLimitRepository) GetBucket(context context.Context, key string) (models.RateLimitBucket, bool, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT tokens, updated_on FROM main.rate_limit_buckets WHERE bucket_key = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: key}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.RateLimitBucket{}, false, fmt.Errorf("failed to fetch the rate limit bucket %s %s", key, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.RateLimitBucket{}, false, nil
+	}
+	return models.RateLimitBucket{
+		Key:       key,
+		Tokens:    rows[0].Values[0].GetDouble(),
+		UpdatedOn: timestampFromValue(rows[0].Values[1]),
+	}, true, nil
+}
+
+/*
+ * Saves the bucket only if nobody else did since it was read, previousUpdatedOn is the UpdatedOn it was read with and is zero for a bucket that did not
+ * exist. The bool is false when another request got there first, the bucket has to be read again then.
+ */
+func (c *CassandraRateLimitRepository) SaveBucket(context context.Context, b models.RateLimitBucket, previousUpdatedOn time.Time, ttl time.Duration) (bool, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	ttlInSeconds := &proto.Value{Inner: &proto.Value_Int{Int: int64(ttl.Seconds()) + 1}}
+	query := &proto.Query{
+		Cql: `INSERT INTO main.rate_limit_buckets (bucket_key, tokens, updated_on) VALUES (?, ?, ?) IF NOT EXISTS USING TTL ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: b.Key}},
+				&proto.Value{Inner: &proto.Value_Double{Double: b.Tokens}},
+				timestampValue(b.UpdatedOn),
+				ttlInSeconds,
+			},
+		},
+	}
+	if !previousUpdatedOn.IsZero() {
+		query = &proto.Query{
+			Cql: `UPDATE main.rate_limit_buckets USING TTL ? SET tokens = ?, updated_on = ? WHERE bucket_key = ? IF updated_on = ?;`,
+			Values: &proto.Values{
+				Values: []*proto.Value{
+					ttlInSeconds,
+					&proto.Value{Inner: &proto.Value_Double{Double: b.Tokens}},
+					timestampValue(b.UpdatedOn),
+					&proto.Value{Inner: &proto.Value_String_{String_: b.Key}},
+					timestampValue(previousUpdatedOn),
+				},
+			},
+		}
+	}
+
+	res, err := cassandraClient.ExecuteQueryWithContext(query, context)
+	if err != nil {
+		return false, fmt.Errorf("failed to save the rate limit bucket %s %s", b.Key, err)
+	}
+	return isApplied(res), nil
+}
@@ -0,0 +1,451 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+var (
+	ErrReportNotFound       = errors.New("the report does not exist")
+	ErrReportAlreadyClaimed = errors.New("the report was already claimed by another moderator")
+	ErrReportNotClaimed     = errors.New("the report has to be claimed by the moderator resolving it")
+	ErrReportBeingResolved  = errors.New("the report is already being resolved")
+)
+
+const reportColumns = `report_id, reporter, target_type, target_id, target_owner, reason, details, status, claimed_by, claimed_on, resolution, note, resolved_on`
+
+/*
+ * reports is where the status of a report is decided, claiming and resolving are lightweight transactions on it so that two moderators cannot act on
+ * the same report. reports_by_status is the queue the moderators go through, every report is copied in the partition of its current status.
+ * Resolved reports only stay in the queue for 90 days.
+ */
+var reportsDDL = `CREATE TABLE IF NOT EXISTS main.reports (report_id timeuuid, reporter text, target_type text, target_id uuid, target_owner text, reason text,
+					details text, status text, claimed_by text, claimed_on timestamp, resolution text, note text, resolved_on timestamp,
+					PRIMARY KEY ((report_id)));`
+
+var reportsByStatusDDL = `CREATE TABLE IF NOT EXISTS main.reports_by_status (status text, report_id timeuuid, reporter text, target_type text, target_id uuid,
+							target_owner text, reason text, details text, claimed_by text, claimed_on timestamp, resolution text, note text,
+							resolved_on timestamp, PRIMARY KEY ((status), report_id)) WITH CLUSTERING ORDER BY (report_id ASC);`
+
+const resolvedReportsTTL = 90 * 24 * time.Hour
+
+/*
+ * One partition per day so that the log of a day can be read at once, nothing is ever updated or deleted in it
+ */
+var moderationAuditLogDDL = `CREATE TABLE IF NOT EXISTS main.moderation_audit_log (day text, entry_id timeuuid, moderator text, action text, target_type text,
+								target_id uuid, target_owner text, report_id uuid, note text, PRIMARY KEY ((day), entry_id));`
+
+const auditLogDayLayout = "2006-01-02"
+
+func NewCassandraReportsRepository() CassandraReportsRepository {
+	return CassandraReportsRepository{}
+}
+
+type CassandraReportsRepository struct {
+}
+
+func (c *CassandraReportsRepository) SaveReport(context context.Context, r models.Report) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	reportQuery, err := insertReportQuery(r)
+	if err != nil {
+		return err
+	}
+	reportByStatusQuery, err := insertReportByStatusQuery(r)
+	if err != nil {
+		return err
+	}
+	_, err = cassandraClient.ExecuteBatchWithContext(&proto.Batch{
+		Type:    proto.Batch_LOGGED,
+		Queries: []*proto.BatchQuery{reportQuery, reportByStatusQuery},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to save the report %s %s", r.ReportId, err)
+	}
+	return nil
+}
+
+func (c *CassandraReportsRepository) GetReport(context context.Context, reportId uuid.UUID) (models.Report, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantReportUuid, err := googleUuidToCassandraUuid(reportId)
+	if err != nil {
+		return models.Report{}, fmt.Errorf("failed to parse the id of the report %s", err)
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT ` + reportColumns + ` FROM main.reports WHERE report_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantReportUuid}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.Report{}, fmt.Errorf("failed to fetch the report %s %s", reportId, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.Report{}, ErrReportNotFound
+	}
+	return reportFromRow(rows[0])
+}
+
+// The oldest reports come first, they have been waiting the longest
+func (c *CassandraReportsRepository) GetReportsByStatus(context context.Context, status string, limit int) ([]models.Report, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT ` + reportColumns + ` FROM main.reports_by_status WHERE status = ? LIMIT ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: status}},
+				&proto.Value{Inner: &proto.Value_Int{Int: int64(limit)}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the %s reports %s", status, err)
+	}
+
+	reports := []models.Report{}
+	for _, row := range res.GetResultSet().Rows {
+		r, err := reportFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+func (c *CassandraReportsRepository) ClaimReport(context context.Context, reportId uuid.UUID, moderator string) (models.Report, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantReportUuid, err := googleUuidToCassandraUuid(reportId)
+	if err != nil {
+		return models.Report{}, fmt.Errorf("failed to parse the id of the report %s", err)
+	}
+	claimedOn := time.Now()
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `UPDATE main.reports SET status = ?, claimed_by = ?, claimed_on = ? WHERE report_id = ? IF status = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: models.ReportClaimed}},
+				&proto.Value{Inner: &proto.Value_String_{String_: moderator}},
+				timestampValue(claimedOn),
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantReportUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: models.ReportOpen}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.Report{}, fmt.Errorf("failed to claim the report %s %s", reportId, err)
+	}
+	if !isApplied(res) {
+		// Either the report does not exist or somebody got to it first
+		_, err := c.GetReport(context, reportId)
+		if err != nil {
+			return models.Report{}, err
+		}
+		return models.Report{}, ErrReportAlreadyClaimed
+	}
+
+	r, err := c.GetReport(context, reportId)
+	if err != nil {
+		return models.Report{}, err
+	}
+	err = c.moveReport(context, cassandraClient, r, models.ReportOpen)
+	if err != nil {
+		return models.Report{}, err
+	}
+	return r, nil
+}
+
+// Only the moderator who claimed the report can resolve it
+func (c *CassandraReportsRepository) ResolveReport(context context.Context, r models.Report) (models.Report, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantReportUuid, err := googleUuidToCassandraUuid(r.ReportId)
+	if err != nil {
+		return models.Report{}, fmt.Errorf("failed to parse the id of the report %s", err)
+	}
+	r.Status = models.ReportResolved
+	r.ResolvedOn = time.Now()
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `UPDATE main.reports SET status = ?, resolution = ?, note = ?, resolved_on = ? WHERE report_id = ? IF status = ? AND claimed_by = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: models.ReportResolved}},
+				&proto.Value{Inner: &proto.Value_String_{String_: r.Resolution}},
+				&proto.Value{Inner: &proto.Value_String_{String_: r.Note}},
+				timestampValue(r.ResolvedOn),
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantReportUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: models.ReportClaimed}},
+				&proto.Value{Inner: &proto.Value_String_{String_: r.ClaimedBy}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.Report{}, fmt.Errorf("failed to resolve the report %s %s", r.ReportId, err)
+	}
+	if !isApplied(res) {
+		return models.Report{}, ErrReportNotClaimed
+	}
+
+	err = c.moveReport(context, cassandraClient, r, models.ReportClaimed)
+	if err != nil {
+		return models.Report{}, err
+	}
+	return r, nil
+}
+
+/*
+ * Writes the resolution on the claimed report before its action is taken, only when no resolution was written yet. false means another request got to
+ * it first, that request is the one taking the action.
+ */
+func (c *CassandraReportsRepository) RecordResolution(context context.Context, r models.Report) (bool, error) {
+	return c.setResolution(context, r, "", r.Resolution, r.Note)
+}
+
+// Takes back a resolution whose action failed so that the report can be resolved again
+func (c *CassandraReportsRepository) ClearResolution(context context.Context, r models.Report) error {
+	_, err := c.setResolution(context, r, r.Resolution, "", "")
+	return err
+}
+
+func (c *CassandraReportsRepository) setResolution(context context.Context, r models.Report, previousResolution string, resolution string, note string) (bool, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantReportUuid, err := googleUuidToCassandraUuid(r.ReportId)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse the id of the report %s", err)
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `UPDATE main.reports SET resolution = ?, note = ? WHERE report_id = ? IF status = ? AND claimed_by = ? AND resolution = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_String_{String_: resolution}},
+				{Inner: &proto.Value_String_{String_: note}},
+				{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantReportUuid}},
+				{Inner: &proto.Value_String_{String_: models.ReportClaimed}},
+				{Inner: &proto.Value_String_{String_: r.ClaimedBy}},
+				{Inner: &proto.Value_String_{String_: previousResolution}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return false, fmt.Errorf("failed to write the resolution of the report %s %s", r.ReportId, err)
+	}
+	return isApplied(res), nil
+}
+
+// Moves the copy of the report in the queue from the partition of its previous status to the one of its current status
+func (c *CassandraReportsRepository) moveReport(context context.Context, cassandraClient *client.StargateClient, r models.Report, previousStatus string) error {
+	cassandraCompliantReportUuid, err := googleUuidToCassandraUuid(r.ReportId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the report %s", err)
+	}
+	reportByStatusQuery, err := insertReportByStatusQuery(r)
+	if err != nil {
+		return err
+	}
+	_, err = cassandraClient.ExecuteBatchWithContext(&proto.Batch{
+		Type: proto.Batch_LOGGED,
+		Queries: []*proto.BatchQuery{
+			{
+				Cql: `DELETE FROM main.reports_by_status WHERE status = ? AND report_id = ?;`,
+				Values: &proto.Values{
+					Values: []*proto.Value{
+						&proto.Value{Inner: &proto.Value_String_{String_: previousStatus}},
+						&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantReportUuid}},
+					},
+				},
+			},
+			reportByStatusQuery,
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to move the report %s to the %s reports %s", r.ReportId, r.Status, err)
+	}
+	return nil
+}
+
+func insertReportQuery(r models.Report) (*proto.BatchQuery, error) {
+	values, err := reportValues(r)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.BatchQuery{
+		Cql:    `INSERT INTO main.reports (` + reportColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		Values: &proto.Values{Values: values},
+	}, nil
+}
+
+func insertReportByStatusQuery(r models.Report) (*proto.BatchQuery, error) {
+	values, err := reportValues(r)
+	if err != nil {
+		return nil, err
+	}
+	ttl := 0
+	if r.Status == models.ReportResolved {
+		ttl = int(resolvedReportsTTL.Seconds())
+	}
+	values = append(values, &proto.Value{Inner: &proto.Value_Int{Int: int64(ttl)}})
+	return &proto.BatchQuery{
+		Cql:    `INSERT INTO main.reports_by_status (` + reportColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?;`,
+		Values: &proto.Values{Values: values},
+	}, nil
+}
+
+func reportValues(r models.Report) ([]*proto.Value, error) {
+	cassandraCompliantReportUuid, err := googleUuidToCassandraUuid(r.ReportId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the id of the report %s", err)
+	}
+	cassandraCompliantTargetUuid, err := googleUuidToCassandraUuid(r.TargetId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the id of the reported %s %s", r.TargetType, err)
+	}
+	return []*proto.Value{
+		&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantReportUuid}},
+		&proto.Value{Inner: &proto.Value_String_{String_: r.Reporter}},
+		&proto.Value{Inner: &proto.Value_String_{String_: r.TargetType}},
+		&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantTargetUuid}},
+		&proto.Value{Inner: &proto.Value_String_{String_: r.TargetOwner}},
+		&proto.Value{Inner: &proto.Value_String_{String_: r.Reason}},
+		&proto.Value{Inner: &proto.Value_String_{String_: r.Details}},
+		&proto.Value{Inner: &proto.Value_String_{String_: r.Status}},
+		&proto.Value{Inner: &proto.Value_String_{String_: r.ClaimedBy}},
+		timestampValue(r.ClaimedOn),
+		&proto.Value{Inner: &proto.Value_String_{String_: r.Resolution}},
+		&proto.Value{Inner: &proto.Value_String_{String_: r.Note}},
+		timestampValue(r.ResolvedOn),
+	}, nil
+}
+
+// The row is expected to hold the columns of reportColumns in that order
+func reportFromRow(row *proto.Row) (models.Report, error) {
+	reportId, err := cassandraUuidToGoogleUuid(row.Values[0])
+	if err != nil {
+		return models.Report{}, fmt.Errorf("failed to parse the id of the report %s", err)
+	}
+	targetId, err := cassandraUuidToGoogleUuid(row.Values[3])
+	if err != nil {
+		return models.Report{}, fmt.Errorf("failed to parse the id of the reported content %s", err)
+	}
+	return models.Report{
+		ReportId:    reportId,
+		Reporter:    row.Values[1].GetString_(),
+		TargetType:  row.Values[2].GetString_(),
+		TargetId:    targetId,
+		TargetOwner: row.Values[4].GetString_(),
+		Reason:      row.Values[5].GetString_(),
+		Details:     row.Values[6].GetString_(),
+		Status:      row.Values[7].GetString_(),
+		ClaimedBy:   row.Values[8].GetString_(),
+		ClaimedOn:   timestampFromValue(row.Values[9]),
+		Resolution:  row.Values[10].GetString_(),
+		Note:        row.Values[11].GetString_(),
+		ResolvedOn:  timestampFromValue(row.Values[12]),
+		CreatedOn:   time.Unix(reportId.Time().UnixTime()),
+	}, nil
+}
+
+func (c *CassandraReportsRepository) RecordAuditEntry(context context.Context, e models.AuditEntry) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantEntryUuid, err := googleUuidToCassandraUuid(e.EntryId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the audit entry %s", err)
+	}
+	cassandraCompliantTargetUuid, err := googleUuidToCassandraUuid(e.TargetId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the moderated %s %s", e.TargetType, err)
+	}
+	cassandraCompliantReportUuid, err := googleUuidToCassandraUuid(e.ReportId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the report %s", err)
+	}
+
+	_, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `INSERT INTO main.moderation_audit_log (day, entry_id, moderator, action, target_type, target_id, target_owner, report_id, note)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: e.CreatedOn.UTC().Format(auditLogDayLayout)}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantEntryUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: e.Moderator}},
+				&proto.Value{Inner: &proto.Value_String_{String_: e.Action}},
+				&proto.Value{Inner: &proto.Value_String_{String_: e.TargetType}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantTargetUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: e.TargetOwner}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantReportUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: e.Note}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to record the %s of %s in the audit log %s", e.Action, e.Moderator, err)
+	}
+	return nil
+}
+
+// Every entry recorded on the given day in UTC
+func (c *CassandraReportsRepository) GetAuditLog(context context.Context, day time.Time) ([]models.AuditEntry, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT entry_id, moderator, action, target_type, target_id, target_owner, report_id, note FROM main.moderation_audit_log WHERE day = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: day.UTC().Format(auditLogDayLayout)}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the audit log %s", err)
+	}
+
+	entries := []models.AuditEntry{}
+	for _, row := range res.GetResultSet().Rows {
+		entryId, err := cassandraUuidToGoogleUuid(row.Values[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the id of the audit entry %s", err)
+		}
+		targetId, err := cassandraUuidToGoogleUuid(row.Values[4])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the id of the moderated content %s", err)
+		}
+		reportId, err := cassandraUuidToGoogleUuid(row.Values[6])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the id of the report %s", err)
+		}
+		entries = append(entries, models.AuditEntry{
+			EntryId:     entryId,
+			Moderator:   row.Values[1].GetString_(),
+			Action:      row.Values[2].GetString_(),
+			TargetType:  row.Values[3].GetString_(),
+			TargetId:    targetId,
+			TargetOwner: row.Values[5].GetString_(),
+			ReportId:    reportId,
+			Note:        row.Values[7].GetString_(),
+			CreatedOn:   time.Unix(entryId.Time().UnixTime()),
+		})
+	}
+	return entries, nil
+}
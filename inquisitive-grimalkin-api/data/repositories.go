@@ -2,19 +2,43 @@ package data
 
 import (
 	"context"
+	"errors"
 	"github.com/google/uuid"
 	"inquisitive-grimalkin/models"
+	"time"
+)
+
+var (
+	ErrUsernameTaken    = errors.New("the username is already taken")
+	ErrEmailTaken       = errors.New("the email is already used by another account")
+	ErrQuestionNotFound = errors.New("the question does not exist")
+	ErrUserNotFound     = errors.New("the user does not exist")
+	ErrExportNotFound   = errors.New("the export does not exist")
+	ErrWrongPassword    = errors.New("the password is wrong")
+	ErrTokenNotFound    = errors.New("the token does not exist, was already used or has expired")
 )
 
 type QuestionsRepository interface {
+	GetUnansweredQuestion(context.Context, string, uuid.UUID) (models.Question, error)
 	GetUnansweredQuestionsForUser(context.Context, string) ([]models.Question, error)
 	Ask(context.Context, models.Question) (models.Question, error)
 	AnswerQuestion(context context.Context,questionId uuid.UUID , qAndA models.QAndA) (models.QAndA, error)
-	UpdateAnswer(context.Context, models.QAndA) (models.QAndA, error)
+	UpdateAnswer(context.Context, uuid.UUID, models.QAndA) (models.QAndA, error)
 	DeleteQAndA(context.Context, models.QAndA) error
 	PostAnswerToFollowersHomefeed(context.Context, models.QAndA,...models.User) (error)
 	UpdateAnswerToFollowersHomefeed(context.Context, models.QAndA, ...models.User) (error)
 	DeleteAnswerFromFollowersHomefeed(context.Context, models.QAndA, ...models.User) (error)
+	RevealAnonymousAsker(context.Context, uuid.UUID) (models.AnonymousAsker, error)
+	RevealAnonymousAskers(context.Context, []uuid.UUID) (map[uuid.UUID]string, error)
+	DeleteUnansweredQuestion(context.Context, models.Question) error
+	GetAnsweredQuestionsForUser(context.Context, string) ([]models.QAndA, error)
+	CountAnswersForUser(context.Context, string) (int64, error)
+	GetQAndA(context context.Context, asked string, qAndAId uuid.UUID) (models.QAndA, error)
+	GetQAndAOwner(context context.Context, qAndAId uuid.UUID) (string, error)
+	GetQuestionsAskedByUser(context.Context, string) ([]models.AskedQuestion, error)
+	AnonymizeAsker(context context.Context, asked string, qAndAId uuid.UUID) (models.QAndA, error)
+	DeleteQuestionsAskedByUser(context.Context, string) error
+	DeleteHomefeed(context.Context, string) error
 }
 
 type LikesRepository interface {
@@ -27,12 +51,82 @@ type LikesRepository interface {
 
 type UsersRepository interface {
 	DoesUserExist(context.Context, models.User) (bool, error)
+	GetUser(context.Context, string) (models.User, error)
 	Register(context.Context, models.User) (models.User, error)
 	Login(context.Context, models.User) (models.User, error)
 	Delete(context.Context, models.User) error
+	DeleteCounters(context.Context, string) error
 	UpdateLoginDetails(context.Context, models.User) (models.User, error)
-	Follow(context context.Context, follower models.User, followed models.User) (error)
-	Unfollow(context context.Context, follower models.User, followed models.User) (error)
+	UpdatePassword(context context.Context, username string, currentPassword string, newPassword string) error
+	ResetPassword(context context.Context, username string, newPassword string) error
+	VerifyEmail(context context.Context, username string, email string) error
+	FindUsernameByEmail(context context.Context, email string) (string, error)
+	Follow(context context.Context, follower string, followed string) (error)
+	Unfollow(context context.Context, follower string, followed string) (error)
 	FindFollowersOfUser(context context.Context, username string) ([]models.User, error)
-	SearchForUsername(context.Context, string) ([]models.User, error)
-}
\ No newline at end of file
+	FindFollowingOfUser(context context.Context, username string) ([]models.User, error)
+	IsFollowing(context context.Context, follower string, followed string) (bool, error)
+	GetFollowCounts(context context.Context, username string) (followers int64, following int64, err error)
+	SearchForUsername(context context.Context, key string) ([]models.PublicUser, error)
+	SetAccountStatus(context context.Context, status models.AccountStatus) error
+	GetAccountStatus(context context.Context, username string) (models.AccountStatus, error)
+	GetAccountStatuses(context context.Context, usernames []string) (map[string]models.AccountStatus, error)
+}
+
+type SettingsRepository interface {
+	GetSettings(context.Context, string) (models.UserSettings, error)
+	UpdateSettings(context.Context, models.UserSettings) (models.UserSettings, error)
+	DeleteSettings(context.Context, string) error
+}
+
+type ErasureRepository interface {
+	SaveErasureRecord(context.Context, models.ErasureRecord) error
+}
+
+type ExportsRepository interface {
+	SaveExport(context.Context, models.DataExport) error
+	GetExport(context context.Context, username string, exportId uuid.UUID) (models.DataExport, error)
+}
+
+type TokensRepository interface {
+	SaveToken(context context.Context, tokenHash string, t models.AccountToken, ttl time.Duration) error
+	GetToken(context context.Context, tokenHash string, purpose string) (models.AccountToken, error)
+	ConsumeToken(context context.Context, tokenHash string, purpose string) (models.AccountToken, error)
+}
+
+type ShareRepository interface {
+	SaveShare(context.Context, models.SharedQAndA) (models.SharedQAndA, error)
+	GetShare(context.Context, uuid.UUID) (models.SharedQAndA, error)
+}
+
+type SessionsRepository interface {
+	RevokeSessions(context context.Context, username string, before time.Time) error
+	GetSessionsRevokedBefore(context context.Context, username string) (time.Time, error)
+}
+
+type CrossPostRepository interface {
+	SaveLinkedAccount(context context.Context, a models.LinkedAccount) error
+	GetLinkedAccount(context context.Context, username string, provider string) (models.LinkedAccount, error)
+	GetLinkedAccounts(context context.Context, username string) ([]models.LinkedAccount, error)
+	DeleteLinkedAccount(context context.Context, username string, provider string) error
+	DeleteLinkedAccounts(context context.Context, username string) error
+	SaveShareJob(context context.Context, j models.ShareJob) error
+	GetShareJob(context context.Context, username string, jobId uuid.UUID) (models.ShareJob, error)
+}
+
+type ModerationRepository interface {
+	SaveHold(context context.Context, h models.HeldContent) error
+	GetHolds(context context.Context, limit int) ([]models.HeldContent, error)
+	GetHold(context context.Context, holdId uuid.UUID) (models.HeldContent, error)
+	ReleaseHold(context context.Context, holdId uuid.UUID) error
+}
+
+type ReportsRepository interface {
+	SaveReport(context context.Context, r models.Report) error
+	GetReport(context context.Context, reportId uuid.UUID) (models.Report, error)
+	GetReportsByStatus(context context.Context, status string, limit int) ([]models.Report, error)
+	ClaimReport(context context.Context, reportId uuid.UUID, moderator string) (models.Report, error)
+	ResolveReport(context context.Context, r models.Report) (models.Report, error)
+	RecordAuditEntry(context context.Context, e models.AuditEntry) error
+	GetAuditLog(context context.Context, day time.Time) ([]models.AuditEntry, error)
+}
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+/*
+ * Tokens are never stored, so they cannot be revoked one by one. Instead every token of a user issued before revoked_before is no longer accepted, which
+ * revokes all of their sessions at once.
+ */
+var sessionRevocationsDDL = `CREATE TABLE IF NOT EXISTS main.session_revocations (username text, revoked_before timestamp, PRIMARY KEY ((username)));`
+
+func NewCassandraSessionsRepository() CassandraSessionsRepository {
+	return CassandraSessionsRepository{}
+}
+
+type CassandraSessionsRepository struct {
+}
+
+func (c *CassandraSessionsRepository) RevokeSessions(context context.Context, username string, before time.Time) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `INSERT INTO main.session_revocations (username, revoked_before) VALUES (?, ?);`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+				timestampValue(before),
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to revoke the sessions of %s %s", username, err)
+	}
+	return nil
+}
+
+// A user whose sessions were never revoked gets the zero time back, every token of theirs is accepted
+func (c *CassandraSessionsRepository) GetSessionsRevokedBefore(context context.Context, username string) (time.Time, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT revoked_before FROM main.session_revocations WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to fetch the revoked sessions of %s %s", username, err)
+	}
+
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return time.Time{}, nil
+	}
+	return timestampFromValue(rows[0].Values[0]), nil
+}
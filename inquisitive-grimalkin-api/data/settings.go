@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/models"
+
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+/*
+ * One row per user holding how their inbox behaves, the row is only written once the user changes their settings for the first time, until then the
+ * defaults from models.DefaultUserSettings apply.
+ */
+var userSettingsDDL = `CREATE TABLE IF NOT EXISTS main.user_settings (username text, accept_anonymous_questions boolean, accept_questions_only_from_followed boolean,
+						inbox_paused boolean, PRIMARY KEY ((username)));`
+
+func NewCassandraSettingsRepository() CassandraSettingsRepository {
+	return CassandraSettingsRepository{}
+}
+
+type CassandraSettingsRepository struct {
+}
+
+func (c *CassandraSettingsRepository) GetSettings(context context.Context, username string) (models.UserSettings, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	if username == "" {
+		return models.UserSettings{}, fmt.Errorf("cannot fetch the settings for no one")
+	}
+
+	getSettingsQuery := `SELECT username, accept_anonymous_questions, accept_questions_only_from_followed, inbox_paused
+						FROM main.user_settings WHERE username = ?;`
+	res, err := cassandraClient.ExecuteQuery(&proto.Query{
+		Cql: getSettingsQuery,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	})
+	if err != nil {
+		return models.UserSettings{}, fmt.Errorf("failed to fetch the settings of user %s %s", username, err)
+	}
+
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.DefaultUserSettings(username), nil
+	}
+
+	return models.UserSettings{
+		Username:                        rows[0].Values[0].GetString_(),
+		AcceptAnonymousQuestions:        rows[0].Values[1].GetBoolean(),
+		AcceptQuestionsOnlyFromFollowed: rows[0].Values[2].GetBoolean(),
+		InboxPaused:                     rows[0].Values[3].GetBoolean(),
+	}, nil
+}
+
+func (c *CassandraSettingsRepository) UpdateSettings(context context.Context, s models.UserSettings) (models.UserSettings, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	if s.Username == "" {
+		return models.UserSettings{}, fmt.Errorf("cannot update the settings for no one")
+	}
+
+	/*
+	 * The whole row is overwritten on purpose, the settings are small and the router merges the ones that were sent into the stored ones first
+	 */
+	updateSettingsQuery := `INSERT INTO main.user_settings
+							(username, accept_anonymous_questions, accept_questions_only_from_followed, inbox_paused)
+							VALUES (?, ?, ?, ?);`
+	_, err := cassandraClient.ExecuteQuery(&proto.Query{
+		Cql: updateSettingsQuery,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: s.Username}},
+				&proto.Value{Inner: &proto.Value_Boolean{Boolean: s.AcceptAnonymousQuestions}},
+				&proto.Value{Inner: &proto.Value_Boolean{Boolean: s.AcceptQuestionsOnlyFromFollowed}},
+				&proto.Value{Inner: &proto.Value_Boolean{Boolean: s.InboxPaused}},
+			},
+		},
+	})
+	if err != nil {
+		return models.UserSettings{}, fmt.Errorf("failed to update the settings of user %s %s", s.Username, err)
+	}
+
+	return s, nil
+}
+
+func (c *CassandraSettingsRepository) DeleteSettings(context context.Context, username string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQuery(&proto.Query{
+		Cql: `DELETE FROM main.user_settings WHERE username = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: username}},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete the settings of user %s %s", username, err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/models"
+
+	"github.com/google/uuid"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+/*
+ * The q&as are partitioned by the asked user, which a permalink made of the question id alone does not know. Sharing a q&a records who answered it here
+ * so that the public pages can find it, the q&a itself is always read from q_and_a_users so that an edit or a deletion shows up on the permalink.
+ */
+var sharedQAndAsDDL = `CREATE TABLE IF NOT EXISTS main.shared_q_and_as (question_id timeuuid, asked text, shared_on timestamp, PRIMARY KEY ((question_id)));`
+
+func NewCassandraShareRepository() CassandraShareRepository {
+	return CassandraShareRepository{}
+}
+
+type CassandraShareRepository struct {
+}
+
+// Sharing the same q&a again keeps the first share, the permalink never changes
+func (c *CassandraShareRepository) SaveShare(context context.Context, s models.SharedQAndA) (models.SharedQAndA, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantQAndAUuid, err := googleUuidToCassandraUuid(s.QuestionId)
+	if err != nil {
+		return models.SharedQAndA{}, fmt.Errorf("failed to parse the id of the shared q&a %s", err)
+	}
+	_, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `INSERT INTO main.shared_q_and_as (question_id, asked, shared_on) VALUES (?, ?, ?) IF NOT EXISTS;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: s.Asked}},
+				timestampValue(s.SharedOn),
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.SharedQAndA{}, fmt.Errorf("failed to share the q&a %s %s", s.QuestionId, err)
+	}
+	return c.GetShare(context, s.QuestionId)
+}
+
+func (c *CassandraShareRepository) GetShare(context context.Context, qAndAId uuid.UUID) (models.SharedQAndA, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantQAndAUuid, err := googleUuidToCassandraUuid(qAndAId)
+	if err != nil {
+		return models.SharedQAndA{}, fmt.Errorf("failed to parse the id of the shared q&a %s", err)
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT question_id, asked, shared_on FROM main.shared_q_and_as WHERE question_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.SharedQAndA{}, fmt.Errorf("failed to fetch the shared q&a %s %s", qAndAId, err)
+	}
+
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.SharedQAndA{}, ErrQuestionNotFound
+	}
+	return models.SharedQAndA{
+		QuestionId: qAndAId,
+		Asked:      rows[0].Values[1].GetString_(),
+		SharedOn:   timestampFromValue(rows[0].Values[2]),
+	}, nil
+}
+
+func deleteShareBatchQuery(cassandraCompliantQAndAUuid *proto.Uuid) *proto.BatchQuery {
+	return &proto.BatchQuery{
+		Cql: `DELETE FROM main.shared_q_and_as WHERE question_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQAndAUuid}},
+			},
+		},
+	}
+}
@@ -0,0 +1,253 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+var ErrQuarantinedQuestionNotFound = errors.New("the quarantined question does not exist")
+
+/*
+ * The questions of an asker are only remembered for as long as the spam detector looks back, the fingerprint is the SimHash of the text stored as a
+ * bigint
+ */
+var recentQuestionsByAskerDDL = `CREATE TABLE IF NOT EXISTS main.recent_questions_by_asker (asker text, asked_on timestamp, question_id timeuuid, asked text,
+							fingerprint bigint, PRIMARY KEY ((asker), asked_on, question_id)) WITH CLUSTERING ORDER BY (asked_on DESC, question_id ASC);`
+
+/*
+ * The asker is kept even for anonymous questions so that releasing one records its asker for the moderators like any other anonymous question, it is
+ * never handed to the recipient
+ */
+var quarantinedQuestionsByUserDDL = `CREATE TABLE IF NOT EXISTS main.quarantined_questions_by_user (asked text, question_id timeuuid, asker text, is_anon boolean,
+							question text, reasons text, PRIMARY KEY ((asked), question_id)) WITH CLUSTERING ORDER BY (question_id DESC);`
+
+// Whatever the recipient did not review by then is dropped
+const quarantinedQuestionsTTL = 30 * 24 * time.Hour
+
+func NewCassandraSpamRepository() CassandraSpamRepository {
+	return CassandraSpamRepository{}
+}
+
+type CassandraSpamRepository struct {
+}
+
+func (c *CassandraSpamRepository) RecordQuestion(context context.Context, q models.RecentQuestion, ttl time.Duration) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	questionId, err := uuid.NewUUID()
+	if err != nil {
+		return fmt.Errorf("failed to record the question of %s %s", q.Asker, err)
+	}
+	cassandraCompliantQuestionUuid, err := googleUuidToCassandraUuid(questionId)
+	if err != nil {
+		return fmt.Errorf("failed to record the question of %s %s", q.Asker, err)
+	}
+
+	_, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `INSERT INTO main.recent_questions_by_asker (asker, asked_on, question_id, asked, fingerprint) VALUES (?, ?, ?, ?, ?) USING TTL ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: q.Asker}},
+				timestampValue(q.AskedOn),
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQuestionUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: q.Asked}},
+				&proto.Value{Inner: &proto.Value_Int{Int: int64(q.Fingerprint)}},
+				&proto.Value{Inner: &proto.Value_Int{Int: int64(ttl.Seconds())}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to record the question of %s %s", q.Asker, err)
+	}
+	return nil
+}
+
+// The questions the asker asked after since, the most recent first
+func (c *CassandraSpamRepository) GetRecentQuestions(context context.Context, asker string, since time.Time) ([]models.RecentQuestion, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT asked_on, asked, fingerprint FROM main.recent_questions_by_asker WHERE asker = ? AND asked_on > ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: asker}},
+				timestampValue(since),
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the recent questions of %s %s", asker, err)
+	}
+
+	questions := []models.RecentQuestion{}
+	for _, row := range res.GetResultSet().Rows {
+		questions = append(questions, models.RecentQuestion{
+			Asker:       asker,
+			AskedOn:     timestampFromValue(row.Values[0]),
+			Asked:       row.Values[1].GetString_(),
+			Fingerprint: uint64(row.Values[2].GetInt()),
+		})
+	}
+	return questions, nil
+}
+
+func (c *CassandraSpamRepository) Quarantine(context context.Context, q models.QuarantinedQuestion) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantQuestionUuid, err := googleUuidToCassandraUuid(q.Question.QuestionId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the quarantined question %s", err)
+	}
+	_, err = cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `INSERT INTO main.quarantined_questions_by_user (asked, question_id, asker, is_anon, question, reasons) VALUES (?, ?, ?, ?, ?, ?) USING TTL ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: q.Question.Asked}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQuestionUuid}},
+				&proto.Value{Inner: &proto.Value_String_{String_: q.Question.Asker}},
+				&proto.Value{Inner: &proto.Value_Boolean{Boolean: q.Question.IsAnon}},
+				&proto.Value{Inner: &proto.Value_String_{String_: q.Question.Question}},
+				&proto.Value{Inner: &proto.Value_String_{String_: strings.Join(q.Reasons, "\n")}},
+				&proto.Value{Inner: &proto.Value_Int{Int: int64(quarantinedQuestionsTTL.Seconds())}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to quarantine the question to %s %s", q.Question.Asked, err)
+	}
+	return nil
+}
+
+func (c *CassandraSpamRepository) GetQuarantinedQuestions(context context.Context, asked string) ([]models.QuarantinedQuestion, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT question_id, asker, is_anon, question, reasons FROM main.quarantined_questions_by_user WHERE asked = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: asked}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the quarantined questions of %s %s", asked, err)
+	}
+
+	questions := []models.QuarantinedQuestion{}
+	for _, row := range res.GetResultSet().Rows {
+		q, err := quarantinedQuestionFromRow(asked, row)
+		if err != nil {
+			return nil, err
+		}
+		questions = append(questions, q)
+	}
+	return questions, nil
+}
+
+func (c *CassandraSpamRepository) GetQuarantinedQuestion(context context.Context, asked string, questionId uuid.UUID) (models.QuarantinedQuestion, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantQuestionUuid, err := googleUuidToCassandraUuid(questionId)
+	if err != nil {
+		return models.QuarantinedQuestion{}, fmt.Errorf("failed to parse the id of the quarantined question %s", err)
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT question_id, asker, is_anon, question, reasons FROM main.quarantined_questions_by_user WHERE asked = ? AND question_id = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: asked}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQuestionUuid}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.QuarantinedQuestion{}, fmt.Errorf("failed to fetch the quarantined question %s %s", questionId, err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 {
+		return models.QuarantinedQuestion{}, ErrQuarantinedQuestionNotFound
+	}
+	return quarantinedQuestionFromRow(asked, rows[0])
+}
+
+/*
+ * Deleting with IF EXISTS makes sure that a question released twice at the same time only lands in the inbox once
+ */
+func (c *CassandraSpamRepository) DeleteQuarantinedQuestion(context context.Context, asked string, questionId uuid.UUID) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	cassandraCompliantQuestionUuid, err := googleUuidToCassandraUuid(questionId)
+	if err != nil {
+		return fmt.Errorf("failed to parse the id of the quarantined question %s", err)
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `DELETE FROM main.quarantined_questions_by_user WHERE asked = ? AND question_id = ? IF EXISTS;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: asked}},
+				&proto.Value{Inner: &proto.Value_Uuid{Uuid: cassandraCompliantQuestionUuid}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to delete the quarantined question %s %s", questionId, err)
+	}
+	if !isApplied(res) {
+		return ErrQuarantinedQuestionNotFound
+	}
+	return nil
+}
+
+func (c *CassandraSpamRepository) DeleteQuarantinedQuestions(context context.Context, asked string) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `DELETE FROM main.quarantined_questions_by_user WHERE asked = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: asked}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to delete the quarantined questions of %s %s", asked, err)
+	}
+	return nil
+}
+
+func quarantinedQuestionFromRow(asked string, row *proto.Row) (models.QuarantinedQuestion, error) {
+	questionId, err := cassandraUuidToGoogleUuid(row.Values[0])
+	if err != nil {
+		return models.QuarantinedQuestion{}, fmt.Errorf("failed to parse the id of the quarantined question %s", err)
+	}
+	reasons := []string{}
+	if row.Values[4].GetString_() != "" {
+		reasons = strings.Split(row.Values[4].GetString_(), "\n")
+	}
+	return models.QuarantinedQuestion{
+		Question: models.Question{
+			QuestionId: questionId,
+			Asked:      asked,
+			Asker:      row.Values[1].GetString_(),
+			IsAnon:     row.Values[2].GetBoolean(),
+			Question:   row.Values[3].GetString_(),
+		},
+		Reasons:       reasons,
+		QuarantinedOn: time.Unix(questionId.Time().UnixTime()),
+	}, nil
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"strings"
+
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+/*
+ * The status lives in the users table with the rest of the account, suspended_until, banned and suspension_reason are all empty for accounts in good
+ * standing
+ */
+func (c *CassandraUsersRepository) SetAccountStatus(context context.Context, status models.AccountStatus) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `UPDATE main.users SET suspended_until = ?, banned = ?, suspension_reason = ? WHERE username = ? IF EXISTS;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				timestampValue(status.SuspendedUntil),
+				&proto.Value{Inner: &proto.Value_Boolean{Boolean: status.Banned}},
+				&proto.Value{Inner: &proto.Value_String_{String_: status.Reason}},
+				&proto.Value{Inner: &proto.Value_String_{String_: status.Username}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to change the status of %s %s", status.Username, err)
+	}
+	if !isApplied(res) {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (c *CassandraUsersRepository) GetAccountStatus(context context.Context, username string) (models.AccountStatus, error) {
+	statuses, err := c.GetAccountStatuses(context, []string{username})
+	if err != nil {
+		return models.AccountStatus{}, err
+	}
+	status, ok := statuses[username]
+	if !ok {
+		return models.AccountStatus{}, ErrUserNotFound
+	}
+	return status, nil
+}
+
+// Users that do not exist are left out of the map
+func (c *CassandraUsersRepository) GetAccountStatuses(context context.Context, usernames []string) (map[string]models.AccountStatus, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	statuses := map[string]models.AccountStatus{}
+	if len(usernames) == 0 {
+		return statuses, nil
+	}
+
+	placeholders := make([]string, 0, len(usernames))
+	values := make([]*proto.Value, 0, len(usernames))
+	for _, username := range usernames {
+		placeholders = append(placeholders, "?")
+		values = append(values, &proto.Value{Inner: &proto.Value_String_{String_: username}})
+	}
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql:    fmt.Sprintf(`SELECT username, suspended_until, banned, suspension_reason FROM main.users WHERE username IN (%s);`, strings.Join(placeholders, ", ")),
+		Values: &proto.Values{Values: values},
+	}, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the status of the accounts %s", err)
+	}
+	for _, row := range res.GetResultSet().Rows {
+		status := models.AccountStatus{
+			Username:       row.Values[0].GetString_(),
+			SuspendedUntil: timestampFromValue(row.Values[1]),
+			Banned:         row.Values[2].GetBoolean(),
+			Reason:         row.Values[3].GetString_(),
+		}
+		statuses[status.Username] = status
+	}
+	return statuses, nil
+}
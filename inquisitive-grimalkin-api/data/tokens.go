@@ -0,0 +1,104 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"time"
+
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/client"
+	"github.com/stargate/stargate-grpc-go-client/stargate/pkg/proto"
+)
+
+/*
+ * Only the hash of a token is stored, whoever reads the table cannot use what they find there. The rows expire on their own through their TTL, and a
+ * token is deleted the moment it is used so it can only be used once.
+ */
+var accountTokensDDL = `CREATE TABLE IF NOT EXISTS main.account_tokens (token_hash text, purpose text, username text, email text, created_on timestamp,
+						PRIMARY KEY ((token_hash)));`
+
+func NewCassandraTokensRepository() CassandraTokensRepository {
+	return CassandraTokensRepository{}
+}
+
+type CassandraTokensRepository struct {
+}
+
+func (c *CassandraTokensRepository) SaveToken(context context.Context, tokenHash string, t models.AccountToken, ttl time.Duration) error {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	_, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `INSERT INTO main.account_tokens (token_hash, purpose, username, email, created_on) VALUES (?, ?, ?, ?, ?) USING TTL ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: tokenHash}},
+				&proto.Value{Inner: &proto.Value_String_{String_: t.Purpose}},
+				&proto.Value{Inner: &proto.Value_String_{String_: t.Username}},
+				&proto.Value{Inner: &proto.Value_String_{String_: t.Email}},
+				timestampValue(t.CreatedOn),
+				&proto.Value{Inner: &proto.Value_Int{Int: int64(ttl.Seconds())}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return fmt.Errorf("failed to save the %s token of %s %s", t.Purpose, t.Username, err)
+	}
+	return nil
+}
+
+func (c *CassandraTokensRepository) GetToken(context context.Context, tokenHash string, purpose string) (models.AccountToken, error) {
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `SELECT purpose, username, email, created_on FROM main.account_tokens WHERE token_hash = ?;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: tokenHash}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.AccountToken{}, fmt.Errorf("failed to fetch the token %s", err)
+	}
+	rows := res.GetResultSet().Rows
+	if len(rows) == 0 || rows[0].Values[0].GetString_() != purpose {
+		return models.AccountToken{}, ErrTokenNotFound
+	}
+	return models.AccountToken{
+		Purpose:   rows[0].Values[0].GetString_(),
+		Username:  rows[0].Values[1].GetString_(),
+		Email:     rows[0].Values[2].GetString_(),
+		CreatedOn: timestampFromValue(rows[0].Values[3]),
+	}, nil
+}
+
+/*
+ * The token is deleted with a lightweight transaction, when two requests race to use the same token only the one that deleted it gets it back
+ */
+func (c *CassandraTokensRepository) ConsumeToken(context context.Context, tokenHash string, purpose string) (models.AccountToken, error) {
+	t, err := c.GetToken(context, tokenHash, purpose)
+	if err != nil {
+		return models.AccountToken{}, err
+	}
+
+	cassandraClient := cassandraConnectionClient.Get().(*client.StargateClient)
+	defer cassandraConnectionClient.Put(cassandraClient)
+
+	res, err := cassandraClient.ExecuteQueryWithContext(&proto.Query{
+		Cql: `DELETE FROM main.account_tokens WHERE token_hash = ? IF EXISTS;`,
+		Values: &proto.Values{
+			Values: []*proto.Value{
+				&proto.Value{Inner: &proto.Value_String_{String_: tokenHash}},
+			},
+		},
+	}, context)
+	if err != nil {
+		return models.AccountToken{}, fmt.Errorf("failed to use the token %s", err)
+	}
+	if !isApplied(res) {
+		return models.AccountToken{}, ErrTokenNotFound
+	}
+	return t, nil
+}
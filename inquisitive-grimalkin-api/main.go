@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/middleware"
+	"inquisitive-grimalkin/routers"
+	"inquisitive-grimalkin/rpc"
+	"inquisitive-grimalkin/services"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/joho/godotenv"
+)
+
+const shutdownTimeout = 30 * time.Second
+
+/*
+ * PORT is where the REST routers are served, 8080 by default, and GRPC_PORT is where grimalkin.proto is served, 9090 by default. Both go through the
+ * same token checks and the same rate limit buckets.
+ */
+func main() {
+	godotenv.Load()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	data.CreateTables()
+
+	r := chi.NewRouter()
+	r.Use(middleware.JwtAuthenticationMiddleware)
+	r.Mount("/users", routers.NewUsersRouter())
+	r.Mount("/questions", routers.NewQuestionsRouter())
+	r.Mount("/share", routers.NewShareRouter())
+	r.Mount("/events", routers.NewEventsRouter())
+	r.Mount("/admin", routers.NewAdminRouter())
+	r.Mount("/moderation", routers.NewModerationRouter())
+	r.Mount("/reports", routers.NewReportsRouter())
+	httpServer := &http.Server{Addr: ":" + portFromEnv("PORT", "8080"), Handler: r}
+
+	grpcListener, err := net.Listen("tcp", ":"+portFromEnv("GRPC_PORT", "9090"))
+	if err != nil {
+		log.Fatalf("failed to listen for grpc %s", err)
+	}
+	grpcServer := rpc.NewGrimalkinServer()
+
+	go (&services.DigestService{}).RunSchedule(ctx)
+	go func() {
+		log.Printf("serving grpc on %s\n", grpcListener.Addr())
+		err := grpcServer.Serve(grpcListener)
+		if err != nil {
+			log.Printf("the grpc server stopped %s\n", err)
+			stop()
+		}
+	}()
+	go func() {
+		log.Printf("serving http on %s\n", httpServer.Addr)
+		err := httpServer.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("the http server stopped %s\n", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("shutting down\n")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = httpServer.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("failed to shut the http server down %s\n", err)
+	}
+	grpcServer.GracefulStop()
+}
+
+func portFromEnv(name string, fallback string) string {
+	port := os.Getenv(name)
+	if port == "" {
+		return fallback
+	}
+	return port
+}
@@ -4,11 +4,14 @@ import (
 	// "context"
 	// "log"
 	// "fmt"
+	"errors"
+	"inquisitive-grimalkin/data"
 	"inquisitive-grimalkin/utils"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/joho/godotenv"
@@ -32,6 +35,29 @@ var permissiblePathsWithNoAuthentication pathsRequringNoAuthentication= map[stri
 	"/users/login":true,
 	"/users/logout":true,
 	"/users/validate":true,
+	"/users/email/verify":true,
+	"/users/password/reset":true,
+	"/users/password/reset/confirm":true,
+}
+
+/*
+ * Routes under these prefixes carry their own proof of access, such as a signed link, instead of a token
+ */
+var permissiblePathPrefixesWithNoAuthentication = []string{
+	"/users/exports/",
+	"/share/",
+}
+
+func isPermissibleWithNoAuthentication(path string) bool {
+	if permissiblePathsWithNoAuthentication.Has(path) {
+		return true
+	}
+	for _, prefix := range permissiblePathPrefixesWithNoAuthentication {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 
@@ -39,18 +65,12 @@ func JwtAuthenticationMiddleware(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
-		if permissiblePathsWithNoAuthentication.Has(path) {
+		if isPermissibleWithNoAuthentication(path) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		authorizationHeader := r.Header.Get("Authorization")
-		log.Println(authorizationHeader)
-		authorizationMetadata := strings.Split(authorizationHeader, " ")
-		log.Println(authorizationMetadata)
-		// The first element of the array is the auth-scheme, can be either basic, bearer, etc.. (keep this so that it might be later used to provide authentication for diff. schemes)
-		_ = authorizationMetadata[0]
-		tokenString := authorizationMetadata[1]
+		tokenString := tokenFromRequest(r)
 
 		if tokenString == "" {
 			unauthorizedHander := UnAuthorizedHandler{}
@@ -58,24 +78,70 @@ func JwtAuthenticationMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		claims := jwt.MapClaims{}
-		_, err := jwt.ParseWithClaims(tokenString, claims, verifierWithKey)
+		claims, username, err := parseToken(tokenString)
+		if err != nil {
+			log.Printf("failed to authenticate the request to %s %s", path, err)
+			unauthorizedHander := UnAuthorizedHandler{}
+			unauthorizedHander.ServeHTTP(w, r)
+			return
+		}
+
+		revoked, err := isSessionRevoked(r.Context(), username, claims)
+		if err != nil {
+			log.Printf("failed to check if the session of %s was revoked %s", username, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			unauthorizedHander := UnAuthorizedHandler{}
+			unauthorizedHander.ServeHTTP(w, r)
+			return
+		}
+
+		status, err := accountStatus(r.Context(), username)
+		if errors.Is(err, data.ErrUserNotFound) {
+			unauthorizedHander := UnAuthorizedHandler{}
+			unauthorizedHander.ServeHTTP(w, r)
+			return
+		}
 		if err != nil {
-			log.Printf("failed to parse jwt %s", err)
+			log.Printf("failed to check if %s is suspended %s", username, err)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		username, ok := claims["username"].(string)
-		if !ok {
-			log.Printf("failed to retrieve username from token")
+		if status.IsSuspended(time.Now()) {
+			writeSuspended(w, status)
 			return
 		}
 
 		context := utils.ContextWithUsername(r.Context(), username)
+		context = utils.ContextWithRoles(context, rolesFromClaims(claims))
 		r = r.WithContext(context)
 		next.ServeHTTP(w, r)
 	})
 }
 
+/*
+ * Browsers cannot set headers on an EventSource, so the event stream also takes the token from ?access_token=. No other path does, a token in the url
+ * ends up in logs and browser histories.
+ */
+var pathsAcceptingTokenInQuery = map[string]bool{
+	"/events":  true,
+	"/events/": true,
+}
+
+func tokenFromRequest(r *http.Request) string {
+	authorizationMetadata := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	// The first element of the array is the auth-scheme, can be either basic, bearer, etc.. (keep this so that it might be later used to provide authentication for diff. schemes)
+	if len(authorizationMetadata) == 2 {
+		return authorizationMetadata[1]
+	}
+	if pathsAcceptingTokenInQuery[r.URL.Path] {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
 type UnAuthorizedHandler struct {}
 
 func (h *UnAuthorizedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)  {
@@ -85,4 +151,31 @@ func (h *UnAuthorizedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 
 func verifierWithKey(t *jwt.Token) (interface{}, error) {
 	return []byte(signingKey), nil	
-}
\ No newline at end of file
+}
+
+// The roles are optional in the token, a token without them belongs to a normal user
+func rolesFromClaims(claims jwt.MapClaims) []string {
+	claimedRoles, ok := claims["roles"].([]interface{})
+	if !ok {
+		return nil
+	}
+	roles := []string{}
+	for _, r := range claimedRoles {
+		if role, ok := r.(string); ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !utils.HasRole(r.Context(), role) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ * Keeps what the middleware looks up per user for ttl, so that it does not cost a round trip to Cassandra on every request. Whatever changes in the
+ * meantime is only seen once the entry expires.
+ */
+type ttlCache[V any] struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedEntry[V]
+}
+
+type cachedEntry[V any] struct {
+	value     V
+	fetchedOn time.Time
+}
+
+func newTTLCache[V any](ttl time.Duration) *ttlCache[V] {
+	return &ttlCache[V]{ttl: ttl, entries: map[string]cachedEntry[V]{}}
+}
+
+func (c *ttlCache[V]) get(key string, fetch func() (V, error)) (V, error) {
+	c.Lock()
+	cached, ok := c.entries[key]
+	c.Unlock()
+	if ok && time.Since(cached.fetchedOn) < c.ttl {
+		return cached.value, nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for cachedKey, e := range c.entries {
+		if time.Since(e.fetchedOn) >= c.ttl {
+			delete(c.entries, cachedKey)
+		}
+	}
+	c.entries[key] = cachedEntry[V]{value: value, fetchedOn: time.Now()}
+	return value, nil
+}
+
+func (c *ttlCache[V]) forget(key string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.entries, key)
+}
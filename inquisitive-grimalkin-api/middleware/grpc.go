@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/utils"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+/*
+ * The gRPC counterpart of JwtAuthenticationMiddleware, every method needs a token. The token is sent as "authorization: Bearer <token>" in the metadata
+ * and goes through the same checks, its signature, the revocations and the status of the account.
+ */
+func JwtUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := authenticateGrpc(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func JwtStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := authenticateGrpc(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// A ServerStream cannot be given a new context, so the authenticated one is handed out in place of the original
+type authenticatedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func authenticateGrpc(ctx context.Context) (context.Context, error) {
+	tokenString := tokenFromMetadata(ctx)
+	if tokenString == "" {
+		return nil, status.Error(codes.Unauthenticated, "the authorization metadata is missing")
+	}
+
+	claims, username, err := parseToken(tokenString)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	revoked, err := isSessionRevoked(ctx, username, claims)
+	if err != nil {
+		log.Printf("failed to check if the session of %s was revoked %s", username, err)
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to check the session %s", err))
+	}
+	if revoked {
+		return nil, status.Error(codes.Unauthenticated, "the session was revoked")
+	}
+
+	accountStatus, err := accountStatus(ctx, username)
+	if errors.Is(err, data.ErrUserNotFound) {
+		return nil, status.Error(codes.Unauthenticated, "the user of the token no longer exists")
+	}
+	if err != nil {
+		log.Printf("failed to check if %s is suspended %s", username, err)
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to check the account %s", err))
+	}
+	if accountStatus.Banned {
+		return nil, status.Error(codes.PermissionDenied, "the account is banned")
+	}
+	if accountStatus.IsSuspended(time.Now()) {
+		return nil, status.Error(codes.PermissionDenied, fmt.Sprintf("the account is suspended until %s", accountStatus.SuspendedUntil.Format(time.RFC3339)))
+	}
+
+	ctx = utils.ContextWithUsername(ctx, username)
+	ctx = utils.ContextWithRoles(ctx, rolesFromClaims(claims))
+	return ctx, nil
+}
+
+func tokenFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+	authorizationMetadata := strings.SplitN(values[0], " ", 2)
+	if len(authorizationMetadata) != 2 {
+		return ""
+	}
+	return authorizationMetadata[1]
+}
+
+type RateLimitedMethod struct {
+	Name  string
+	Limit RateLimit
+}
+
+/*
+ * The gRPC counterpart of RateLimitRoute, methods is keyed by the full name of the methods. A method given the same name as a route shares its buckets,
+ * so a client cannot double their limit by switching between the two APIs. It has to be chained after JwtUnaryInterceptor to see the user.
+ */
+func RateLimitUnaryInterceptor(methods map[string]RateLimitedMethod) grpc.UnaryServerInterceptor {
+	limits := map[string]RateLimitedMethod{}
+	for fullMethod, m := range methods {
+		m.Limit = rateLimitFromEnv(m.Name, m.Limit)
+		limits[fullMethod] = m
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		m, ok := limits[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		username, _ := utils.UserFromContext(ctx)
+		decision, err := takeRateLimitToken(ctx, m.Name+":user:"+username, m.Limit)
+		if err != nil {
+			log.Printf("failed to rate limit a call to %s, letting it through %s\n", info.FullMethod, err)
+			return handler(ctx, req)
+		}
+		if !decision.Allowed {
+			return nil, status.Error(codes.ResourceExhausted, fmt.Sprintf("too many requests, try again in %d seconds", ceilSeconds(decision.RetryAfter)))
+		}
+		return handler(ctx, req)
+	}
+}
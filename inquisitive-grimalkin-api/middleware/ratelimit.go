@@ -0,0 +1,274 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/utils"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Another instance saving the same bucket in between a read and a write is retried this many times before giving up
+const maxRateLimitAttempts = 3
+
+const memoryRateLimitSweepInterval = time.Minute
+
+// How long the clients of a StrictRateLimitRoute are told to wait when the store cannot be reached
+const rateLimitUnavailableRetryAfter = 30 * time.Second
+
+var ErrRateLimitContention = errors.New("the rate limit bucket kept changing while it was being updated")
+
+/*
+ * Requests is both the size of the bucket, the burst a client can make at once, and how many tokens it gets back over Per
+ */
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l RateLimit) tokensPerSecond() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+/*
+ * RetryAfter is how long until the next token is back and Reset how long until the bucket is full again
+ */
+type RateLimitDecision struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitDecision, error)
+}
+
+var rateLimitStore RateLimitStore
+
+/*
+ * RATE_LIMIT_BACKEND picks where the buckets live, cassandra shares them between the instances and anything else keeps them in the memory of each
+ * instance, which lets a client make as many requests per instance
+ */
+func init() {
+	godotenv.Load()
+	switch os.Getenv("RATE_LIMIT_BACKEND") {
+	case "cassandra":
+		rateLimitStore = &CassandraRateLimitStore{repository: data.NewCassandraRateLimitRepository()}
+	default:
+		rateLimitStore = NewMemoryRateLimitStore()
+	}
+}
+
+/*
+ * Refills the bucket for the time elapsed since it was last updated and takes a token out of it if there is one. A bucket that does not exist yet is
+ * full.
+ */
+func takeToken(b models.RateLimitBucket, exists bool, limit RateLimit, now time.Time) (models.RateLimitBucket, RateLimitDecision) {
+	capacity := float64(limit.Requests)
+	rate := limit.tokensPerSecond()
+
+	tokens := capacity
+	if exists {
+		elapsed := now.Sub(b.UpdatedOn).Seconds()
+		tokens = math.Min(capacity, b.Tokens+math.Max(0, elapsed)*rate)
+	}
+
+	decision := RateLimitDecision{}
+	if tokens >= 1 {
+		tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = secondsToDuration((1 - tokens) / rate)
+	}
+	decision.Remaining = int(math.Floor(tokens))
+	decision.Reset = secondsToDuration((capacity - tokens) / rate)
+
+	// Cassandra keeps milliseconds, the bucket is saved as it will be read back
+	return models.RateLimitBucket{Key: b.Key, Tokens: tokens, UpdatedOn: time.UnixMilli(now.UnixMilli())}, decision
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+type memoryBucket struct {
+	bucket models.RateLimitBucket
+	fullOn time.Time
+}
+
+type MemoryRateLimitStore struct {
+	sync.Mutex
+	buckets map[string]memoryBucket
+	sweptOn time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]memoryBucket{}}
+}
+
+// Buckets that are full again are dropped from time to time, they are no different from the ones that were never used
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit, now time.Time) (RateLimitDecision, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if now.Sub(s.sweptOn) >= memoryRateLimitSweepInterval {
+		for bucketKey, b := range s.buckets {
+			if !now.Before(b.fullOn) {
+				delete(s.buckets, bucketKey)
+			}
+		}
+		s.sweptOn = now
+	}
+
+	existing, exists := s.buckets[key]
+	bucket, decision := takeToken(existing.bucket, exists, limit, now)
+	bucket.Key = key
+	s.buckets[key] = memoryBucket{bucket: bucket, fullOn: now.Add(decision.Reset)}
+	return decision, nil
+}
+
+/*
+ * The bucket is read, refilled and written back only if no other request wrote it in the meantime, so that two instances never hand out the same
+ * token
+ */
+type CassandraRateLimitStore struct {
+	repository data.CassandraRateLimitRepository
+}
+
+func (s *CassandraRateLimitStore) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitDecision, error) {
+	for attempt := 0; attempt < maxRateLimitAttempts; attempt++ {
+		existing, exists, err := s.repository.GetBucket(ctx, key)
+		if err != nil {
+			return RateLimitDecision{}, err
+		}
+		bucket, decision := takeToken(existing, exists, limit, now)
+		bucket.Key = key
+		saved, err := s.repository.SaveBucket(ctx, bucket, existing.UpdatedOn, limit.Per)
+		if err != nil {
+			return RateLimitDecision{}, err
+		}
+		if saved {
+			return decision, nil
+		}
+	}
+	return RateLimitDecision{}, ErrRateLimitContention
+}
+
+/*
+ * A bucket that kept changing while it was being updated is taken by a burst of parallel requests, which is what the limits are there to stop, so
+ * the request is turned down like any other one over the limit. Only the errors of the store itself are returned.
+ */
+func takeRateLimitToken(ctx context.Context, key string, limit RateLimit) (RateLimitDecision, error) {
+	decision, err := rateLimitStore.Take(ctx, key, limit, time.Now())
+	if errors.Is(err, ErrRateLimitContention) {
+		return RateLimitDecision{RetryAfter: time.Second, Reset: time.Second}, nil
+	}
+	return decision, err
+}
+
+/*
+ * Limits the requests made to the route, per authenticated user or per IP for the requests made without a token. The name keeps the buckets of the
+ * routes apart, routes given the same name share their buckets, and RATE_LIMIT_<NAME> overrides the limit, written as requests/duration such as 30/1h.
+ *
+ * The middleware has to come after JwtAuthenticationMiddleware to see the user. It fails open, when the store cannot be reached the request is let
+ * through rather than failing everything that is rate limited, which is fine for the routes where a burst only costs some spam. Routes where a burst
+ * is an attack, like guessing passwords, use StrictRateLimitRoute instead. A bucket under contention is not a store that cannot be reached, see
+ * takeRateLimitToken.
+ */
+func RateLimitRoute(name string, limit RateLimit) func(http.Handler) http.Handler {
+	return rateLimitRoute(name, limit, false)
+}
+
+/*
+ * The same as RateLimitRoute except that it fails closed, when the store cannot be reached the request is turned down with a 503 instead of being let
+ * through without a limit
+ */
+func StrictRateLimitRoute(name string, limit RateLimit) func(http.Handler) http.Handler {
+	return rateLimitRoute(name, limit, true)
+}
+
+func rateLimitRoute(name string, limit RateLimit, failClosed bool) func(http.Handler) http.Handler {
+	limit = rateLimitFromEnv(name, limit)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decision, err := takeRateLimitToken(r.Context(), name+":"+rateLimitSubject(r), limit)
+			if err != nil && failClosed {
+				log.Printf("failed to rate limit a request to %s, turning it down %s\n", name, err)
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(rateLimitUnavailableRetryAfter)))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("the request cannot be rate limited right now, try again later"))
+				return
+			}
+			if err != nil {
+				log.Printf("failed to rate limit a request to %s, letting it through %s\n", name, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Per.Seconds())))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+			if !decision.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(fmt.Sprintf("too many requests, try again in %d seconds", ceilSeconds(decision.RetryAfter))))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+/*
+ * The address is the one the request came from, behind a proxy chi's RealIP middleware has to be mounted first for it to be the client's
+ */
+func rateLimitSubject(r *http.Request) string {
+	username, ok := utils.UserFromContext(r.Context())
+	if ok && username != "" {
+		return "user:" + username
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func rateLimitFromEnv(name string, fallback RateLimit) RateLimit {
+	variable := "RATE_LIMIT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	configured := os.Getenv(variable)
+	if configured == "" {
+		return fallback
+	}
+
+	requestsInString, perInString, _ := strings.Cut(configured, "/")
+	requests, err := strconv.Atoi(requestsInString)
+	if err != nil || requests <= 0 {
+		log.Printf("%s is not written as requests/duration, keeping %d/%s\n", variable, fallback.Requests, fallback.Per)
+		return fallback
+	}
+	per, err := time.ParseDuration(perInString)
+	if err != nil || per <= 0 {
+		log.Printf("%s is not written as requests/duration, keeping %d/%s\n", variable, fallback.Requests, fallback.Per)
+		return fallback
+	}
+	return RateLimit{Requests: requests, Per: per}
+}
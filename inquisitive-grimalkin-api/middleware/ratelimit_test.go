@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type unreachableRateLimitStore struct{}
+
+func (unreachableRateLimitStore) Take(context.Context, string, RateLimit, time.Time) (RateLimitDecision, error) {
+	return RateLimitDecision{}, errors.New("the store cannot be reached")
+}
+
+func useTestRateLimitStore(t *testing.T, store RateLimitStore) {
+	t.Helper()
+	previous := rateLimitStore
+	rateLimitStore = store
+	t.Cleanup(func() { rateLimitStore = previous })
+}
+
+func serveRateLimited(limiter func(http.Handler) http.Handler, reached *bool) *httptest.ResponseRecorder {
+	*reached = false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*reached = true
+		w.WriteHeader(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/users/login", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	res := httptest.NewRecorder()
+	limiter(next).ServeHTTP(res, req)
+	return res
+}
+
+func TestRateLimitRoute(t *testing.T) {
+	useTestRateLimitStore(t, NewMemoryRateLimitStore())
+	limiter := RateLimitRoute("test", RateLimit{Requests: 2, Per: time.Hour})
+
+	var reached bool
+	for i := 0; i < 2; i++ {
+		res := serveRateLimited(limiter, &reached)
+		if !reached || res.Code != http.StatusOK {
+			t.Fatalf("request %d got %d, want it let through", i, res.Code)
+		}
+	}
+	res := serveRateLimited(limiter, &reached)
+	if reached || res.Code != http.StatusTooManyRequests {
+		t.Fatalf("the request over the limit got %d, want 429", res.Code)
+	}
+	if res.Header().Get("Retry-After") == "" || res.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("the headers of the 429 are %v", res.Header())
+	}
+}
+
+// A store that cannot be reached lets the requests of RateLimitRoute through and turns down the ones of StrictRateLimitRoute
+func TestRateLimitRouteUnreachableStore(t *testing.T) {
+	useTestRateLimitStore(t, unreachableRateLimitStore{})
+	limit := RateLimit{Requests: 2, Per: time.Hour}
+
+	var reached bool
+	res := serveRateLimited(RateLimitRoute("test", limit), &reached)
+	if !reached || res.Code != http.StatusOK {
+		t.Errorf("RateLimitRoute got %d, want the request let through", res.Code)
+	}
+
+	res = serveRateLimited(StrictRateLimitRoute("test", limit), &reached)
+	if reached || res.Code != http.StatusServiceUnavailable {
+		t.Errorf("StrictRateLimitRoute got %d, want 503", res.Code)
+	}
+	if res.Header().Get("Retry-After") == "" {
+		t.Errorf("the 503 does not say when to try again")
+	}
+}
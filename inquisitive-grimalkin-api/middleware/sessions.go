@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"inquisitive-grimalkin/data"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// A token has to be replaced by logging in again once it expires, revoking the sessions only ends them earlier
+const tokenValidity = 30 * 24 * time.Hour
+
+/*
+ * Looking the revocation up on every request would add a round trip to Cassandra to each of them, so it is cached for a short while. A revoked session can
+ * therefore still be used for up to revocationCacheTTL after it was revoked.
+ */
+const revocationCacheTTL = 30 * time.Second
+
+var sessionsRepository = data.NewCassandraSessionsRepository()
+
+var revocationCache = newTTLCache[time.Time](revocationCacheTTL)
+
+var (
+	ErrInvalidToken     = errors.New("the token is not valid")
+	ErrTokenExpired     = errors.New("the token has expired")
+	ErrTokenWithoutUser = errors.New("the token does not name a user")
+)
+
+// The roles are the ones stored for the user, see rolesFromClaims
+func IssueToken(username string, roles []string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		`exp`:      now.Add(tokenValidity).Unix(),
+		`iat`:      now.Unix(),
+		`username`: username,
+	}
+	if len(roles) > 0 {
+		claims[`roles`] = roles
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(signingKey))
+}
+
+/*
+ * Checks the signature and the expiry of the token and hands back its claims along with the user it was issued to. A token without an expiry is
+ * rejected like an expired one, the tokens issued before they had one would otherwise never expire.
+ */
+func parseToken(tokenString string) (jwt.MapClaims, string, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, verifierWithKey)
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+		return nil, "", ErrTokenExpired
+	}
+	if err != nil {
+		return nil, "", ErrInvalidToken
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, "", ErrTokenExpired
+	}
+	username, ok := claims["username"].(string)
+	if !ok || username == "" {
+		return nil, "", ErrTokenWithoutUser
+	}
+	return claims, username, nil
+}
+
+/*
+ * Tokens issued before the sessions of the user were revoked are rejected, tokens that do not say when they were issued are treated as issued at the
+ * beginning of time
+ */
+func isSessionRevoked(ctx context.Context, username string, claims jwt.MapClaims) (bool, error) {
+	revokedBefore, err := sessionsRevokedBefore(ctx, username)
+	if err != nil {
+		return false, err
+	}
+	if revokedBefore.IsZero() {
+		return false, nil
+	}
+	issuedAt, _ := claims["iat"].(float64)
+	return int64(issuedAt) < revokedBefore.Unix(), nil
+}
+
+func sessionsRevokedBefore(ctx context.Context, username string) (time.Time, error) {
+	return revocationCache.get(username, func() (time.Time, error) {
+		return sessionsRepository.GetSessionsRevokedBefore(ctx, username)
+	})
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func useTestSigningKey(t *testing.T) {
+	t.Helper()
+	previous := signingKey
+	signingKey = "the signing key"
+	t.Cleanup(func() { signingKey = previous })
+}
+
+func signTestToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(signingKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestIssueTokenExpires(t *testing.T) {
+	useTestSigningKey(t)
+
+	token, err := IssueToken("grimalkin", []string{"moderator"})
+	if err != nil {
+		t.Fatalf("failed to issue the token %s", err)
+	}
+	claims, username, err := parseToken(token)
+	if err != nil {
+		t.Fatalf("the token just issued was refused %s", err)
+	}
+	if username != "grimalkin" {
+		t.Errorf("the token names %q, want grimalkin", username)
+	}
+	expiresOn := time.Unix(int64(claims["exp"].(float64)), 0)
+	if until := time.Until(expiresOn); until <= 0 || until > tokenValidity {
+		t.Errorf("the token expires in %s, want at most %s", until, tokenValidity)
+	}
+}
+
+func TestParseTokenRejections(t *testing.T) {
+	useTestSigningKey(t)
+	now := time.Now()
+
+	otherKeyToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		`exp`:      now.Add(time.Hour).Unix(),
+		`username`: "grimalkin",
+	}).SignedString([]byte("another key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{name: "expired", token: signTestToken(t, jwt.MapClaims{`exp`: now.Add(-time.Minute).Unix(), `username`: "grimalkin"}), want: ErrTokenExpired},
+		{name: "without expiry", token: signTestToken(t, jwt.MapClaims{`nba`: now.Add(time.Hour).Unix(), `username`: "grimalkin"}), want: ErrTokenExpired},
+		{name: "without user", token: signTestToken(t, jwt.MapClaims{`exp`: now.Add(time.Hour).Unix()}), want: ErrTokenWithoutUser},
+		{name: "signed with another key", token: otherKeyToken, want: ErrInvalidToken},
+		{name: "not a token", token: "not a token", want: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseToken(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// Every token that cannot be used has to come back as a 401, never as an empty 200
+func TestJwtAuthenticationMiddlewareRejectsTokens(t *testing.T) {
+	useTestSigningKey(t)
+	now := time.Now()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("the request reached the handler")
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{name: "no token", authorization: ""},
+		{name: "not a token", authorization: "Bearer not-a-token"},
+		{name: "expired", authorization: "Bearer " + signTestToken(t, jwt.MapClaims{`exp`: now.Add(-time.Minute).Unix(), `username`: "grimalkin"})},
+		{name: "without expiry", authorization: "Bearer " + signTestToken(t, jwt.MapClaims{`username`: "grimalkin"})},
+		{name: "without user", authorization: "Bearer " + signTestToken(t, jwt.MapClaims{`exp`: now.Add(time.Hour).Unix()})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res := httptest.NewRecorder()
+			JwtAuthenticationMiddleware(next).ServeHTTP(res, req)
+			if res.Code != http.StatusUnauthorized {
+				t.Errorf("got %d, want 401", res.Code)
+			}
+		})
+	}
+}
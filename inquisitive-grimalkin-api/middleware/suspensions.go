@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"net/http"
+	"time"
+)
+
+/*
+ * Like the revocations, the status of an account is cached for a short while, a suspension takes up to accountStatusCacheTTL to be enforced by the
+ * instances that did not apply it themselves
+ */
+const accountStatusCacheTTL = 30 * time.Second
+
+var usersRepository = data.NewCassandraUsersRepository()
+
+var accountStatusCache = newTTLCache[models.AccountStatus](accountStatusCacheTTL)
+
+/*
+ * A user that no longer exists gets data.ErrUserNotFound, their token must not be accepted, it would also authenticate whoever registers the username
+ * next. Only the existing users are cached.
+ */
+func accountStatus(ctx context.Context, username string) (models.AccountStatus, error) {
+	return accountStatusCache.get(username, func() (models.AccountStatus, error) {
+		return usersRepository.GetAccountStatus(ctx, username)
+	})
+}
+
+/*
+ * Drops the cached status of the user so that a change made through this instance is enforced right away
+ */
+func ForgetAccountStatus(username string) {
+	accountStatusCache.forget(username)
+}
+
+func writeSuspended(w http.ResponseWriter, status models.AccountStatus) {
+	message := "the account is suspended"
+	if status.Banned {
+		message = "the account is banned"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": message,
+		"status":  status,
+	})
+}
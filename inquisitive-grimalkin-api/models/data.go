@@ -1,10 +1,16 @@
 package models
 
 import (
+	"encoding/json"
 	"github.com/google/uuid"
 	"time"
 )
 
+const (
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type Question struct {
 	QuestionId uuid.UUID `json:"questionId,omitempty"`
 	Asked      string    `json:"asked"`
@@ -13,6 +19,18 @@ type Question struct {
 	Question   string    `json:"question"`
 }
 
+/*
+ * The asker of an anonymous question must never leave the server, whoever serializes the question gets it without the asker. Moderators that need the
+ * real asker go through AnonymousAsker instead.
+ */
+func (q Question) MarshalJSON() ([]byte, error) {
+	type question Question
+	if q.IsAnon {
+		q.Asker = ""
+	}
+	return json.Marshal(question(q))
+}
+
 type QAndA struct {
 	QuestionId uuid.UUID `json:"questionId,omitempty"`
 	Asked      string `json:"asked"`
@@ -23,11 +41,495 @@ type QAndA struct {
 	AnsweredOn time.Time
 }
 
+func (q QAndA) MarshalJSON() ([]byte, error) {
+	type qAndA QAndA
+	if q.IsAnon {
+		q.Asker = ""
+	}
+	return json.Marshal(qAndA(q))
+}
+
+type SharedQAndA struct {
+	QuestionId uuid.UUID `json:"questionId"`
+	Asked      string    `json:"asked"`
+	SharedOn   time.Time `json:"sharedOn"`
+	Permalink  string    `json:"permalink"`
+}
+
+type ShareRequest struct {
+	Asked string `json:"asked"`
+}
+
+type TweetIntent struct {
+	Url string `json:"url"`
+}
+
+const (
+	ProviderTwitter  = "twitter"
+	ProviderMastodon = "mastodon"
+)
+
+/*
+ * An account on another network the user allowed us to post to. The tokens never leave the server, they are only kept encrypted in the database and
+ * decrypted when something is posted.
+ */
+type LinkedAccount struct {
+	Username     string    `json:"username"`
+	Provider     string    `json:"provider"`
+	Handle       string    `json:"handle"`
+	InstanceUrl  string    `json:"instanceUrl,omitempty"`
+	AccessToken  string    `json:"accessToken,omitempty"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	ExpiresOn    time.Time `json:"expiresOn"`
+	LinkedOn     time.Time `json:"linkedOn"`
+}
+
+func (a LinkedAccount) MarshalJSON() ([]byte, error) {
+	type linkedAccount LinkedAccount
+	a.AccessToken = ""
+	a.RefreshToken = ""
+	return json.Marshal(linkedAccount(a))
+}
+
+const (
+	ShareJobPending   = "pending"
+	ShareJobDelivered = "delivered"
+	ShareJobFailed    = "failed"
+)
+
+// A q&a waiting to be posted, or already posted, to a linked account
+type ShareJob struct {
+	JobId      uuid.UUID `json:"jobId"`
+	Username   string    `json:"username"`
+	Provider   string    `json:"provider"`
+	QuestionId uuid.UUID `json:"questionId"`
+	Asked      string    `json:"asked"`
+	Status     string    `json:"status"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"lastError,omitempty"`
+	RemoteUrl  string    `json:"remoteUrl,omitempty"`
+	CreatedOn  time.Time `json:"createdOn"`
+}
+
+const (
+	ModerationAllow  = "allow"
+	ModerationHold   = "hold"
+	ModerationReject = "reject"
+)
+
+// The kinds of content that go through moderation, an answer update is held apart from a new answer since approving it does something else
+const (
+	ContentQuestion     = "question"
+	ContentAnswer       = "answer"
+	ContentAnswerUpdate = "answer_update"
+	ContentProfile      = "profile"
+)
+
+type ModerationVerdict struct {
+	Outcome string   `json:"outcome"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+/*
+ * Content a moderator has to look at before it goes anywhere, the payload is the question or the q&a exactly as it would have been saved
+ */
+type HeldContent struct {
+	HoldId   uuid.UUID       `json:"holdId"`
+	Kind     string          `json:"kind"`
+	Username string          `json:"username"`
+	Payload  json.RawMessage `json:"payload"`
+	Reasons  []string        `json:"reasons"`
+	HeldOn   time.Time       `json:"heldOn"`
+}
+
+const (
+	ReportTargetQAndA    = "q_and_a"
+	ReportTargetQuestion = "question"
+	ReportTargetUser     = "user"
+)
+
+const (
+	ReportSpam          = "spam"
+	ReportHarassment    = "harassment"
+	ReportHateSpeech    = "hate_speech"
+	ReportSexualContent = "sexual_content"
+	ReportSelfHarm      = "self_harm"
+	ReportImpersonation = "impersonation"
+	ReportOther         = "other"
+)
+
+const (
+	ReportOpen     = "open"
+	ReportClaimed  = "claimed"
+	ReportResolved = "resolved"
+)
+
+// What a moderator can do about a report, every action ends up in the audit log
+const (
+	ModerationActionDismiss       = "dismiss"
+	ModerationActionDeleteContent = "delete_content"
+	ModerationActionWarn          = "warn"
+	ModerationActionSuspend       = "suspend"
+)
+
+// The other moderator actions, recorded in the audit log as well
+const (
+	ModerationActionApproveHold = "approve_hold"
+	ModerationActionRejectHold  = "reject_hold"
+	ModerationActionRevealAsker = "reveal_asker"
+	ModerationActionBan         = "ban"
+	ModerationActionReinstate   = "reinstate"
+)
+
+/*
+ * A suspended account keeps its data but cannot use the api until SuspendedUntil, a banned one cannot use it ever again. Clearing both reinstates the
+ * account.
+ */
+type AccountStatus struct {
+	Username       string    `json:"username"`
+	SuspendedUntil time.Time `json:"suspendedUntil"`
+	Banned         bool      `json:"banned"`
+	Reason         string    `json:"reason,omitempty"`
+}
+
+func (s AccountStatus) IsSuspended(now time.Time) bool {
+	return s.Banned || now.Before(s.SuspendedUntil)
+}
+
+/*
+ * TargetId is the id of the q&a or of the question and is empty when a user is reported, TargetOwner is the user the content belongs to, the one who
+ * answered a q&a, the one who received a question or the reported user
+ */
+type Report struct {
+	ReportId    uuid.UUID `json:"reportId"`
+	Reporter    string    `json:"reporter"`
+	TargetType  string    `json:"targetType"`
+	TargetId    uuid.UUID `json:"targetId"`
+	TargetOwner string    `json:"targetOwner"`
+	Reason      string    `json:"reason"`
+	Details     string    `json:"details,omitempty"`
+	Status      string    `json:"status"`
+	ClaimedBy   string    `json:"claimedBy,omitempty"`
+	ClaimedOn   time.Time `json:"claimedOn"`
+	Resolution  string    `json:"resolution,omitempty"`
+	Note        string    `json:"note,omitempty"`
+	ResolvedOn  time.Time `json:"resolvedOn"`
+	CreatedOn   time.Time `json:"createdOn"`
+}
+
+// SuspensionDays is only read when the action is suspend, the suspension lasts a week when it is left out
+type ReportResolution struct {
+	Action         string `json:"action"`
+	Note           string `json:"note"`
+	SuspensionDays *int   `json:"suspensionDays,omitempty"`
+}
+
+type AuditEntry struct {
+	EntryId     uuid.UUID `json:"entryId"`
+	Moderator   string    `json:"moderator"`
+	Action      string    `json:"action"`
+	TargetType  string    `json:"targetType"`
+	TargetId    uuid.UUID `json:"targetId"`
+	TargetOwner string    `json:"targetOwner"`
+	ReportId    uuid.UUID `json:"reportId"`
+	Note        string    `json:"note,omitempty"`
+	CreatedOn   time.Time `json:"createdOn"`
+}
+
+// AnonymousAsker is only handed to moderators investigating abuse
+type AnonymousAsker struct {
+	QuestionId uuid.UUID `json:"questionId"`
+	Asker      string    `json:"asker"`
+}
+
 type User struct {
 	Username string `json:"username"`
-	Password string `json:"password"`
+	Password string `json:"password,omitempty"`
 	Email string `json:"email"`
+	PendingEmail string `json:"pendingEmail,omitempty"`
+	EmailVerified bool `json:"emailVerified"`
 	FirstName string `json:"firstName"`
 	LastName string `json:"lastName"`
-	Roles []string 
+	JoinedOn time.Time `json:"joinedOn"`
+	// Granted in the users table, never taken from a request, and carried in the token issued at login
+	Roles []string `json:"roles,omitempty"`
+}
+
+// PublicUser is what anyone can see about a user, it is safe to hand out to people who are not the user
+type PublicUser struct {
+	Username  string `json:"username"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Followers int64  `json:"followers"`
+}
+
+/*
+ * The profile as seen by the caller, FollowedByYou and FollowsYou describe how the caller and the user are related and are always false on the profile
+ * of the caller themselves
+ */
+type PublicProfile struct {
+	Username      string    `json:"username"`
+	FirstName     string    `json:"firstName"`
+	LastName      string    `json:"lastName"`
+	JoinedOn      time.Time `json:"joinedOn"`
+	Followers     int64     `json:"followers"`
+	Following     int64     `json:"following"`
+	Answers       int64     `json:"answers"`
+	FollowedByYou bool      `json:"followedByYou"`
+	FollowsYou    bool      `json:"followsYou"`
+}
+
+type UserSearchPage struct {
+	Users    []PublicUser `json:"users"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"pageSize"`
+	HasMore  bool         `json:"hasMore"`
+}
+
+/*
+ * Only the fields that are sent are changed, a missing field keeps its current value
+ */
+type ProfileUpdate struct {
+	Email     *string `json:"email"`
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+}
+
+const (
+	TokenEmailVerification = "email_verification"
+	TokenPasswordReset     = "password_reset"
+)
+
+/*
+ * A token mailed to the user to prove they own the email it was sent to, it is bound to that email so that it stops working once the email changes
+ */
+type AccountToken struct {
+	Purpose   string
+	Username  string
+	Email     string
+	CreatedOn time.Time
+}
+
+type EmailVerification struct {
+	Token string `json:"token"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type PasswordReset struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+type PasswordChange struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+/*
+ * The settings are owned by the asked user and are checked every time someone tries to drop a question in their inbox. A user who never touched their
+ * settings gets the defaults, which keep the inbox open to everyone.
+ */
+type UserSettings struct {
+	Username                        string `json:"username"`
+	AcceptAnonymousQuestions        bool   `json:"acceptAnonymousQuestions"`
+	AcceptQuestionsOnlyFromFollowed bool   `json:"acceptQuestionsOnlyFromFollowed"`
+	InboxPaused                     bool   `json:"inboxPaused"`
+}
+
+func DefaultUserSettings(username string) UserSettings {
+	return UserSettings{
+		Username:                        username,
+		AcceptAnonymousQuestions:        true,
+		AcceptQuestionsOnlyFromFollowed: false,
+		InboxPaused:                     false,
+	}
+}
+
+/*
+ * Only the settings that are sent are changed, a missing one keeps its stored value instead of being turned off
+ */
+type SettingsUpdate struct {
+	AcceptAnonymousQuestions        *bool `json:"acceptAnonymousQuestions"`
+	AcceptQuestionsOnlyFromFollowed *bool `json:"acceptQuestionsOnlyFromFollowed"`
+	InboxPaused                     *bool `json:"inboxPaused"`
+}
+
+func (u SettingsUpdate) Apply(s UserSettings) UserSettings {
+	if u.AcceptAnonymousQuestions != nil {
+		s.AcceptAnonymousQuestions = *u.AcceptAnonymousQuestions
+	}
+	if u.AcceptQuestionsOnlyFromFollowed != nil {
+		s.AcceptQuestionsOnlyFromFollowed = *u.AcceptQuestionsOnlyFromFollowed
+	}
+	if u.InboxPaused != nil {
+		s.InboxPaused = *u.InboxPaused
+	}
+	return s
+}
+
+/*
+ * A question seen from the side of the person who asked it, the asker is left out since it is always the owner of the list
+ */
+type AskedQuestion struct {
+	QuestionId uuid.UUID `json:"questionId"`
+	Asked      string    `json:"asked"`
+	IsAnon     bool      `json:"isAnon"`
+	Question   string    `json:"question"`
+	Answered   bool      `json:"answered"`
+}
+
+const (
+	ErasurePending   = "pending"
+	ErasureCompleted = "completed"
+	ErasureFailed    = "failed"
+)
+
+/*
+ * The record outlives the user it is about, so it only keeps a hash of the username as the subject and what was done in each step of the erasure
+ */
+type ErasureRecord struct {
+	ErasureId   uuid.UUID `json:"erasureId"`
+	Subject     string    `json:"subject"`
+	Status      string    `json:"status"`
+	RequestedOn time.Time `json:"requestedOn"`
+	CompletedOn time.Time `json:"completedOn,omitempty"`
+	Steps       []string  `json:"steps"`
+}
+
+const (
+	ExportPending   = "pending"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+)
+
+/*
+ * The download url is only handed out once the archive is built and it stops working after ExpiresOn
+ */
+type DataExport struct {
+	ExportId    uuid.UUID `json:"exportId"`
+	Username    string    `json:"username"`
+	Status      string    `json:"status"`
+	RequestedOn time.Time `json:"requestedOn"`
+	CompletedOn time.Time `json:"completedOn,omitempty"`
+	DownloadUrl string    `json:"downloadUrl,omitempty"`
+	ExpiresOn   time.Time `json:"expiresOn,omitempty"`
+}
+
+/*
+ * A token bucket of the rate limiter, Tokens is what was left in it at UpdatedOn, the bucket refills from there on
+ */
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	UpdatedOn time.Time
+}
+
+/*
+ * A question the spam detector kept out of the inbox, the recipient can release it into their inbox or discard it. The question hides its asker when it
+ * is anonymous, like everywhere else.
+ */
+type QuarantinedQuestion struct {
+	Question      Question  `json:"question"`
+	Reasons       []string  `json:"reasons"`
+	QuarantinedOn time.Time `json:"quarantinedOn"`
+}
+
+// What the spam detector remembers of every question for a little while, Fingerprint is the SimHash of the text
+type RecentQuestion struct {
+	Asker       string
+	Asked       string
+	AskedOn     time.Time
+	Fingerprint uint64
+}
+
+const (
+	NotificationQuestion = "question"
+	NotificationAnswer   = "answer"
+	NotificationLike     = "like"
+	NotificationFollow   = "follow"
+)
+
+/*
+ * Actor is who caused the notification and is empty for anonymous questions. Likes of the same q&a are gathered in one notification until it is read,
+ * Actor is then the latest of them and Count how many there were.
+ */
+type Notification struct {
+	NotificationId uuid.UUID `json:"notificationId"`
+	Username       string    `json:"-"`
+	Kind           string    `json:"kind"`
+	Actor          string    `json:"actor,omitempty"`
+	QuestionId     uuid.UUID `json:"questionId"`
+	Count          int       `json:"count"`
+	Read           bool      `json:"read"`
+	CreatedOn      time.Time `json:"createdOn"`
+	Message        string    `json:"message"`
+	// Everyone gathered in a like notification, Count is how many of them there are
+	Likers []string `json:"-"`
+}
+
+const (
+	EventQuestion = "question"
+	EventAnswer   = "answer"
+	EventLike     = "like"
+)
+
+/*
+ * What is pushed to the connected clients, Payload is a question for EventQuestion, a q&a for EventAnswer and a LikeEvent for EventLike
+ */
+type Event struct {
+	Kind    string `json:"kind"`
+	Payload any    `json:"payload"`
+}
+
+type LikeEvent struct {
+	QuestionId uuid.UUID `json:"questionId"`
+	Liker      string    `json:"liker"`
+}
+
+/*
+ * Shaped like PushSubscription.toJSON() in the browser so that the webapp can send it as is. P256dh is the public key of the browser and Auth the
+ * secret it shares with us, both base64url encoded.
+ */
+type PushSubscription struct {
+	Endpoint  string               `json:"endpoint"`
+	Keys      PushSubscriptionKeys `json:"keys"`
+	Username  string               `json:"-"`
+	CreatedOn time.Time            `json:"createdOn"`
+}
+
+type PushSubscriptionKeys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+/*
+ * Whether the user wants the email digest and when the last one was sent, a digest covers what happened since LastSentOn. ClaimedUntil is set while
+ * an instance is sending the digest so that no other instance sends it too.
+ */
+type DigestState struct {
+	Username     string    `json:"-"`
+	OptedIn      bool      `json:"optedIn"`
+	LastSentOn   time.Time `json:"lastSentOn"`
+	ClaimedUntil time.Time `json:"-"`
+}
+
+// A q&a on the timeline of a follower along with how many likes it has
+type TimelineQAndA struct {
+	QAndA
+	Likes int64 `json:"likes"`
+}
+
+// QAndA.MarshalJSON would be promoted and leave the likes out, the fields of both are written side by side instead
+func (t TimelineQAndA) MarshalJSON() ([]byte, error) {
+	type qAndA QAndA
+	if t.IsAnon {
+		t.Asker = ""
+	}
+	return json.Marshal(struct {
+		qAndA
+		Likes int64 `json:"likes"`
+	}{qAndA(t.QAndA), t.Likes})
 }
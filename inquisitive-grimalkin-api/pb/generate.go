@@ -0,0 +1,4 @@
+// Package pb holds the gRPC API, the code is generated from grimalkin.proto and is never edited by hand
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative grimalkin.proto
@@ -0,0 +1,105 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/middleware"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/services"
+	"inquisitive-grimalkin/utils"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+/*
+ * Every route here is only for admins
+ */
+type AdminRouter struct {
+	chi.Router
+	suspensionService services.SuspensionService
+}
+
+func NewAdminRouter() AdminRouter {
+	r := chi.NewRouter()
+	adminRouter := AdminRouter{
+		Router: r,
+	}
+
+	r.Use(middleware.RequireRole(models.RoleAdmin))
+	r.Get("/users/{username}/status", adminRouter.GetAccountStatus())
+	r.Put("/users/{username}/status", adminRouter.SetAccountStatus())
+
+	return adminRouter
+}
+
+func (router *AdminRouter) GetAccountStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := chi.URLParam(r, "username")
+		status, err := router.suspensionService.GetAccountStatus(r.Context(), username)
+		writeAccountStatus(w, status, username, err)
+	}
+}
+
+/*
+ * The body is a models.AccountStatus, {"banned": true} bans the user, a suspendedUntil in the future suspends them and an empty object reinstates
+ * them
+ */
+func (router *AdminRouter) SetAccountStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		username := chi.URLParam(r, "username")
+		admin, _ := utils.UserFromContext(r.Context())
+		statusInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to parse the request body to bytes %s", err)))
+			return
+		}
+
+		var status models.AccountStatus
+		err = json.Unmarshal(statusInBytes, &status)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to account status object %s", err)))
+			return
+		}
+
+		status, err = router.suspensionService.SetAccountStatus(r.Context(), admin, username, status)
+		var validationErrs utils.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			writeValidationErrors(w, validationErrs)
+			return
+		}
+		if err == nil {
+			middleware.ForgetAccountStatus(username)
+		}
+		writeAccountStatus(w, status, username, err)
+	}
+}
+
+func writeAccountStatus(w http.ResponseWriter, status models.AccountStatus, username string, err error) {
+	if errors.Is(err, data.ErrUserNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("failed to find the user %s %s", username, err)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to change the status of %s %s", username, err)))
+		return
+	}
+
+	statusInBytes, err := json.Marshal(status)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to change the status of %s %s", username, err)))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(statusInBytes)
+}
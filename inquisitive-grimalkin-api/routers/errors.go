@@ -0,0 +1,19 @@
+package routers
+
+import (
+	"encoding/json"
+	"inquisitive-grimalkin/utils"
+	"net/http"
+)
+
+func writeValidationErrors(w http.ResponseWriter, errs utils.ValidationErrors) {
+	errsInBytes, err := json.Marshal(errs)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(errsInBytes)
+}
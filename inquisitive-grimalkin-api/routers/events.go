@@ -0,0 +1,104 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/services"
+	"inquisitive-grimalkin/utils"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Keeps proxies from closing a stream that has been quiet for a while
+const eventStreamHeartbeat = 25 * time.Second
+
+type EventsRouter struct {
+	chi.Router
+	eventsService services.EventsService
+}
+
+func NewEventsRouter() EventsRouter {
+	r := chi.NewRouter()
+	eventsRouter := EventsRouter{
+		Router: r,
+	}
+
+	r.Get("/", eventsRouter.Stream())
+
+	return eventsRouter
+}
+
+/*
+ * A Server-Sent Events stream of the events of the authenticated user, each event is named after its kind and carries its payload as JSON. When the
+ * client falls too far behind an overflow event is sent and the stream is closed, the client has to fetch what it shows again before reconnecting.
+ */
+func (router *EventsRouter) Stream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, _ := utils.UserFromContext(r.Context())
+		subscription, err := router.eventsService.Subscribe(username)
+		if errors.Is(err, services.ErrUnauthenticated) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, services.ErrTooManySubscriptions) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to open the event stream %s", err)))
+			return
+		}
+		defer router.eventsService.Unsubscribe(subscription)
+
+		// The stream outlives any write timeout of the server
+		controller := http.NewResponseController(w)
+		controller.SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "retry: 5000\n\n")
+		err = controller.Flush()
+		if err != nil {
+			log.Printf("failed to open the event stream of %s %s\n", username, err)
+			return
+		}
+
+		heartbeat := time.NewTicker(eventStreamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			case e, ok := <-subscription.Events:
+				if !ok {
+					if subscription.Overflowed() {
+						fmt.Fprint(w, "event: overflow\ndata: {}\n\n")
+						controller.Flush()
+					}
+					return
+				}
+				payloadInBytes, err := json.Marshal(e.Payload)
+				if err != nil {
+					log.Printf("failed to send a %s event to %s %s\n", e.Kind, username, err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, payloadInBytes)
+			}
+			err = controller.Flush()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
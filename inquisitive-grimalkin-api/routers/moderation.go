@@ -0,0 +1,231 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/middleware"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/services"
+	"inquisitive-grimalkin/utils"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+/*
+ * Every route here is only for moderators
+ */
+type ModerationRouter struct {
+	chi.Router
+	moderationService services.ModerationService
+	reportsService    services.ReportsService
+}
+
+func NewModerationRouter() ModerationRouter {
+	r := chi.NewRouter()
+	moderationRouter := ModerationRouter{
+		Router: r,
+	}
+
+	r.Use(middleware.RequireRole(models.RoleModerator))
+	r.Get("/holds", moderationRouter.GetHolds())
+	r.Post("/holds/{hold_id}/approve", moderationRouter.ApproveHold())
+	r.Post("/holds/{hold_id}/reject", moderationRouter.RejectHold())
+	r.Get("/reports", moderationRouter.GetReports())
+	r.Post("/reports/{report_id}/claim", moderationRouter.ClaimReport())
+	r.Post("/reports/{report_id}/resolve", moderationRouter.ResolveReport())
+	r.Get("/audit", moderationRouter.GetAuditLog())
+
+	return moderationRouter
+}
+
+func (router *ModerationRouter) GetHolds() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		holds, err := router.moderationService.GetHolds(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the held content %s", err)))
+			return
+		}
+
+		holdsInBytes, err := json.Marshal(holds)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the held content %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(holdsInBytes)
+	}
+}
+
+func (router *ModerationRouter) ApproveHold() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		holdId := chi.URLParam(r, "hold_id")
+		moderator, _ := utils.UserFromContext(r.Context())
+		h, err := router.moderationService.ApproveHold(r.Context(), moderator, holdId)
+		writeReviewedHold(w, h, holdId, err)
+	}
+}
+
+func (router *ModerationRouter) RejectHold() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		holdId := chi.URLParam(r, "hold_id")
+		moderator, _ := utils.UserFromContext(r.Context())
+		h, err := router.moderationService.RejectHold(r.Context(), moderator, holdId)
+		writeReviewedHold(w, h, holdId, err)
+	}
+}
+
+func writeReviewedHold(w http.ResponseWriter, h models.HeldContent, holdId string, err error) {
+	if errors.Is(err, data.ErrHoldNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("failed to review the held content with id %s %s", holdId, err)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to review the held content with id %s %s", holdId, err)))
+		return
+	}
+
+	holdInBytes, err := json.Marshal(h)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to review the held content with id %s %s", holdId, err)))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(holdInBytes)
+}
+
+// ?status= picks the queue, open reports are listed when it is left out
+func (router *ModerationRouter) GetReports() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reports, err := router.reportsService.GetReports(r.Context(), r.URL.Query().Get("status"))
+		if errors.Is(err, services.ErrInvalidReportStatus) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the reports %s", err)))
+			return
+		}
+
+		reportsInBytes, err := json.Marshal(reports)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the reports %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(reportsInBytes)
+	}
+}
+
+func (router *ModerationRouter) ClaimReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reportId := chi.URLParam(r, "report_id")
+		moderator, _ := utils.UserFromContext(r.Context())
+		report, err := router.reportsService.ClaimReport(r.Context(), moderator, reportId)
+		writeReport(w, report, reportId, err)
+	}
+}
+
+/*
+ * The body is a models.ReportResolution, the report has to be claimed by the moderator resolving it
+ */
+func (router *ModerationRouter) ResolveReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		reportId := chi.URLParam(r, "report_id")
+		moderator, _ := utils.UserFromContext(r.Context())
+		resolutionInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to parse the request body to bytes %s", err)))
+			return
+		}
+
+		var resolution models.ReportResolution
+		err = json.Unmarshal(resolutionInBytes, &resolution)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to resolution object %s", err)))
+			return
+		}
+
+		report, err := router.reportsService.ResolveReport(r.Context(), moderator, reportId, resolution)
+		var validationErrs utils.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			writeValidationErrors(w, validationErrs)
+			return
+		}
+		writeReport(w, report, reportId, err)
+	}
+}
+
+// ?day=2006-01-02 picks the day in UTC, today is shown when it is left out
+func (router *ModerationRouter) GetAuditLog() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		day := time.Now()
+		if r.URL.Query().Has("day") {
+			var err error
+			day, err = time.Parse("2006-01-02", r.URL.Query().Get("day"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("the day has to be written as YYYY-MM-DD %s", err)))
+				return
+			}
+		}
+
+		entries, err := router.reportsService.GetAuditLog(r.Context(), day)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the audit log %s", err)))
+			return
+		}
+
+		entriesInBytes, err := json.Marshal(entries)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the audit log %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(entriesInBytes)
+	}
+}
+
+func writeReport(w http.ResponseWriter, report models.Report, reportId string, err error) {
+	switch {
+	case errors.Is(err, data.ErrReportNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("failed to moderate the report with id %s %s", reportId, err)))
+		return
+	case errors.Is(err, data.ErrReportAlreadyClaimed), errors.Is(err, data.ErrReportNotClaimed), errors.Is(err, data.ErrReportBeingResolved):
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("failed to moderate the report with id %s %s", reportId, err)))
+		return
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to moderate the report with id %s %s", reportId, err)))
+		return
+	}
+
+	reportInBytes, err := json.Marshal(report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to moderate the report with id %s %s", reportId, err)))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(reportInBytes)
+}
@@ -1,17 +1,29 @@
 package routers
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/middleware"
 	"inquisitive-grimalkin/models"
 	"inquisitive-grimalkin/services"
+	"inquisitive-grimalkin/utils"
 	"io"
 	"log"
 	"net/http"
+	"time"
+)
+
+/*
+ * Defaults of the rate limits, RATE_LIMIT_ASK and RATE_LIMIT_LIKE override them. Liking and unliking share their limit so that toggling a like does
+ * not get around it.
+ */
+var (
+	askRateLimit  = middleware.RateLimit{Requests: 30, Per: time.Hour}
+	likeRateLimit = middleware.RateLimit{Requests: 120, Per: 10 * time.Minute}
 )
 
 type QuestionsRouter struct {
@@ -19,6 +31,9 @@ type QuestionsRouter struct {
 	questionsRepository data.CassandraQuestionsRepository
 	likesRepository     data.CassandraLikesRepository
 	questionsService    services.QuestionsService
+	shareService        services.ShareService
+	crossPostService    services.CrossPostService
+	likesService        services.LikesService
 }
 
 func NewQuestionsRouter() QuestionsRouter {
@@ -32,24 +47,50 @@ func NewQuestionsRouter() QuestionsRouter {
 	}
 
 	r.Get("/", questionsRouter.GetUnansweredQuestions())
-	r.Post("/", questionsRouter.Ask())
+	r.With(middleware.RateLimitRoute("ask", askRateLimit)).Post("/", questionsRouter.Ask())
+
+	r.Get("/quarantine", questionsRouter.GetQuarantinedQuestions())
+	r.Post("/quarantine/{question_id}/release", questionsRouter.ReleaseQuarantinedQuestion())
+	r.Delete("/quarantine/{question_id}", questionsRouter.DiscardQuarantinedQuestion())
 
 	r.Post("/{question_id}/answer/", questionsRouter.AnswerQuestion())
 	r.Put("/{question_id}/", questionsRouter.UpdateAnswer())
 	r.Delete("/{question_id}", questionsRouter.DeleteQAndA())
 
 	r.Get("/{question_id}/likes", questionsRouter.GetLikesForQAndA())
-	r.Put("/{question_id}/like", questionsRouter.LikeQAndA())
-	r.Put("/{question_id}/unlike", questionsRouter.UnlikeQAndA())
+	r.With(middleware.RateLimitRoute("like", likeRateLimit)).Put("/{question_id}/like", questionsRouter.LikeQAndA())
+	r.With(middleware.RateLimitRoute("like", likeRateLimit)).Put("/{question_id}/unlike", questionsRouter.UnlikeQAndA())
 
 	r.Post("/{question_id}/share/", questionsRouter.Share())
 	r.Post("/{question_id}/share/twitter", questionsRouter.ShareToTwitter())
+	r.Post("/{question_id}/share/{provider}", questionsRouter.CrossPost())
+
+	r.With(middleware.RequireRole(models.RoleModerator)).Get("/{question_id}/asker", questionsRouter.RevealAnonymousAsker())
 
 	return questionsRouter
 }
 
-func (r *QuestionsRouter) GetUnansweredQuestions() http.HandlerFunc {
+func (router *QuestionsRouter) GetUnansweredQuestions() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		questions, err := router.questionsService.GetUnansweredQuestions(r.Context())
+		if errors.Is(err, services.ErrUnauthenticated) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the unanswered questions %s", err)))
+			return
+		}
+
+		questionsInBytes, err := json.Marshal(questions)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the unanswered questions %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(questionsInBytes)
 	}
 }
 
@@ -72,10 +113,19 @@ func (router *QuestionsRouter) Ask() http.HandlerFunc {
 			return
 		}
 
-		context := context.TODO()
-		q, err := router.questionsService.Ask(context, question)
+		q, err := router.questionsService.Ask(r.Context(), question)
+		var validationErrs utils.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			writeValidationErrors(w, validationErrs)
+			return
+		}
+		if errors.Is(err, services.ErrHeldForReview) {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(err.Error()))
+			return
+		}
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(statusForAskError(err))
 			w.Write([]byte(err.Error()))
 			return
 		}
@@ -92,12 +142,136 @@ func (router *QuestionsRouter) Ask() http.HandlerFunc {
 	}
 }
 
-func (r *QuestionsRouter) UpdateAnswer() http.HandlerFunc {
+// The questions the spam detector kept out of the inbox of the authenticated user
+func (router *QuestionsRouter) GetQuarantinedQuestions() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		questions, err := router.questionsService.GetQuarantinedQuestions(r.Context())
+		if errors.Is(err, services.ErrUnauthenticated) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the quarantined questions %s", err)))
+			return
+		}
 
+		questionsInBytes, err := json.Marshal(questions)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the quarantined questions %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(questionsInBytes)
 	}
 }
 
+func (router *QuestionsRouter) ReleaseQuarantinedQuestion() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionUuidInString := chi.URLParam(r, "question_id")
+		q, err := router.questionsService.ReleaseQuarantinedQuestion(r.Context(), questionUuidInString)
+		if !writeQuarantineError(w, err, questionUuidInString) {
+			return
+		}
+
+		questionInBytes, err := json.Marshal(q)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to release the question with id %s %s", questionUuidInString, err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(questionInBytes)
+	}
+}
+
+func (router *QuestionsRouter) DiscardQuarantinedQuestion() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionUuidInString := chi.URLParam(r, "question_id")
+		err := router.questionsService.DiscardQuarantinedQuestion(r.Context(), questionUuidInString)
+		if !writeQuarantineError(w, err, questionUuidInString) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Writes the error if there is one and tells whether the handler can carry on
+func writeQuarantineError(w http.ResponseWriter, err error, questionUuidInString string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrUnauthenticated):
+		w.WriteHeader(http.StatusUnauthorized)
+	case errors.Is(err, data.ErrQuarantinedQuestionNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("failed to find the quarantined question with id %s %s", questionUuidInString, err)))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to review the quarantined question with id %s %s", questionUuidInString, err)))
+	}
+	return false
+}
+
+func (router *QuestionsRouter) UpdateAnswer() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		qAndAUuidInString := chi.URLParam(r, "question_id")
+		reqInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to update the answer with id %s %s", qAndAUuidInString, err)))
+			return
+		}
+
+		var qAndA models.QAndA
+		err = json.Unmarshal(reqInBytes, &qAndA)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to update the answer with id %s %s", qAndAUuidInString, err)))
+			return
+		}
+
+		qAndA, err = router.questionsService.UpdateAnswer(r.Context(), qAndAUuidInString, qAndA)
+		if !writeAnswerError(w, err, qAndAUuidInString) {
+			return
+		}
+
+		marshalledQAndA, err := json.Marshal(qAndA)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to update the answer with id %s %s", qAndAUuidInString, err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(marshalledQAndA)
+	}
+}
+
+// Writes the response for a failed answer and tells whether the handler can carry on
+func writeAnswerError(w http.ResponseWriter, err error, questionUuidInString string) bool {
+	var validationErrs utils.ValidationErrors
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &validationErrs):
+		writeValidationErrors(w, validationErrs)
+	case errors.Is(err, services.ErrHeldForReview):
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(err.Error()))
+	case errors.Is(err, services.ErrUnauthenticated):
+		w.WriteHeader(http.StatusUnauthorized)
+	case errors.Is(err, data.ErrQuestionNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("failed to answer the question with id %s %s", questionUuidInString, err)))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to answer the question with id %s %s", questionUuidInString, err)))
+	}
+	return false
+}
+
 func (r *QuestionsRouter) DeleteQAndA() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -107,7 +281,6 @@ func (r *QuestionsRouter) DeleteQAndA() http.HandlerFunc {
 func (router *QuestionsRouter) AnswerQuestion() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
-		context := context.TODO()
 		questionUuidInString := chi.URLParam(r, "question_id")
 		reqInBytes, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -124,10 +297,8 @@ func (router *QuestionsRouter) AnswerQuestion() http.HandlerFunc {
 			return
 		}
 
-		qAndA, err = router.questionsService.AnswerQuestion(context, questionUuidInString, qAndA)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("failed to answer the question with id %s %s", questionUuidInString, err)))
+		qAndA, err = router.questionsService.AnswerQuestion(r.Context(), questionUuidInString, qAndA)
+		if !writeAnswerError(w, err, questionUuidInString) {
 			return
 		}
 
@@ -148,8 +319,13 @@ func (router *QuestionsRouter) AnswerQuestion() http.HandlerFunc {
 func (router *QuestionsRouter) GetLikesForQAndA() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		questionId := chi.URLParam(r, "question_id")
-		questionUuid := uuid.MustParse(questionId)
-		likes, err := router.likesRepository.GetLikesForQAndA(context.TODO(), questionUuid)
+		questionUuid, err := uuid.Parse(questionId)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("the q&a id %s is not valid", questionId)))
+			return
+		}
+		likes, err := router.likesRepository.GetLikesForQAndA(r.Context(), questionUuid)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(fmt.Sprintf("failed to fetch likes for answer with id %s %s", questionId, err)))
@@ -160,11 +336,20 @@ func (router *QuestionsRouter) GetLikesForQAndA() http.HandlerFunc {
 	}
 }
 
+/*
+ * The user who answered the q&a is found from its id and notified of the like
+ */
 func (router *QuestionsRouter) LikeQAndA() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		questionId := chi.URLParam(r, "question_id")
-		questionUuid := uuid.MustParse(questionId)
-		err := router.likesRepository.LikeQAndA(context.TODO(), questionUuid)
+		questionUuid, err := uuid.Parse(questionId)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("failed to like answer with id %s %s", questionId, err)))
+			return
+		}
+		liker, _ := utils.UserFromContext(r.Context())
+		err = router.likesService.LikeQAndA(r.Context(), liker, questionUuid)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(fmt.Sprintf("failed to like answer with id %s %s", questionId, err)))
@@ -177,8 +362,13 @@ func (router *QuestionsRouter) LikeQAndA() http.HandlerFunc {
 func (router *QuestionsRouter) UnlikeQAndA() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		questionId := chi.URLParam(r, "question_id")
-		questionUuid := uuid.MustParse(questionId)
-		err := router.likesRepository.UnlikeQAndA(context.TODO(), questionUuid)
+		questionUuid, err := uuid.Parse(questionId)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("the q&a id %s is not valid", questionId)))
+			return
+		}
+		err = router.likesService.UnlikeQAndA(r.Context(), questionUuid)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(fmt.Sprintf("failed to like answer with id %s %s", questionId, err)))
@@ -188,14 +378,176 @@ func (router *QuestionsRouter) UnlikeQAndA() http.HandlerFunc {
 	}
 }
 
-func (r *QuestionsRouter) Share() http.HandlerFunc {
+/*
+ * The body is optional, {"asked": "..."} shares a q&a of someone else and without it the caller shares one of their own answers
+ */
+func (router *QuestionsRouter) Share() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		questionId := chi.URLParam(r, "question_id")
+		caller, _ := utils.UserFromContext(r.Context())
+		shareRequest, err := parseShareRequest(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to share object %s", err)))
+			return
+		}
+
+		shared, err := router.shareService.Share(r.Context(), caller, shareRequest.Asked, questionId)
+		if err != nil {
+			w.WriteHeader(statusForShareError(err))
+			w.Write([]byte(fmt.Sprintf("failed to share the q&a with id %s %s", questionId, err)))
+			return
+		}
+
+		sharedInBytes, err := json.Marshal(shared)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to share the q&a with id %s %s", questionId, err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(sharedInBytes)
+	}
+}
+
+func (router *QuestionsRouter) ShareToTwitter() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		questionId := chi.URLParam(r, "question_id")
+		caller, _ := utils.UserFromContext(r.Context())
+		shareRequest, err := parseShareRequest(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to share object %s", err)))
+			return
+		}
+
+		// With a linked twitter account the q&a is posted for the user, without one they get an intent to post it themselves
+		job, err := router.crossPostService.CrossPost(r.Context(), caller, shareRequest.Asked, models.ProviderTwitter, questionId)
+		if err == nil {
+			writeShareJob(w, job, questionId)
+			return
+		}
+		if !errors.Is(err, data.ErrLinkedAccountNotFound) {
+			w.WriteHeader(statusForShareError(err))
+			w.Write([]byte(fmt.Sprintf("failed to share the q&a with id %s %s", questionId, err)))
+			return
+		}
+
+		intent, err := router.shareService.TweetIntent(r.Context(), caller, shareRequest.Asked, questionId)
+		if err != nil {
+			w.WriteHeader(statusForShareError(err))
+			w.Write([]byte(fmt.Sprintf("failed to share the q&a with id %s %s", questionId, err)))
+			return
+		}
+
+		intentInBytes, err := json.Marshal(intent)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to share the q&a with id %s %s", questionId, err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(intentInBytes)
+	}
+}
+
+/*
+ * Posts the q&a to the linked account of the caller on the given network, the post is made in the background and the pending job comes back with 202
+ */
+func (router *QuestionsRouter) CrossPost() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
 
+		questionId := chi.URLParam(r, "question_id")
+		provider := chi.URLParam(r, "provider")
+		caller, _ := utils.UserFromContext(r.Context())
+		shareRequest, err := parseShareRequest(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to share object %s", err)))
+			return
+		}
+
+		job, err := router.crossPostService.CrossPost(r.Context(), caller, shareRequest.Asked, provider, questionId)
+		if err != nil {
+			w.WriteHeader(statusForShareError(err))
+			w.Write([]byte(fmt.Sprintf("failed to share the q&a with id %s to %s %s", questionId, provider, err)))
+			return
+		}
+		writeShareJob(w, job, questionId)
 	}
 }
 
-func (r *QuestionsRouter) ShareToTwitter() http.HandlerFunc {
+func writeShareJob(w http.ResponseWriter, job models.ShareJob, questionId string) {
+	jobInBytes, err := json.Marshal(job)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to share the q&a with id %s %s", questionId, err)))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(jobInBytes)
+}
+
+func parseShareRequest(r *http.Request) (models.ShareRequest, error) {
+	shareRequest := models.ShareRequest{}
+	shareRequestInBytes, err := io.ReadAll(r.Body)
+	if err != nil || len(shareRequestInBytes) == 0 {
+		return shareRequest, err
+	}
+	err = json.Unmarshal(shareRequestInBytes, &shareRequest)
+	return shareRequest, err
+}
+
+func statusForShareError(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, data.ErrQuestionNotFound),
+		errors.Is(err, services.ErrUnsupportedProvider):
+		return http.StatusNotFound
+	case errors.Is(err, data.ErrLinkedAccountNotFound):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (router *QuestionsRouter) RevealAnonymousAsker() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		questionId := chi.URLParam(r, "question_id")
+		moderator, _ := utils.UserFromContext(r.Context())
+		anonymousAsker, err := router.questionsService.RevealAnonymousAsker(r.Context(), moderator, questionId)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to reveal the asker of question with id %s %s", questionId, err)))
+			return
+		}
+
+		anonymousAskerInBytes, err := json.Marshal(anonymousAsker)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to reveal the asker of question with id %s %s", questionId, err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(anonymousAskerInBytes)
+	}
+}
 
+func statusForAskError(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrInboxPaused),
+		errors.Is(err, services.ErrAnonymousQuestionsNotAccepted),
+		errors.Is(err, services.ErrAskerNotFollowed):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
 	}
 }
@@ -0,0 +1,84 @@
+package routers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/services"
+	"inquisitive-grimalkin/utils"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type ReportsRouter struct {
+	chi.Router
+	reportsService services.ReportsService
+}
+
+func NewReportsRouter() ReportsRouter {
+	r := chi.NewRouter()
+	reportsRouter := ReportsRouter{
+		Router: r,
+	}
+
+	r.Post("/", reportsRouter.Report())
+
+	return reportsRouter
+}
+
+func (router *ReportsRouter) Report() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		reporter, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		reportInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to parse the request body to bytes %s", err)))
+			return
+		}
+
+		var report models.Report
+		err = json.Unmarshal(reportInBytes, &report)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to report object %s", err)))
+			return
+		}
+
+		report, err = router.reportsService.Report(r.Context(), reporter, report)
+		var validationErrs utils.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			writeValidationErrors(w, validationErrs)
+			return
+		}
+		if errors.Is(err, data.ErrQuestionNotFound) || errors.Is(err, data.ErrUserNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("failed to report the %s %s", report.TargetType, err)))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to report the %s %s", report.TargetType, err)))
+			return
+		}
+
+		reportInBytes, err = json.Marshal(report)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to report the %s %s", report.TargetType, err)))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write(reportInBytes)
+	}
+}
@@ -0,0 +1,123 @@
+package routers
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/services"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+/*
+ * The pages here are what social networks fetch to build the preview of a shared link, so they are public and only hold what the preview and a visitor
+ * without an account need
+ */
+var sharePageTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta name="description" content="{{.Description}}">
+<link rel="canonical" href="{{.Permalink}}">
+<meta property="og:type" content="article">
+<meta property="og:site_name" content="Inquisitive Grimalkin">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.Permalink}}">
+<meta property="og:image" content="{{.ImageUrl}}">
+<meta property="og:image:width" content="1200">
+<meta property="og:image:height" content="630">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+<meta name="twitter:image" content="{{.ImageUrl}}">
+</head>
+<body>
+<article>
+<p>{{if .QAndA.Asker}}{{.QAndA.Asker}} asked{{else}}Someone asked anonymously{{end}}</p>
+<h1>{{.QAndA.Question}}</h1>
+<p>{{.QAndA.Answer}}</p>
+<p><a href="{{.ProfileUrl}}">More answers from {{.QAndA.Asked}}</a></p>
+</article>
+</body>
+</html>
+`))
+
+type ShareRouter struct {
+	chi.Router
+	shareService services.ShareService
+}
+
+func NewShareRouter() ShareRouter {
+	r := chi.NewRouter()
+	shareRouter := ShareRouter{
+		Router: r,
+	}
+
+	r.Get("/{question_id}", shareRouter.ShowSharedQAndA())
+	r.Get("/{question_id}.png", shareRouter.ShowShareCard())
+
+	return shareRouter
+}
+
+/*
+ * The ETag changes whenever what is drawn on the card does, so previews that were already fetched are revalidated without the card being sent again
+ */
+func (router *ShareRouter) ShowShareCard() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionId := chi.URLParam(r, "question_id")
+		card, key, err := router.shareService.GetShareCard(r.Context(), questionId)
+		if errors.Is(err, data.ErrQuestionNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("the q&a with id %s does not exist", questionId)))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to render the q&a with id %s %s", questionId, err)))
+			return
+		}
+
+		etag := `"` + key + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(len(card)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(card)
+	}
+}
+
+func (router *ShareRouter) ShowSharedQAndA() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		questionId := chi.URLParam(r, "question_id")
+		preview, err := router.shareService.GetSharePreview(r.Context(), questionId)
+		if errors.Is(err, data.ErrQuestionNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("the q&a with id %s does not exist", questionId)))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the q&a with id %s %s", questionId, err)))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.WriteHeader(http.StatusOK)
+		err = sharePageTemplate.Execute(w, preview)
+		if err != nil {
+			log.Printf("failed to render the shared q&a %s %s\n", questionId, err)
+		}
+	}
+}
@@ -1,24 +1,44 @@
 package routers
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"inquisitive-grimalkin/data"
 	"inquisitive-grimalkin/models"
 	"inquisitive-grimalkin/services"
 	"inquisitive-grimalkin/utils"
+	"inquisitive-grimalkin/middleware"
 	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 	"github.com/go-chi/chi/v5"
-	jwt "github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 )
 
+// RATE_LIMIT_FOLLOW overrides it, following and unfollowing share the limit
+var followRateLimit = middleware.RateLimit{Requests: 50, Per: time.Hour}
+
+// RATE_LIMIT_LOGIN overrides it, counted per IP since the caller has no token yet
+var loginRateLimit = middleware.RateLimit{Requests: 20, Per: 15 * time.Minute}
+
+// RATE_LIMIT_PASSWORD_RESET overrides it, asking for a reset and confirming it share the limit
+var passwordResetRateLimit = middleware.RateLimit{Requests: 10, Per: time.Hour}
+
 type UsersRouter struct {
 	chi.Router
 	userRepository data.UsersRepository
 	userService services.UsersService
+	settingsRepository data.CassandraSettingsRepository
+	erasureService services.ErasureService
+	exportService services.ExportService
+	accountService services.AccountService
+	crossPostService services.CrossPostService
+	notificationService services.NotificationService
+	pushService services.PushService
+	digestService services.DigestService
 }
 
 func NewUsersRouter() UsersRouter {
@@ -26,16 +46,42 @@ func NewUsersRouter() UsersRouter {
 	r := UsersRouter{
 		Router:         embeddableRouter,
 		userRepository: data.NewCassandraUsersRepository(),
+		settingsRepository: data.NewCassandraSettingsRepository(),
 	}
 
 	r.Post("/register", r.Register())
-	r.Post("/login", r.Login())
+	r.With(middleware.StrictRateLimitRoute("login", loginRateLimit)).Post("/login", r.Login())
 	r.Post("/validate", r.Validate())
 	//TODO: As a placeholder, we will be adding the follower to the path, but it should be noted that the follower username will be removed from the url and parsed from JWT
-	r.Post("/follow/{followed}", r.Follow())
+	r.With(middleware.RateLimitRoute("follow", followRateLimit)).Post("/follow/{followed}", r.Follow())
 	//TODO: As a placeholder, we will be adding the follower to the path, but it should be noted that the follower username will be removed from the url and parsed from JWT
-	r.Post("/unfollow/{followed}", r.Unfollow())
-	r.Get("/{username}", r.SearchForUsername())
+	r.With(middleware.RateLimitRoute("follow", followRateLimit)).Post("/unfollow/{followed}", r.Unfollow())
+	r.Patch("/me", r.UpdateProfile())
+	r.Post("/me/password", r.ChangePassword())
+	r.Post("/me/email/verification", r.RequestEmailVerification())
+	r.Post("/email/verify", r.ConfirmEmailVerification())
+	r.With(middleware.StrictRateLimitRoute("password-reset", passwordResetRateLimit)).Post("/password/reset", r.RequestPasswordReset())
+	r.With(middleware.StrictRateLimitRoute("password-reset", passwordResetRateLimit)).Post("/password/reset/confirm", r.ConfirmPasswordReset())
+	r.Delete("/me", r.DeleteAccount())
+	r.Post("/me/export", r.RequestExport())
+	r.Get("/me/export/{export_id}", r.GetExport())
+	r.Get("/exports/{export_id}", r.DownloadExport())
+	r.Get("/me/settings", r.GetSettings())
+	r.Put("/me/settings", r.UpdateSettings())
+	r.Get("/me/digest", r.GetDigest())
+	r.Put("/me/digest", r.UpdateDigest())
+	r.Get("/me/accounts", r.GetLinkedAccounts())
+	r.Put("/me/accounts/{provider}", r.LinkAccount())
+	r.Delete("/me/accounts/{provider}", r.UnlinkAccount())
+	r.Get("/me/share-jobs/{job_id}", r.GetShareJob())
+	r.Get("/me/notifications", r.GetNotifications())
+	r.Get("/me/notifications/unread", r.GetUnreadNotificationsCount())
+	r.Post("/me/notifications/read", r.MarkNotificationsRead())
+	r.Get("/me/push/key", r.GetPushPublicKey())
+	r.Put("/me/push/subscriptions", r.SubscribeToPush())
+	r.Delete("/me/push/subscriptions", r.UnsubscribeFromPush())
+	r.Get("/search/{username}", r.SearchForUsername())
+	r.Get("/{username}", r.GetProfile())
 
 	return r
 }
@@ -61,7 +107,17 @@ func (router *UsersRouter) Register() http.HandlerFunc {
 			return
 		}
 
-		registeredUser, err := router.userRepository.Register(context.TODO(), userToBeRegisterd)
+		registeredUser, err := router.userService.Register(r.Context(), userToBeRegisterd)
+		var validationErrs utils.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			writeValidationErrors(w, validationErrs)
+			return
+		}
+		if errors.Is(err, data.ErrUsernameTaken) || errors.Is(err, data.ErrEmailTaken) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(fmt.Sprintf("failed to create user %s", err)))
+			return
+		}
 		if err != nil {
 			msg := fmt.Sprintf("failed to create user %s", err)
 			w.WriteHeader(http.StatusBadRequest)
@@ -69,16 +125,24 @@ func (router *UsersRouter) Register() http.HandlerFunc {
 			return
 		}
 
-		registeredUserInString := fmt.Sprintf("%s", registeredUser)
-
-		claimsForANormalUser := jwt.MapClaims{
-			`nba`:(time.Now().Unix() + 31536000),
-			`username`:registeredUser.Username,
+		// The repository never hands back the password, it is cleared again here so that it can never be echoed to the client
+		registeredUser.Password = ""
+		registeredUserInBytes, err := json.Marshal(registeredUser)
+		if err != nil {
+			msg := fmt.Sprintf("failed to marshal the registered user %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(msg))
+			return
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claimsForANormalUser)
+		// The account exists whether or not the mail goes out, the user can ask for the verification again
+		err = router.accountService.SendEmailVerification(r.Context(), registeredUser.Username)
+		if err != nil {
+			log.Printf("failed to send the email verification to %s %s\n", registeredUser.Username, err)
+		}
 
-		tokenString, err := token.SignedString([]byte(signingKey))
+		// A new account has no roles, they are only ever granted in the users table
+		tokenString, err := middleware.IssueToken(registeredUser.Username, nil)
 		if err != nil {
 			msg := fmt.Sprintf("failed to sign the jwt %s", err)
 			w.WriteHeader(http.StatusBadRequest)
@@ -87,13 +151,63 @@ func (router *UsersRouter) Register() http.HandlerFunc {
 		}
 		w.Header().Set("Authorization", `bearer ` + tokenString)
 		w.WriteHeader(http.StatusCreated)
-		w.Write([]byte(registeredUserInString))
+		w.Write(registeredUserInBytes)
 	}
 }
 
+/*
+ * The body is {"username": ..., "password": ...}, the token comes back in the Authorization header and carries the roles of the user
+ */
 func (router *UsersRouter) Login() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		credentialsInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to parse the request body to bytes %s", err)))
+			return
+		}
+		var credentials models.User
+		err = json.Unmarshal(credentialsInBytes, &credentials)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to user object %s", err)))
+			return
+		}
 
+		loggedInUser, err := router.userService.Login(r.Context(), models.User{Username: credentials.Username, Password: credentials.Password})
+		var validationErrs utils.ValidationErrors
+		switch {
+		case errors.As(err, &validationErrs):
+			writeValidationErrors(w, validationErrs)
+			return
+		case errors.Is(err, data.ErrWrongPassword):
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("the username or the password is wrong"))
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to log in %s", err)))
+			return
+		}
+
+		tokenString, err := middleware.IssueToken(loggedInUser.Username, loggedInUser.Roles)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to sign the jwt %s", err)))
+			return
+		}
+		loggedInUserInBytes, err := json.Marshal(loggedInUser)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to marshal the user %s", err)))
+			return
+		}
+		w.Header().Set("Authorization", `bearer ` + tokenString)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(loggedInUserInBytes)
 	}
 }
 
@@ -134,7 +248,907 @@ func (router *UsersRouter) Unfollow() http.HandlerFunc {
 	}	
 }
 
+/*
+ * The page is 0 based and both the page and its size are optional query parameters, ?page=1&pageSize=20
+ */
 func (router *UsersRouter) SearchForUsername() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-	}	
-}
\ No newline at end of file
+		term := chi.URLParam(r, "username")
+
+		page, pageSize := 0, services.DefaultSearchPageSize
+		var err error
+		if pageInString := r.URL.Query().Get("page"); pageInString != "" {
+			page, err = strconv.Atoi(pageInString)
+			if err != nil || page < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("the page %s is not a valid page", pageInString)))
+				return
+			}
+		}
+		if pageSizeInString := r.URL.Query().Get("pageSize"); pageSizeInString != "" {
+			pageSize, err = strconv.Atoi(pageSizeInString)
+			if err != nil || pageSize <= 0 || pageSize > services.MaxSearchPageSize {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("the page size has to be between 1 and %d", services.MaxSearchPageSize)))
+				return
+			}
+		}
+
+		foundUsers, err := router.userService.SearchUsers(r.Context(), term, page, pageSize)
+		var validationErrs utils.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			writeValidationErrors(w, validationErrs)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to search for %s %s", term, err)))
+			return
+		}
+
+		foundUsersInBytes, err := json.Marshal(foundUsers)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to search for %s %s", term, err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(foundUsersInBytes)
+	}
+}
+
+func (router *UsersRouter) GetProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := chi.URLParam(r, "username")
+		caller, _ := utils.UserFromContext(r.Context())
+
+		profile, err := router.userService.GetProfile(r.Context(), caller, username)
+		if errors.Is(err, data.ErrUserNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("the user %s does not exist", username)))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the profile of %s %s", username, err)))
+			return
+		}
+
+		profileInBytes, err := json.Marshal(profile)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the profile of %s %s", username, err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(profileInBytes)
+	}
+}
+
+func (router *UsersRouter) UpdateProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		updateInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to parse the request body to bytes %s", err)))
+			return
+		}
+		var update models.ProfileUpdate
+		err = json.Unmarshal(updateInBytes, &update)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to profile update object %s", err)))
+			return
+		}
+
+		updatedUser, err := router.userService.UpdateProfile(r.Context(), username, update)
+		var validationErrs utils.ValidationErrors
+		switch {
+		case errors.As(err, &validationErrs):
+			writeValidationErrors(w, validationErrs)
+			return
+		case errors.Is(err, data.ErrEmailTaken):
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(fmt.Sprintf("failed to update the profile %s", err)))
+			return
+		case errors.Is(err, data.ErrUserNotFound):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("failed to update the profile %s", err)))
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to update the profile %s", err)))
+			return
+		}
+
+		updatedUserInBytes, err := json.Marshal(updatedUser)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to update the profile %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(updatedUserInBytes)
+	}
+}
+
+/*
+ * Every session of the user is revoked once the password is changed, the one that changed it gets a new token back so that it can carry on
+ */
+func (router *UsersRouter) ChangePassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		changeInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to parse the request body to bytes %s", err)))
+			return
+		}
+		var change models.PasswordChange
+		err = json.Unmarshal(changeInBytes, &change)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to password change object %s", err)))
+			return
+		}
+
+		err = router.userService.ChangePassword(r.Context(), username, change)
+		var validationErrs utils.ValidationErrors
+		switch {
+		case errors.As(err, &validationErrs):
+			writeValidationErrors(w, validationErrs)
+			return
+		case errors.Is(err, data.ErrWrongPassword):
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(fmt.Sprintf("failed to change the password %s", err)))
+			return
+		case errors.Is(err, data.ErrUserNotFound):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("failed to change the password %s", err)))
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to change the password %s", err)))
+			return
+		}
+
+		u, err := router.userRepository.GetUser(r.Context(), username)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("the password was changed but signing a new jwt failed, log in again %s", err)))
+			return
+		}
+		tokenString, err := middleware.IssueToken(username, u.Roles)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("the password was changed but signing a new jwt failed, log in again %s", err)))
+			return
+		}
+		w.Header().Set("Authorization", `bearer ` + tokenString)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (router *UsersRouter) RequestEmailVerification() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		err := router.accountService.SendEmailVerification(r.Context(), username)
+		switch {
+		case errors.Is(err, services.ErrEmailAlreadyVerified):
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(err.Error()))
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to send the email verification %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (router *UsersRouter) ConfirmEmailVerification() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var verification models.EmailVerification
+		err := json.NewDecoder(r.Body).Decode(&verification)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to email verification object %s", err)))
+			return
+		}
+
+		err = router.accountService.ConfirmEmailVerification(r.Context(), verification.Token)
+		switch {
+		case errors.Is(err, data.ErrTokenNotFound), errors.Is(err, data.ErrUserNotFound):
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to verify the email %s", err)))
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to verify the email %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+/*
+ * The answer is always 202 so that this route cannot be used to find out whether an email is registered
+ */
+func (router *UsersRouter) RequestPasswordReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var resetRequest models.PasswordResetRequest
+		err := json.NewDecoder(r.Body).Decode(&resetRequest)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to password reset request object %s", err)))
+			return
+		}
+
+		err = router.accountService.RequestPasswordReset(r.Context(), resetRequest.Email)
+		if err != nil {
+			log.Printf("failed to send the password reset %s\n", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (router *UsersRouter) ConfirmPasswordReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var reset models.PasswordReset
+		err := json.NewDecoder(r.Body).Decode(&reset)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to password reset object %s", err)))
+			return
+		}
+
+		err = router.accountService.ConfirmPasswordReset(r.Context(), reset)
+		var validationErrs utils.ValidationErrors
+		switch {
+		case errors.As(err, &validationErrs):
+			writeValidationErrors(w, validationErrs)
+			return
+		case errors.Is(err, data.ErrTokenNotFound), errors.Is(err, data.ErrUserNotFound):
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to reset the password %s", err)))
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to reset the password %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (router *UsersRouter) GetSettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		settings, err := router.settingsRepository.GetSettings(r.Context(), username)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the settings %s", err)))
+			return
+		}
+
+		settingsInBytes, err := json.Marshal(settings)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the settings %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(settingsInBytes)
+	}
+}
+
+/*
+ * The settings left out of the body keep their stored value, {"inboxPaused": true} only pauses the inbox
+ */
+func (router *UsersRouter) UpdateSettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		settingsInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to parse the request body to bytes %s", err)))
+			return
+		}
+
+		var update models.SettingsUpdate
+		err = json.Unmarshal(settingsInBytes, &update)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to settings object %s", err)))
+			return
+		}
+
+		// The settings always belong to the authenticated user, whatever username was sent in the body
+		settings, err := router.settingsRepository.GetSettings(r.Context(), username)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to update the settings %s", err)))
+			return
+		}
+		updatedSettings, err := router.settingsRepository.UpdateSettings(r.Context(), update.Apply(settings))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to update the settings %s", err)))
+			return
+		}
+
+		updatedSettingsInBytes, err := json.Marshal(updatedSettings)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to update the settings %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(updatedSettingsInBytes)
+	}
+}
+
+/*
+ * The account is erased in the background, the client gets the erasure record back straight away with 202
+ */
+func (router *UsersRouter) DeleteAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		record, err := router.erasureService.RequestErasure(r.Context(), username)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to delete the account %s", err)))
+			return
+		}
+
+		recordInBytes, err := json.Marshal(record)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to delete the account %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(recordInBytes)
+	}
+}
+
+func (router *UsersRouter) RequestExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		export, err := router.exportService.RequestExport(r.Context(), username)
+		if errors.Is(err, services.ErrDataExportsDisabled) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to request the export %s", err)))
+			return
+		}
+
+		exportInBytes, err := json.Marshal(export)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to request the export %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(exportInBytes)
+	}
+}
+
+func (router *UsersRouter) GetExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		exportId := chi.URLParam(r, "export_id")
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		export, err := router.exportService.GetExport(r.Context(), username, exportId)
+		if errors.Is(err, services.ErrDataExportsDisabled) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if errors.Is(err, data.ErrExportNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the export with id %s %s", exportId, err)))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the export with id %s %s", exportId, err)))
+			return
+		}
+
+		exportInBytes, err := json.Marshal(export)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the export with id %s %s", exportId, err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(exportInBytes)
+	}
+}
+
+/*
+ * This route is reachable without a token, the signature in the link is what grants access to the archive
+ */
+func (router *UsersRouter) DownloadExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		exportId := chi.URLParam(r, "export_id")
+		archive, err := router.exportService.OpenArchive(exportId, r.URL.Query().Get("expires"), r.URL.Query().Get("signature"))
+		switch {
+		case errors.Is(err, services.ErrDataExportsDisabled):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error()))
+			return
+		case errors.Is(err, services.ErrInvalidDownloadLink):
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(err.Error()))
+			return
+		case errors.Is(err, services.ErrDownloadLinkExpired):
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(err.Error()))
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("failed to download the export with id %s %s", exportId, err)))
+			return
+		}
+		defer archive.Close()
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="inquisitive-grimalkin-%s.zip"`, exportId))
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, archive)
+	}
+}
+
+func (router *UsersRouter) GetLinkedAccounts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		accounts, err := router.crossPostService.GetLinkedAccounts(r.Context(), username)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the linked accounts %s", err)))
+			return
+		}
+
+		accountsInBytes, err := json.Marshal(accounts)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the linked accounts %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(accountsInBytes)
+	}
+}
+
+/*
+ * The client completes the OAuth flow of the network itself and sends the tokens it got, linking the same network again replaces the old account
+ */
+func (router *UsersRouter) LinkAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		provider := chi.URLParam(r, "provider")
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		accountInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to parse the request body to bytes %s", err)))
+			return
+		}
+
+		var account models.LinkedAccount
+		err = json.Unmarshal(accountInBytes, &account)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to linked account object %s", err)))
+			return
+		}
+
+		linkedAccount, err := router.crossPostService.LinkAccount(r.Context(), username, provider, account)
+		var validationErrs utils.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			writeValidationErrors(w, validationErrs)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to link the %s account %s", provider, err)))
+			return
+		}
+
+		linkedAccountInBytes, err := json.Marshal(linkedAccount)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to link the %s account %s", provider, err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(linkedAccountInBytes)
+	}
+}
+
+func (router *UsersRouter) UnlinkAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider := chi.URLParam(r, "provider")
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		err := router.crossPostService.UnlinkAccount(r.Context(), username, provider)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to unlink the %s account %s", provider, err)))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (router *UsersRouter) GetShareJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobId := chi.URLParam(r, "job_id")
+		username, ok := utils.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		job, err := router.crossPostService.GetShareJob(r.Context(), username, jobId)
+		if errors.Is(err, data.ErrShareJobNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the share job with id %s %s", jobId, err)))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the share job with id %s %s", jobId, err)))
+			return
+		}
+
+		jobInBytes, err := json.Marshal(job)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the share job with id %s %s", jobId, err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(jobInBytes)
+	}
+}
+
+// ?limit= is how many of the most recent notifications are listed, it is optional
+func (router *UsersRouter) GetNotifications() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, _ := utils.UserFromContext(r.Context())
+
+		limit := services.DefaultNotificationsPageSize
+		if limitInString := r.URL.Query().Get("limit"); limitInString != "" {
+			var err error
+			limit, err = strconv.Atoi(limitInString)
+			if err != nil || limit <= 0 || limit > services.MaxNotificationsPageSize {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("the limit has to be between 1 and %d", services.MaxNotificationsPageSize)))
+				return
+			}
+		}
+
+		notifications, err := router.notificationService.GetNotifications(r.Context(), username, limit)
+		if errors.Is(err, services.ErrUnauthenticated) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the notifications %s", err)))
+			return
+		}
+
+		notificationsInBytes, err := json.Marshal(notifications)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the notifications %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(notificationsInBytes)
+	}
+}
+
+func (router *UsersRouter) GetUnreadNotificationsCount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, _ := utils.UserFromContext(r.Context())
+		unread, err := router.notificationService.GetUnreadCount(r.Context(), username)
+		if errors.Is(err, services.ErrUnauthenticated) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to count the unread notifications %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf(`{"unread":%d}`, unread)))
+	}
+}
+
+/*
+ * The body is optional, {"notificationIds": [...]} marks only those notifications as read and without it every notification is marked as read
+ */
+func (router *UsersRouter) MarkNotificationsRead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		username, _ := utils.UserFromContext(r.Context())
+		reqInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to parse the request body to bytes %s", err)))
+			return
+		}
+
+		var req struct {
+			NotificationIds []uuid.UUID `json:"notificationIds"`
+		}
+		if len(reqInBytes) > 0 {
+			err = json.Unmarshal(reqInBytes, &req)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to notification ids %s", err)))
+				return
+			}
+		}
+
+		err = router.notificationService.MarkRead(r.Context(), username, req.NotificationIds)
+		if errors.Is(err, services.ErrUnauthenticated) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to mark the notifications as read %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (router *UsersRouter) GetPushPublicKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		publicKey, err := router.pushService.GetPublicKey()
+		if errors.Is(err, services.ErrWebPushDisabled) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		keyInBytes, err := json.Marshal(map[string]string{"publicKey": publicKey})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the push public key %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(keyInBytes)
+	}
+}
+
+/*
+ * The body is the subscription the browser handed to the webapp, as PushSubscription.toJSON() returns it
+ */
+func (router *UsersRouter) SubscribeToPush() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		username, _ := utils.UserFromContext(r.Context())
+		subInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to parse the request body to bytes %s", err)))
+			return
+		}
+		var sub models.PushSubscription
+		err = json.Unmarshal(subInBytes, &sub)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to unmarshall the request body to a push subscription %s", err)))
+			return
+		}
+
+		saved, err := router.pushService.Subscribe(r.Context(), username, sub)
+		var validationErrs utils.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			writeValidationErrors(w, validationErrs)
+			return
+		}
+		if errors.Is(err, services.ErrUnauthenticated) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, services.ErrWebPushDisabled) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to save the push subscription %s", err)))
+			return
+		}
+
+		savedInBytes, err := json.Marshal(saved)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to save the push subscription %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(savedInBytes)
+	}
+}
+
+// The endpoint to unsubscribe is a url of its own, so it is passed as the endpoint query parameter
+func (router *UsersRouter) UnsubscribeFromPush() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, _ := utils.UserFromContext(r.Context())
+		endpoint := r.URL.Query().Get("endpoint")
+		if endpoint == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("the endpoint of the push subscription is missing"))
+			return
+		}
+
+		err := router.pushService.Unsubscribe(r.Context(), username, endpoint)
+		if errors.Is(err, services.ErrUnauthenticated) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to delete the push subscription %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (router *UsersRouter) GetDigest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, _ := utils.UserFromContext(r.Context())
+		state, err := router.digestService.GetDigestState(r.Context(), username)
+		if errors.Is(err, services.ErrUnauthenticated) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the digest settings %s", err)))
+			return
+		}
+
+		stateInBytes, err := json.Marshal(state)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to fetch the digest settings %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(stateInBytes)
+	}
+}
+
+/*
+ * The body is {"optedIn": true} to get the email digest and {"optedIn": false} to stop it
+ */
+func (router *UsersRouter) UpdateDigest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		username, _ := utils.UserFromContext(r.Context())
+		reqInBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to parse the request body to bytes %s", err)))
+			return
+		}
+		var req struct {
+			OptedIn *bool `json:"optedIn"`
+		}
+		err = json.Unmarshal(reqInBytes, &req)
+		if err != nil || req.OptedIn == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("the body has to say whether the digest is wanted with optedIn"))
+			return
+		}
+
+		state, err := router.digestService.SetDigestOptIn(r.Context(), username, *req.OptedIn)
+		if errors.Is(err, services.ErrUnauthenticated) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to update the digest settings %s", err)))
+			return
+		}
+
+		stateInBytes, err := json.Marshal(state)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to update the digest settings %s", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(stateInBytes)
+	}
+}
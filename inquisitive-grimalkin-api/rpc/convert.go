@@ -0,0 +1,97 @@
+package rpc
+
+import (
+	"errors"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/pb"
+	"inquisitive-grimalkin/services"
+	"inquisitive-grimalkin/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Like Question.MarshalJSON, the asker of an anonymous question never leaves the server
+func questionToPb(q models.Question) *pb.Question {
+	asker := q.Asker
+	if q.IsAnon {
+		asker = ""
+	}
+	return &pb.Question{
+		QuestionId: idToPb(q.QuestionId),
+		Asked:      q.Asked,
+		Asker:      asker,
+		IsAnon:     q.IsAnon,
+		Question:   q.Question,
+	}
+}
+
+func qAndAToPb(qAndA models.QAndA, likes int64) *pb.QAndA {
+	asker := qAndA.Asker
+	if qAndA.IsAnon {
+		asker = ""
+	}
+	return &pb.QAndA{
+		QuestionId: idToPb(qAndA.QuestionId),
+		Asked:      qAndA.Asked,
+		Asker:      asker,
+		IsAnon:     qAndA.IsAnon,
+		Question:   qAndA.Question,
+		Answer:     qAndA.Answer,
+		AnsweredOn: timestampToPb(qAndA.AnsweredOn),
+		Likes:      likes,
+	}
+}
+
+// A content that was held or quarantined has no id yet
+func idToPb(id uuid.UUID) string {
+	if id == uuid.Nil {
+		return ""
+	}
+	return id.String()
+}
+
+func timestampToPb(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+/*
+ * The same mapping the routers make to status codes, the fields that failed validation are attached as a BadRequest so that the clients get every
+ * one of them like they do from the REST api
+ */
+func statusFromError(err error) error {
+	var validationErrs utils.ValidationErrors
+	switch {
+	case errors.As(err, &validationErrs):
+		badRequest := &errdetails.BadRequest{}
+		for _, e := range validationErrs {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       e.Field,
+				Description: e.Message,
+			})
+		}
+		st, detailsErr := status.New(codes.InvalidArgument, validationErrs.Error()).WithDetails(badRequest)
+		if detailsErr != nil {
+			return status.Error(codes.InvalidArgument, validationErrs.Error())
+		}
+		return st.Err()
+	case errors.Is(err, services.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, services.ErrInboxPaused),
+		errors.Is(err, services.ErrAnonymousQuestionsNotAccepted),
+		errors.Is(err, services.ErrAskerNotFollowed):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, data.ErrUserNotFound), errors.Is(err, data.ErrQuestionNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
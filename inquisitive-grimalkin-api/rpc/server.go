@@ -0,0 +1,179 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"inquisitive-grimalkin/middleware"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/pb"
+	"inquisitive-grimalkin/services"
+	"inquisitive-grimalkin/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+ * Serves grimalkin.proto on top of the same services as the REST routers, so both APIs always behave the same. The caller is the user named by the
+ * token the interceptors checked, it is never taken from the request.
+ */
+type GrimalkinServer struct {
+	pb.UnimplementedGrimalkinServer
+	questionsService services.QuestionsService
+	usersService     services.UsersService
+	likesService     services.LikesService
+}
+
+/*
+ * The same names and limits as the REST routes, the two APIs share the buckets of every user
+ */
+var rateLimitedMethods = map[string]middleware.RateLimitedMethod{
+	pb.Grimalkin_Ask_FullMethodName:      {Name: "ask", Limit: middleware.RateLimit{Requests: 30, Per: time.Hour}},
+	pb.Grimalkin_Like_FullMethodName:     {Name: "like", Limit: middleware.RateLimit{Requests: 120, Per: 10 * time.Minute}},
+	pb.Grimalkin_Unlike_FullMethodName:   {Name: "like", Limit: middleware.RateLimit{Requests: 120, Per: 10 * time.Minute}},
+	pb.Grimalkin_Follow_FullMethodName:   {Name: "follow", Limit: middleware.RateLimit{Requests: 50, Per: time.Hour}},
+	pb.Grimalkin_Unfollow_FullMethodName: {Name: "follow", Limit: middleware.RateLimit{Requests: 50, Per: time.Hour}},
+}
+
+func NewGrimalkinServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(middleware.JwtUnaryInterceptor, middleware.RateLimitUnaryInterceptor(rateLimitedMethods)),
+		grpc.ChainStreamInterceptor(middleware.JwtStreamInterceptor),
+	)
+	pb.RegisterGrimalkinServer(server, &GrimalkinServer{})
+	return server
+}
+
+func (s *GrimalkinServer) Ask(ctx context.Context, req *pb.AskRequest) (*pb.AskResponse, error) {
+	q, err := s.questionsService.Ask(ctx, models.Question{
+		Asked:    req.GetAsked(),
+		IsAnon:   req.GetIsAnon(),
+		Question: req.GetQuestion(),
+	})
+	if errors.Is(err, services.ErrHeldForReview) {
+		return &pb.AskResponse{Question: questionToPb(q), HeldForReview: true}, nil
+	}
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	return &pb.AskResponse{Question: questionToPb(q)}, nil
+}
+
+func (s *GrimalkinServer) Answer(ctx context.Context, req *pb.AnswerRequest) (*pb.AnswerResponse, error) {
+	caller, _ := utils.UserFromContext(ctx)
+	_, err := uuid.Parse(req.GetQuestionId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "the question id %q is not valid", req.GetQuestionId())
+	}
+
+	// The question is looked up in the inbox of the caller, nobody can answer a question that was asked to someone else
+	qAndA, err := s.questionsService.AnswerQuestion(ctx, req.GetQuestionId(), models.QAndA{Asked: caller, Answer: req.GetAnswer()})
+	if errors.Is(err, services.ErrHeldForReview) {
+		return &pb.AnswerResponse{QAndA: qAndAToPb(qAndA, 0), HeldForReview: true}, nil
+	}
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	return &pb.AnswerResponse{QAndA: qAndAToPb(qAndA, 0)}, nil
+}
+
+func (s *GrimalkinServer) GetInbox(ctx context.Context, _ *pb.GetInboxRequest) (*pb.GetInboxResponse, error) {
+	questions, err := s.questionsService.GetUnansweredQuestions(ctx)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	res := &pb.GetInboxResponse{Questions: make([]*pb.Question, 0, len(questions))}
+	for _, q := range questions {
+		res.Questions = append(res.Questions, questionToPb(q))
+	}
+	return res, nil
+}
+
+func (s *GrimalkinServer) GetTimeline(ctx context.Context, req *pb.GetTimelineRequest) (*pb.GetTimelineResponse, error) {
+	if req.GetLimit() < 0 || req.GetLimit() > services.MaxTimelinePageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "the limit has to be between 1 and %d", services.MaxTimelinePageSize)
+	}
+	timeline, err := s.questionsService.GetTimeline(ctx, int(req.GetLimit()))
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	res := &pb.GetTimelineResponse{QAndAs: make([]*pb.QAndA, 0, len(timeline))}
+	for _, t := range timeline {
+		res.QAndAs = append(res.QAndAs, qAndAToPb(t.QAndA, t.Likes))
+	}
+	return res, nil
+}
+
+func (s *GrimalkinServer) Like(ctx context.Context, req *pb.LikeRequest) (*pb.LikeResponse, error) {
+	caller, _ := utils.UserFromContext(ctx)
+	qAndAUuid, err := uuid.Parse(req.GetQuestionId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "the q&a id %q is not valid", req.GetQuestionId())
+	}
+	err = s.likesService.LikeQAndA(ctx, caller, qAndAUuid)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	return &pb.LikeResponse{}, nil
+}
+
+func (s *GrimalkinServer) Unlike(ctx context.Context, req *pb.UnlikeRequest) (*pb.UnlikeResponse, error) {
+	qAndAUuid, err := uuid.Parse(req.GetQuestionId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "the q&a id %q is not valid", req.GetQuestionId())
+	}
+	err = s.likesService.UnlikeQAndA(ctx, qAndAUuid)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	return &pb.UnlikeResponse{}, nil
+}
+
+func (s *GrimalkinServer) Follow(ctx context.Context, req *pb.FollowRequest) (*pb.FollowResponse, error) {
+	caller, _ := utils.UserFromContext(ctx)
+	if req.GetUsername() == "" || req.GetUsername() == caller {
+		return nil, status.Error(codes.InvalidArgument, "the user to follow has to be someone else")
+	}
+	err := s.usersService.Follow(ctx, caller, req.GetUsername())
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	return &pb.FollowResponse{}, nil
+}
+
+func (s *GrimalkinServer) Unfollow(ctx context.Context, req *pb.UnfollowRequest) (*pb.UnfollowResponse, error) {
+	caller, _ := utils.UserFromContext(ctx)
+	if req.GetUsername() == "" || req.GetUsername() == caller {
+		return nil, status.Error(codes.InvalidArgument, "the user to unfollow has to be someone else")
+	}
+	err := s.usersService.Unfollow(ctx, caller, req.GetUsername())
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	return &pb.UnfollowResponse{}, nil
+}
+
+func (s *GrimalkinServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.User, error) {
+	caller, _ := utils.UserFromContext(ctx)
+	if req.GetUsername() == "" {
+		return nil, status.Error(codes.InvalidArgument, "the username is missing")
+	}
+	profile, err := s.usersService.GetProfile(ctx, caller, req.GetUsername())
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	return &pb.User{
+		Username:      profile.Username,
+		FirstName:     profile.FirstName,
+		LastName:      profile.LastName,
+		JoinedOn:      timestampToPb(profile.JoinedOn),
+		Followers:     profile.Followers,
+		Following:     profile.Following,
+		Answers:       profile.Answers,
+		FollowedByYou: profile.FollowedByYou,
+		FollowsYou:    profile.FollowsYou,
+	}, nil
+}
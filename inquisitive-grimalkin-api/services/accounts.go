@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/utils"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	emailVerificationTokenValidity = 24 * time.Hour
+	passwordResetTokenValidity     = time.Hour
+)
+
+var ErrEmailAlreadyVerified = errors.New("the email is already verified")
+
+var appBaseUrl string
+
+func init() {
+	godotenv.Load()
+	appBaseUrl = strings.TrimSuffix(os.Getenv("APP_BASE_URL"), "/")
+	if appBaseUrl == "" {
+		appBaseUrl = "http://localhost:3000"
+	}
+}
+
+type AccountService struct {
+	usersRepository    data.CassandraUsersRepository
+	tokensRepository   data.CassandraTokensRepository
+	sessionsRepository data.CassandraSessionsRepository
+}
+
+/*
+ * The verification goes to the email waiting to be verified if the user is changing their email, otherwise to their current email if it was never verified
+ */
+func (s *AccountService) SendEmailVerification(ctx context.Context, username string) error {
+	u, err := s.usersRepository.GetUser(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	email := u.PendingEmail
+	if email == "" {
+		if u.EmailVerified {
+			return ErrEmailAlreadyVerified
+		}
+		email = u.Email
+	}
+
+	token, err := s.issueToken(ctx, models.AccountToken{
+		Purpose:  models.TokenEmailVerification,
+		Username: u.Username,
+		Email:    email,
+	}, emailVerificationTokenValidity)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, Mail{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm that this is your email by opening the link below, it is valid for 24 hours.\n\n%s\n\n"+
+			"If you did not ask for this you can ignore this mail.\n", u.FirstName, tokenLink("/verify-email", token)),
+	})
+}
+
+func (s *AccountService) ConfirmEmailVerification(ctx context.Context, token string) error {
+	t, err := s.tokensRepository.ConsumeToken(ctx, hashToken(token), models.TokenEmailVerification)
+	if err != nil {
+		return err
+	}
+	err = s.usersRepository.VerifyEmail(ctx, t.Username, t.Email)
+	if err != nil {
+		return err
+	}
+	log.Printf("the email of %s was verified\n", t.Username)
+	return nil
+}
+
+/*
+ * Nothing tells the caller whether the email belongs to anyone, otherwise this could be used to find out who is registered. The reset is only sent to the
+ * current email of the user, never to one that is still waiting to be verified.
+ */
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email string) error {
+	username, err := s.usersRepository.FindUsernameByEmail(ctx, email)
+	if errors.Is(err, data.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	u, err := s.usersRepository.GetUser(ctx, username)
+	if errors.Is(err, data.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !sameEmail(u.Email, email) {
+		return nil
+	}
+
+	token, err := s.issueToken(ctx, models.AccountToken{
+		Purpose:  models.TokenPasswordReset,
+		Username: u.Username,
+		Email:    u.Email,
+	}, passwordResetTokenValidity)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, Mail{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account %s. Open the link below to choose a new one, it is valid for "+
+			"one hour.\n\n%s\n\nIf it was not you, you can ignore this mail, your password stays the same.\n",
+			u.FirstName, u.Username, tokenLink("/reset-password", token)),
+	})
+}
+
+/*
+ * The new password is validated before the token is used up, so a password that is rejected does not cost the user their link. Like changing the
+ * password, resetting it revokes every session of the user.
+ */
+func (s *AccountService) ConfirmPasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	tokenHash := hashToken(reset.Token)
+	t, err := s.tokensRepository.GetToken(ctx, tokenHash, models.TokenPasswordReset)
+	if err != nil {
+		return err
+	}
+	err = utils.ValidateNewPassword(reset.NewPassword, t.Username, t.Email)
+	if err != nil {
+		return err
+	}
+
+	t, err = s.tokensRepository.ConsumeToken(ctx, tokenHash, models.TokenPasswordReset)
+	if err != nil {
+		return err
+	}
+	// The token stops working once the email it was sent to is no longer the email of the user
+	u, err := s.usersRepository.GetUser(ctx, t.Username)
+	if err != nil {
+		return err
+	}
+	if !sameEmail(u.Email, t.Email) {
+		return data.ErrTokenNotFound
+	}
+
+	err = s.usersRepository.ResetPassword(ctx, t.Username, reset.NewPassword)
+	if err != nil {
+		return err
+	}
+	err = s.sessionsRepository.RevokeSessions(ctx, t.Username, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("the password of %s was reset and all of their sessions were revoked\n", t.Username)
+	return nil
+}
+
+func (s *AccountService) issueToken(ctx context.Context, t models.AccountToken, validity time.Duration) (string, error) {
+	tokenInBytes := make([]byte, 32)
+	_, err := rand.Read(tokenInBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate the %s token %s", t.Purpose, err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenInBytes)
+
+	t.CreatedOn = time.Now()
+	err = s.tokensRepository.SaveToken(ctx, hashToken(token), t, validity)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func tokenLink(path string, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", appBaseUrl, path, url.QueryEscape(token))
+}
+
+func sameEmail(a string, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/utils"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrUnsupportedProvider = errors.New("sharing to this network is not supported")
+
+var sharers = map[string]Sharer{
+	models.ProviderTwitter:  NewTwitterSharer(),
+	models.ProviderMastodon: NewMastodonSharer(),
+}
+
+/*
+ * Every CrossPostService shares the same queue, it is only started the first time something is cross posted
+ */
+var (
+	crossPostQueue     *JobQueue
+	crossPostQueueOnce sync.Once
+)
+
+func sharedCrossPostQueue() *JobQueue {
+	crossPostQueueOnce.Do(func() {
+		crossPostQueue = NewJobQueue(jobQueueWorkers)
+	})
+	return crossPostQueue
+}
+
+type CrossPostService struct {
+	shareService        ShareService
+	questionsRepository data.CassandraQuestionsRepository
+	crossPostRepository data.CassandraCrossPostRepository
+}
+
+func (s *CrossPostService) LinkAccount(ctx context.Context, caller string, provider string, account models.LinkedAccount) (models.LinkedAccount, error) {
+	if caller == "" {
+		return models.LinkedAccount{}, ErrUnauthenticated
+	}
+	account.Username = caller
+	account.Provider = provider
+	account.Handle = strings.TrimPrefix(strings.TrimSpace(account.Handle), "@")
+	account.InstanceUrl = strings.TrimSuffix(strings.TrimSpace(account.InstanceUrl), "/")
+	account.LinkedOn = time.Now()
+	err := utils.ValidateLinkedAccount(account)
+	if err != nil {
+		return models.LinkedAccount{}, err
+	}
+
+	err = s.crossPostRepository.SaveLinkedAccount(ctx, account)
+	if err != nil {
+		return models.LinkedAccount{}, err
+	}
+	return account, nil
+}
+
+func (s *CrossPostService) UnlinkAccount(ctx context.Context, caller string, provider string) error {
+	if caller == "" {
+		return ErrUnauthenticated
+	}
+	return s.crossPostRepository.DeleteLinkedAccount(ctx, caller, provider)
+}
+
+func (s *CrossPostService) GetLinkedAccounts(ctx context.Context, caller string) ([]models.LinkedAccount, error) {
+	if caller == "" {
+		return nil, ErrUnauthenticated
+	}
+	return s.crossPostRepository.GetLinkedAccounts(ctx, caller)
+}
+
+func (s *CrossPostService) GetShareJob(ctx context.Context, caller string, jobIdInString string) (models.ShareJob, error) {
+	if caller == "" {
+		return models.ShareJob{}, ErrUnauthenticated
+	}
+	jobId, err := uuid.Parse(jobIdInString)
+	if err != nil {
+		return models.ShareJob{}, data.ErrShareJobNotFound
+	}
+	return s.crossPostRepository.GetShareJob(ctx, caller, jobId)
+}
+
+/*
+ * The q&a is shared like any other share so that it gets its permalink, then the post is handed to the queue and the pending job is returned
+ * right away. The job is saved again every time its status changes, the client polls it to find out where the post ended up.
+ */
+func (s *CrossPostService) CrossPost(ctx context.Context, caller string, asked string, provider string, qAndAIdInString string) (models.ShareJob, error) {
+	sharer, ok := sharers[provider]
+	if !ok {
+		return models.ShareJob{}, ErrUnsupportedProvider
+	}
+	shared, err := s.shareService.Share(ctx, caller, asked, qAndAIdInString)
+	if err != nil {
+		return models.ShareJob{}, err
+	}
+	// The account is only checked here, it is read again from the database on every attempt in case the user relinks it in the meantime
+	_, err = s.crossPostRepository.GetLinkedAccount(ctx, caller, provider)
+	if err != nil {
+		return models.ShareJob{}, err
+	}
+	qAndA, err := s.questionsRepository.GetQAndA(ctx, shared.Asked, shared.QuestionId)
+	if err != nil {
+		return models.ShareJob{}, err
+	}
+
+	jobId, err := uuid.NewUUID()
+	if err != nil {
+		return models.ShareJob{}, fmt.Errorf("failed to create the share job %s", err)
+	}
+	job := models.ShareJob{
+		JobId:      jobId,
+		Username:   caller,
+		Provider:   provider,
+		QuestionId: shared.QuestionId,
+		Asked:      shared.Asked,
+		Status:     models.ShareJobPending,
+		CreatedOn:  time.Now(),
+	}
+	err = s.crossPostRepository.SaveShareJob(ctx, job)
+	if err != nil {
+		return models.ShareJob{}, err
+	}
+
+	post := sharer.Compose(qAndA.Question, qAndA.Asked, shared.Permalink)
+	post.IdempotencyKey = jobId.String()
+	sharedCrossPostQueue().Enqueue(Job{
+		Name: fmt.Sprintf("%s share %s", provider, jobId),
+		Run: func(ctx context.Context, attempt int) error {
+			remoteUrl, err := s.post(ctx, sharer, caller, post)
+			job.Attempts = attempt
+			job.RemoteUrl = remoteUrl
+			if err != nil {
+				job.LastError = err.Error()
+				// Keeps the attempts visible to the client while the job waits for its next attempt
+				saveErr := s.crossPostRepository.SaveShareJob(ctx, job)
+				if saveErr != nil {
+					log.Printf("failed to save the share job %s %s\n", job.JobId, saveErr)
+				}
+			}
+			return err
+		},
+		Done: func(attempts int, err error) {
+			job.Status = models.ShareJobDelivered
+			if err != nil {
+				job.Status = models.ShareJobFailed
+			}
+			// The request that created the job is long gone by now
+			err = s.crossPostRepository.SaveShareJob(context.Background(), job)
+			if err != nil {
+				log.Printf("failed to save the share job %s %s\n", job.JobId, err)
+			}
+		},
+	})
+
+	return job, nil
+}
+
+/*
+ * An expired token is refreshed before posting when the network allows it, the refreshed tokens are saved right away since the old refresh token
+ * usually stops working once it is used
+ */
+func (s *CrossPostService) post(ctx context.Context, sharer Sharer, username string, post SharePost) (string, error) {
+	account, err := s.crossPostRepository.GetLinkedAccount(ctx, username, sharer.Provider())
+	if errors.Is(err, data.ErrLinkedAccountNotFound) {
+		return "", Permanent(err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	refresher, canRefresh := sharer.(Refresher)
+	if canRefresh && !account.ExpiresOn.IsZero() && time.Now().After(account.ExpiresOn) {
+		account, err = refresher.Refresh(ctx, account)
+		if err != nil {
+			return "", err
+		}
+		err = s.crossPostRepository.SaveLinkedAccount(ctx, account)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return sharer.Share(ctx, account, post)
+}
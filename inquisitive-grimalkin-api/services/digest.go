@@ -0,0 +1,347 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	defaultDigestPeriod = 7 * 24 * time.Hour
+	digestCheckInterval = time.Hour
+	// Long enough for one digest to be collected and sent, a claim left behind by an instance that died is picked up again after it
+	digestClaimDuration = 30 * time.Minute
+	digestSendTimeout   = time.Minute
+	maxDigestQuestions  = 10
+	maxDigestQAndAs     = 5
+	digestExcerptLength = 280
+)
+
+var (
+	digestPeriod   = defaultDigestPeriod
+	digestsEnabled = true
+)
+
+/*
+ * EMAIL_DIGEST_PERIOD is how long the users wait between two digests, as a Go duration like 168h, and off stops this instance from sending any. Every
+ * instance that runs RunSchedule checks for due digests every hour, the digests are claimed one by one so running it on several instances sends each
+ * of them once.
+ */
+func init() {
+	godotenv.Load()
+	period := os.Getenv("EMAIL_DIGEST_PERIOD")
+	switch period {
+	case "":
+	case "off":
+		log.Printf("EMAIL_DIGEST_PERIOD is off, this instance does not send the email digests\n")
+		digestsEnabled = false
+	default:
+		parsed, err := time.ParseDuration(period)
+		if err != nil || parsed <= 0 {
+			log.Printf("EMAIL_DIGEST_PERIOD %q is not a positive duration, the digests are sent every %s\n", period, defaultDigestPeriod)
+		} else {
+			digestPeriod = parsed
+		}
+	}
+}
+
+var digestTextTemplate = template.Must(template.New("digest.txt").Parse(`Hi {{.FirstName}},
+{{if .Questions}}
+{{if eq .NewQuestions 1}}You got a new question{{else}}You got {{.NewQuestions}} new questions{{end}} since your last digest:
+{{range .Questions}}
+- {{if .IsAnon}}Someone{{else}}{{.Asker}}{{end}} asked: {{.Question}}
+{{- end}}
+
+Answer them in your inbox: {{.InboxUrl}}
+{{end}}{{if .QAndAs}}
+The most liked answers from the people you follow:
+{{range .QAndAs}}
+{{.Asked}} answered "{{.Question}}"
+{{.Answer}}
+{{.Likes}} {{if eq .Likes 1}}like{{else}}likes{{end}} - {{.Url}}
+{{end}}{{end}}
+You get this mail because you asked for a digest of your account {{.Username}}. You can stop it in your settings: {{.SettingsUrl}}
+`))
+
+var digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest.html").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #222; max-width: 600px; margin: 0 auto;">
+<p>Hi {{.FirstName}},</p>
+{{if .Questions}}
+<h2>{{if eq .NewQuestions 1}}You got a new question{{else}}You got {{.NewQuestions}} new questions{{end}}</h2>
+<ul>
+{{range .Questions}}<li><strong>{{if .IsAnon}}Someone{{else}}{{.Asker}}{{end}}</strong> asked: {{.Question}}</li>
+{{end}}</ul>
+<p><a href="{{.InboxUrl}}">Answer them in your inbox</a></p>
+{{end}}
+{{if .QAndAs}}
+<h2>The most liked answers from the people you follow</h2>
+{{range .QAndAs}}<div style="margin-bottom: 16px;">
+<p style="margin: 0;"><strong>{{.Asked}}</strong> answered <em>{{.Question}}</em></p>
+<p style="margin: 4px 0;">{{.Answer}}</p>
+<p style="margin: 0; color: #777;">{{.Likes}} {{if eq .Likes 1}}like{{else}}likes{{end}} &middot; <a href="{{.Url}}">See it</a></p>
+</div>
+{{end}}{{end}}
+<p style="color: #777; font-size: 12px;">You get this mail because you asked for a digest of your account {{.Username}}.
+<a href="{{.SettingsUrl}}">Stop the digest</a></p>
+</body>
+</html>
+`))
+
+type digestQAndA struct {
+	models.QAndA
+	Likes int64
+	Url   string
+}
+
+type digest struct {
+	FirstName    string
+	Username     string
+	NewQuestions int
+	Questions    []models.Question
+	QAndAs       []digestQAndA
+	InboxUrl     string
+	SettingsUrl  string
+}
+
+/*
+ * A periodic mail for the users who opted in, with the questions they received and the most liked answers of the people they follow since the last
+ * one. The send state of every user is kept in the email_digests table so that nobody gets the same digest twice.
+ */
+type DigestService struct {
+	digestRepository    data.CassandraDigestRepository
+	usersRepository     data.CassandraUsersRepository
+	questionsRepository data.CassandraQuestionsRepository
+	likesRepository     data.CassandraLikesRepository
+}
+
+func (s *DigestService) GetDigestState(ctx context.Context, username string) (models.DigestState, error) {
+	if username == "" {
+		return models.DigestState{}, ErrUnauthenticated
+	}
+	return s.digestRepository.GetDigestState(ctx, username)
+}
+
+func (s *DigestService) SetDigestOptIn(ctx context.Context, username string, optedIn bool) (models.DigestState, error) {
+	if username == "" {
+		return models.DigestState{}, ErrUnauthenticated
+	}
+	err := s.digestRepository.SetDigestOptIn(ctx, username, optedIn, time.Now())
+	if err != nil {
+		return models.DigestState{}, err
+	}
+	return s.digestRepository.GetDigestState(ctx, username)
+}
+
+/*
+ * Sends the due digests every digestCheckInterval until the context is cancelled. Nothing starts it on its own, the server runs it next to the routers
+ * with the context it cancels when shutting down.
+ */
+func (s *DigestService) RunSchedule(ctx context.Context) {
+	if !digestsEnabled {
+		return
+	}
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sent, err := s.SendDueDigests(ctx, time.Now())
+			if err != nil {
+				log.Printf("failed to go through the email digests %s\n", err)
+				continue
+			}
+			if sent > 0 {
+				log.Printf("sent %d email digests\n", sent)
+			}
+		}
+	}
+}
+
+/*
+ * Sends the digest of every user who opted in and got their last one at least a period ago. A digest that fails is only logged, it is picked up again
+ * once its claim expires.
+ */
+func (s *DigestService) SendDueDigests(ctx context.Context, now time.Time) (int, error) {
+	states, err := s.digestRepository.GetDigestStates(ctx)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, state := range states {
+		if !state.OptedIn || now.Sub(state.LastSentOn) < digestPeriod || state.ClaimedUntil.After(now) {
+			continue
+		}
+		ok, err := s.sendDigest(ctx, state, now)
+		if err != nil {
+			log.Printf("failed to send the email digest of %s %s\n", state.Username, err)
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+/*
+ * Everything up to now goes in the digest, anything that comes in while it is being sent goes in the next one. A user with nothing new, a suspended
+ * user or a user without a verified email is skipped for this period and gets no mail.
+ *
+ * The digest is recorded as sent before the mail goes out, a mail cannot be taken back but a record can, so a digest is never sent twice. When the mail
+ * fails the previous record is put back and the digest is tried again once the claim is released.
+ */
+func (s *DigestService) sendDigest(ctx context.Context, state models.DigestState, now time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, digestSendTimeout)
+	defer cancel()
+
+	claimed, err := s.digestRepository.ClaimDigest(ctx, state, now.Add(digestClaimDuration))
+	if err != nil || !claimed {
+		return false, err
+	}
+	// Left behind, the claim would only hold the digest back until it expires
+	defer func() {
+		err := s.digestRepository.ReleaseDigestClaim(ctx, state.Username)
+		if err != nil {
+			log.Printf("%s\n", err)
+		}
+	}()
+
+	u, err := s.usersRepository.GetUser(ctx, state.Username)
+	if errors.Is(err, data.ErrUserNotFound) {
+		return false, s.digestRepository.DeleteDigestState(ctx, state.Username)
+	}
+	if err != nil {
+		return false, err
+	}
+	status, err := s.usersRepository.GetAccountStatus(ctx, u.Username)
+	if err != nil {
+		return false, err
+	}
+	if !u.EmailVerified || status.IsSuspended(now) {
+		return false, s.digestRepository.MarkDigestSent(ctx, u.Username, now)
+	}
+
+	d, err := s.collectDigest(ctx, u, state.LastSentOn, now)
+	if err != nil {
+		return false, err
+	}
+	if len(d.Questions) == 0 && len(d.QAndAs) == 0 {
+		return false, s.digestRepository.MarkDigestSent(ctx, u.Username, now)
+	}
+
+	var text, html bytes.Buffer
+	err = digestTextTemplate.Execute(&text, d)
+	if err != nil {
+		return false, fmt.Errorf("failed to render the digest %s", err)
+	}
+	err = digestHTMLTemplate.Execute(&html, d)
+	if err != nil {
+		return false, fmt.Errorf("failed to render the digest %s", err)
+	}
+	err = s.digestRepository.MarkDigestSent(ctx, u.Username, now)
+	if err != nil {
+		return false, err
+	}
+	err = mailer.Send(ctx, Mail{
+		To:       u.Email,
+		Subject:  digestSubject(d),
+		Body:     text.String(),
+		HTMLBody: html.String(),
+	})
+	if err != nil {
+		restoreErr := s.digestRepository.MarkDigestSent(ctx, u.Username, state.LastSentOn)
+		if restoreErr != nil {
+			log.Printf("the digest of %s was not sent and is skipped for this period %s\n", u.Username, restoreErr)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *DigestService) collectDigest(ctx context.Context, u models.User, since time.Time, until time.Time) (digest, error) {
+	d := digest{
+		FirstName:   u.FirstName,
+		Username:    u.Username,
+		InboxUrl:    appBaseUrl + "/inbox",
+		SettingsUrl: appBaseUrl + "/settings",
+	}
+	if d.FirstName == "" {
+		d.FirstName = u.Username
+	}
+
+	questions, err := s.questionsRepository.GetUnansweredQuestionsForUser(ctx, u.Username)
+	if err != nil {
+		return digest{}, err
+	}
+	for _, q := range questions {
+		askedOn := time.Unix(q.QuestionId.Time().UnixTime())
+		if askedOn.After(since) && !askedOn.After(until) {
+			q.Question = truncate(q.Question, digestExcerptLength)
+			d.Questions = append(d.Questions, q)
+		}
+	}
+	// Timeuuids of the same version sort by time, the newest questions come first
+	sort.Slice(d.Questions, func(i, j int) bool {
+		return d.Questions[i].QuestionId.Time() > d.Questions[j].QuestionId.Time()
+	})
+	d.NewQuestions = len(d.Questions)
+	if len(d.Questions) > maxDigestQuestions {
+		d.Questions = d.Questions[:maxDigestQuestions]
+	}
+
+	qAndAs, err := s.questionsRepository.GetHomefeedAnsweredSince(ctx, u.Username, since)
+	if err != nil {
+		return digest{}, err
+	}
+	for _, qAndA := range qAndAs {
+		if qAndA.AnsweredOn.After(until) {
+			continue
+		}
+		likes, err := s.likesRepository.GetLikesForQAndA(ctx, qAndA.QuestionId)
+		if err != nil {
+			return digest{}, err
+		}
+		qAndA.Question = truncate(qAndA.Question, digestExcerptLength)
+		qAndA.Answer = truncate(qAndA.Answer, digestExcerptLength)
+		d.QAndAs = append(d.QAndAs, digestQAndA{
+			QAndA: qAndA,
+			Likes: likes,
+			Url:   fmt.Sprintf("%s/%s", appBaseUrl, url.PathEscape(qAndA.Asked)),
+		})
+	}
+	sort.SliceStable(d.QAndAs, func(i, j int) bool {
+		if d.QAndAs[i].Likes != d.QAndAs[j].Likes {
+			return d.QAndAs[i].Likes > d.QAndAs[j].Likes
+		}
+		return d.QAndAs[i].AnsweredOn.After(d.QAndAs[j].AnsweredOn)
+	})
+	if len(d.QAndAs) > maxDigestQAndAs {
+		d.QAndAs = d.QAndAs[:maxDigestQAndAs]
+	}
+	return d, nil
+}
+
+func digestSubject(d digest) string {
+	switch {
+	case d.NewQuestions == 1:
+		return "You got a new question"
+	case d.NewQuestions > 1:
+		return fmt.Sprintf("You got %d new questions", d.NewQuestions)
+	}
+	return "What the people you follow answered"
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ErasureService struct {
+	usersRepository         data.CassandraUsersRepository
+	questionsRepository     data.CassandraQuestionsRepository
+	likesRepository         data.CassandraLikesRepository
+	settingsRepository      data.CassandraSettingsRepository
+	erasureRepository       data.CassandraErasureRepository
+	crossPostRepository     data.CassandraCrossPostRepository
+	spamRepository          data.CassandraSpamRepository
+	notificationsRepository data.CassandraNotificationsRepository
+	pushRepository          data.CassandraPushRepository
+	digestRepository        data.CassandraDigestRepository
+	sessionsRepository      data.CassandraSessionsRepository
+}
+
+/*
+ * The erasure touches every table the user ever wrote to or was written to, which can take a while for users with many followers, so it is only
+ * recorded here and then carried out in the background. The returned record can be used to follow up on it.
+ */
+func (s *ErasureService) RequestErasure(ctx context.Context, username string) (models.ErasureRecord, error) {
+	erasureId, err := uuid.NewUUID()
+	if err != nil {
+		return models.ErasureRecord{}, fmt.Errorf("failed to request the erasure %s", err)
+	}
+
+	record := models.ErasureRecord{
+		ErasureId:   erasureId,
+		Subject:     erasureSubject(username),
+		Status:      models.ErasurePending,
+		RequestedOn: time.Now(),
+		Steps:       []string{},
+	}
+	err = s.erasureRepository.SaveErasureRecord(ctx, record)
+	if err != nil {
+		return models.ErasureRecord{}, err
+	}
+
+	// The erasure must not be cancelled once the request that started it is done
+	go s.erase(context.Background(), username, record)
+
+	return record, nil
+}
+
+/*
+ * The erasure will have multiple steps:
+ * 1) Revoke the sessions of the user, then delete the account, its settings, its digest state and its linked accounts, from then on nobody can log in
+ *    as the user or ask them anything. The revocation is kept, a token of the user must not be accepted for whoever registers the username next.
+ * 2) Delete the questions the user received and never answered, along with the ones quarantined as spam.
+ * 3) Delete the answers of the user, from their profile, from the homefeeds of their followers and from the likes counter table.
+ * 4) Delete the homefeed of the user.
+ * 5) Delete the questions the user asked that were never answered and anonymize the ones that were, the answers belong to the people who gave them.
+ * 6) Unfollow the user from everyone following them and everyone they follow, which also corrects the counters of the other users.
+ * 7) Delete the counters of the user.
+ * 8) Delete the notifications of the user, the ones they caused for others expire on their own.
+ * Likes are only kept as counters per q&a and are not tied to whoever gave them, so there is nothing to erase there.
+ * Every step can be run again safely, so a failed erasure can be requested again and it will carry on from where it stopped.
+ */
+func (s *ErasureService) erase(ctx context.Context, username string, record models.ErasureRecord) {
+	steps := []func(context.Context, string) (string, error){
+		s.eraseAccount,
+		s.eraseReceivedQuestions,
+		s.eraseAnswers,
+		s.eraseHomefeed,
+		s.eraseAskedQuestions,
+		s.eraseFollows,
+		s.eraseCounters,
+		s.eraseNotifications,
+	}
+
+	record.Status = models.ErasureCompleted
+	for _, step := range steps {
+		summary, err := step(ctx, username)
+		if err != nil {
+			record.Status = models.ErasureFailed
+			record.Steps = append(record.Steps, fmt.Sprintf("failed: %s", err))
+			break
+		}
+		record.Steps = append(record.Steps, summary)
+	}
+	record.CompletedOn = time.Now()
+
+	err := s.erasureRepository.SaveErasureRecord(ctx, record)
+	if err != nil {
+		log.Printf("failed to save the erasure record %s %s\n", record.ErasureId, err)
+		return
+	}
+	log.Printf("erasure %s finished with status %s\n", record.ErasureId, record.Status)
+}
+
+func (s *ErasureService) eraseAccount(context context.Context, username string) (string, error) {
+	err := s.sessionsRepository.RevokeSessions(context, username, time.Now())
+	if err != nil {
+		return "", err
+	}
+	err = s.usersRepository.Delete(context, models.User{Username: username})
+	if err != nil {
+		return "", err
+	}
+	err = s.settingsRepository.DeleteSettings(context, username)
+	if err != nil {
+		return "", err
+	}
+	err = s.digestRepository.DeleteDigestState(context, username)
+	if err != nil {
+		return "", err
+	}
+	err = s.crossPostRepository.DeleteLinkedAccounts(context, username)
+	if err != nil {
+		return "", err
+	}
+	return "account: revoked the sessions and deleted the user, their email, their settings, their digest state and their linked accounts", nil
+}
+
+func (s *ErasureService) eraseReceivedQuestions(context context.Context, username string) (string, error) {
+	questions, err := s.questionsRepository.GetUnansweredQuestionsForUser(context, username)
+	if err != nil {
+		return "", err
+	}
+	for _, q := range questions {
+		err := s.questionsRepository.DeleteUnansweredQuestion(context, q)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = s.spamRepository.DeleteQuarantinedQuestions(context, username)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("received questions: deleted %d unanswered questions and the quarantined ones", len(questions)), nil
+}
+
+func (s *ErasureService) eraseAnswers(context context.Context, username string) (string, error) {
+	answers, err := s.questionsRepository.GetAnsweredQuestionsForUser(context, username)
+	if err != nil {
+		return "", err
+	}
+	followers, err := s.usersRepository.FindFollowersOfUser(context, username)
+	if err != nil {
+		return "", err
+	}
+	for _, qAndA := range answers {
+		err := s.questionsRepository.DeleteAnswerFromFollowersHomefeed(context, qAndA, followers...)
+		if err != nil {
+			return "", err
+		}
+		err = s.likesRepository.DeleteQAndA(context, qAndA.QuestionId)
+		if err != nil {
+			return "", err
+		}
+		err = s.questionsRepository.DeleteQAndA(context, qAndA)
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("answers: deleted %d q&as from the profile, the likes and %d homefeeds", len(answers), len(followers)), nil
+}
+
+func (s *ErasureService) eraseHomefeed(context context.Context, username string) (string, error) {
+	err := s.questionsRepository.DeleteHomefeed(context, username)
+	if err != nil {
+		return "", err
+	}
+	return "homefeed: deleted", nil
+}
+
+func (s *ErasureService) eraseAskedQuestions(context context.Context, username string) (string, error) {
+	askedQuestions, err := s.questionsRepository.GetQuestionsAskedByUser(context, username)
+	if err != nil {
+		return "", err
+	}
+
+	deleted, anonymized := 0, 0
+	for _, q := range askedQuestions {
+		if !q.Answered {
+			err := s.questionsRepository.DeleteUnansweredQuestion(context, models.Question{
+				QuestionId: q.QuestionId,
+				Asked:      q.Asked,
+				Asker:      username,
+				IsAnon:     q.IsAnon,
+			})
+			if err != nil {
+				return "", err
+			}
+			deleted++
+			continue
+		}
+
+		anonymizedQAndA, err := s.questionsRepository.AnonymizeAsker(context, q.Asked, q.QuestionId)
+		if errors.Is(err, data.ErrQuestionNotFound) {
+			// The q&a was deleted by the person who answered it in the meantime
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		followersOfAsked, err := s.usersRepository.FindFollowersOfUser(context, q.Asked)
+		if err != nil {
+			return "", err
+		}
+		err = s.questionsRepository.UpdateAnswerToFollowersHomefeed(context, anonymizedQAndA, followersOfAsked...)
+		if err != nil {
+			return "", err
+		}
+		anonymized++
+	}
+
+	err = s.questionsRepository.DeleteQuestionsAskedByUser(context, username)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("asked questions: deleted %d unanswered questions and anonymized %d answered ones", deleted, anonymized), nil
+}
+
+func (s *ErasureService) eraseFollows(context context.Context, username string) (string, error) {
+	followers, err := s.usersRepository.FindFollowersOfUser(context, username)
+	if err != nil {
+		return "", err
+	}
+	for _, follower := range followers {
+		err := s.usersRepository.Unfollow(context, follower.Username, username)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	following, err := s.usersRepository.FindFollowingOfUser(context, username)
+	if err != nil {
+		return "", err
+	}
+	for _, followed := range following {
+		err := s.usersRepository.Unfollow(context, username, followed.Username)
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("follows: removed %d followers and %d followed users", len(followers), len(following)), nil
+}
+
+func (s *ErasureService) eraseCounters(context context.Context, username string) (string, error) {
+	err := s.usersRepository.DeleteCounters(context, username)
+	if err != nil {
+		return "", err
+	}
+	return "counters: deleted", nil
+}
+
+func (s *ErasureService) eraseNotifications(context context.Context, username string) (string, error) {
+	err := s.notificationsRepository.DeleteNotifications(context, username)
+	if err != nil {
+		return "", err
+	}
+	err = s.pushRepository.DeletePushSubscriptions(context, username)
+	if err != nil {
+		return "", err
+	}
+	return "notifications: deleted, along with the push subscriptions", nil
+}
+
+func erasureSubject(username string) string {
+	hash := sha256.Sum256([]byte(username))
+	return hex.EncodeToString(hash[:])
+}
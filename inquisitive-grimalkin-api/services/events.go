@@ -0,0 +1,126 @@
+package services
+
+import (
+	"errors"
+	"inquisitive-grimalkin/models"
+	"sync"
+)
+
+const (
+	// How many events can wait for a slow connection before it is dropped
+	eventBufferSize         = 32
+	maxSubscriptionsPerUser = 5
+)
+
+var ErrTooManySubscriptions = errors.New("the user already has too many open event streams")
+
+/*
+ * A Subscription receives the events of one user for one connection. Events is closed once the subscription is over, Overflowed then tells whether it
+ * was dropped for falling behind, the client has missed events and has to fetch what it shows again.
+ */
+type Subscription struct {
+	Username   string
+	Events     <-chan models.Event
+	events     chan models.Event
+	overflowed bool
+}
+
+func (s *Subscription) Overflowed() bool {
+	return s.overflowed
+}
+
+/*
+ * Fans the events published by the services out to the connections of their users. Publishing never waits for a connection, a connection whose
+ * buffer is full is dropped instead so that one slow client cannot hold up the requests publishing to it.
+ *
+ * The hub only knows the connections made to this instance, the events published by the others do not reach them.
+ */
+type EventHub struct {
+	sync.Mutex
+	subscriptions map[string]map[*Subscription]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscriptions: map[string]map[*Subscription]struct{}{}}
+}
+
+var eventHub = NewEventHub()
+
+func (h *EventHub) Subscribe(username string) (*Subscription, error) {
+	h.Lock()
+	defer h.Unlock()
+	if len(h.subscriptions[username]) >= maxSubscriptionsPerUser {
+		return nil, ErrTooManySubscriptions
+	}
+	events := make(chan models.Event, eventBufferSize)
+	s := &Subscription{Username: username, Events: events, events: events}
+	if h.subscriptions[username] == nil {
+		h.subscriptions[username] = map[*Subscription]struct{}{}
+	}
+	h.subscriptions[username][s] = struct{}{}
+	return s, nil
+}
+
+// Safe to call for a subscription that was already dropped
+func (h *EventHub) Unsubscribe(s *Subscription) {
+	h.Lock()
+	defer h.Unlock()
+	h.remove(s)
+}
+
+func (h *EventHub) Publish(username string, e models.Event) {
+	h.Lock()
+	defer h.Unlock()
+	for s := range h.subscriptions[username] {
+		select {
+		case s.events <- e:
+		default:
+			s.overflowed = true
+			h.remove(s)
+		}
+	}
+}
+
+func (h *EventHub) remove(s *Subscription) {
+	subscriptions, ok := h.subscriptions[s.Username]
+	if !ok {
+		return
+	}
+	if _, ok := subscriptions[s]; !ok {
+		return
+	}
+	delete(subscriptions, s)
+	close(s.events)
+	if len(subscriptions) == 0 {
+		delete(h.subscriptions, s.Username)
+	}
+}
+
+// The stream of the authenticated user, see EventHub
+type EventsService struct {
+}
+
+func (s *EventsService) Subscribe(username string) (*Subscription, error) {
+	if username == "" {
+		return nil, ErrUnauthenticated
+	}
+	return eventHub.Subscribe(username)
+}
+
+func (s *EventsService) Unsubscribe(subscription *Subscription) {
+	eventHub.Unsubscribe(subscription)
+}
+
+func publish(username string, kind string, payload any) {
+	if username == "" {
+		return
+	}
+	eventHub.Publish(username, models.Event{Kind: kind, Payload: payload})
+}
+
+// Whether the user has a stream open on this instance, a user connected to another instance counts as not connected
+func (h *EventHub) IsConnected(username string) bool {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.subscriptions[username]) > 0
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+)
+
+const exportLinkValidity = 24 * time.Hour
+
+var (
+	ErrDataExportsDisabled = errors.New("data exports are not configured on this server")
+	ErrInvalidDownloadLink = errors.New("the download link is not valid")
+	ErrDownloadLinkExpired = errors.New("the download link has expired")
+)
+
+var exportsDirectory string
+
+// Empty when EXPORT_SIGNING_KEY is not set, no export is built or served then since anyone could sign a download link with an empty key
+var exportSigningKey string
+
+func init() {
+	godotenv.Load()
+	exportsDirectory = os.Getenv("EXPORTS_DIRECTORY")
+	if exportsDirectory == "" {
+		exportsDirectory = filepath.Join(os.TempDir(), "inquisitive-grimalkin-exports")
+	}
+	exportSigningKey = os.Getenv("EXPORT_SIGNING_KEY")
+	if exportSigningKey == "" {
+		log.Printf("EXPORT_SIGNING_KEY is not set, data exports are turned off\n")
+	}
+}
+
+type ExportService struct {
+	usersRepository     data.CassandraUsersRepository
+	questionsRepository data.CassandraQuestionsRepository
+	likesRepository     data.CassandraLikesRepository
+	exportsRepository   data.CassandraExportsRepository
+}
+
+/*
+ * Building the archive reads every table the user has data in, so it is only recorded here and then built in the background. The client polls the
+ * export until it is completed and gets the download link from there.
+ */
+func (s *ExportService) RequestExport(ctx context.Context, username string) (models.DataExport, error) {
+	if exportSigningKey == "" {
+		return models.DataExport{}, ErrDataExportsDisabled
+	}
+	exportId, err := uuid.NewUUID()
+	if err != nil {
+		return models.DataExport{}, fmt.Errorf("failed to request the export %s", err)
+	}
+
+	export := models.DataExport{
+		ExportId:    exportId,
+		Username:    username,
+		Status:      models.ExportPending,
+		RequestedOn: time.Now(),
+	}
+	err = s.exportsRepository.SaveExport(ctx, export)
+	if err != nil {
+		return models.DataExport{}, err
+	}
+
+	// The export must not be cancelled once the request that started it is done
+	go s.build(context.Background(), export)
+
+	return export, nil
+}
+
+func (s *ExportService) GetExport(ctx context.Context, username string, exportIdInString string) (models.DataExport, error) {
+	if exportSigningKey == "" {
+		return models.DataExport{}, ErrDataExportsDisabled
+	}
+	exportId, err := uuid.Parse(exportIdInString)
+	if err != nil {
+		return models.DataExport{}, data.ErrExportNotFound
+	}
+	export, err := s.exportsRepository.GetExport(ctx, username, exportId)
+	if err != nil {
+		return models.DataExport{}, err
+	}
+	if export.Status == models.ExportCompleted && time.Now().Before(export.ExpiresOn) {
+		export.DownloadUrl = signedDownloadUrl(export.ExportId, export.ExpiresOn)
+	}
+	return export, nil
+}
+
+/*
+ * The download link is the only thing needed to fetch the archive, so nothing is served unless its signature matches and it has not expired yet
+ */
+func (s *ExportService) OpenArchive(exportIdInString string, expiresInString string, signature string) (*os.File, error) {
+	if exportSigningKey == "" {
+		return nil, ErrDataExportsDisabled
+	}
+	exportId, err := uuid.Parse(exportIdInString)
+	if err != nil {
+		return nil, ErrInvalidDownloadLink
+	}
+	expiresInUnix, err := strconv.ParseInt(expiresInString, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidDownloadLink
+	}
+	expectedSignature := signDownload(exportId, expiresInUnix)
+	if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
+		return nil, ErrInvalidDownloadLink
+	}
+	if time.Now().After(time.Unix(expiresInUnix, 0)) {
+		return nil, ErrDownloadLinkExpired
+	}
+
+	archive, err := os.Open(archivePath(exportId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the archive of the export %s %s", exportId, err)
+	}
+	return archive, nil
+}
+
+func (s *ExportService) build(ctx context.Context, export models.DataExport) {
+	err := s.writeArchiveToDisk(ctx, export)
+	if err != nil {
+		log.Printf("failed to build the export %s %s\n", export.ExportId, err)
+		export.Status = models.ExportFailed
+	} else {
+		export.Status = models.ExportCompleted
+	}
+	export.CompletedOn = time.Now()
+	export.ExpiresOn = export.CompletedOn.Add(exportLinkValidity)
+
+	err = s.exportsRepository.SaveExport(ctx, export)
+	if err != nil {
+		log.Printf("failed to save the export %s %s\n", export.ExportId, err)
+	}
+	removeExpiredArchives()
+}
+
+func (s *ExportService) writeArchiveToDisk(ctx context.Context, export models.DataExport) error {
+	err := os.MkdirAll(exportsDirectory, 0700)
+	if err != nil {
+		return err
+	}
+
+	// The archive is written under a temporary name and only renamed once complete, so a half written archive can never be downloaded
+	partialPath := archivePath(export.ExportId) + ".partial"
+	archive, err := os.OpenFile(partialPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = s.writeArchive(ctx, export.Username, archive)
+	closeErr := archive.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+	return os.Rename(partialPath, archivePath(export.ExportId))
+}
+
+/*
+ * The archive holds one file per kind of data, the profile as JSON and every list as NDJSON so that it can be processed line by line
+ */
+func (s *ExportService) writeArchive(ctx context.Context, username string, w io.Writer) error {
+	zipWriter := zip.NewWriter(w)
+
+	profile, err := s.usersRepository.GetUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	err = writeJSONEntry(zipWriter, "profile.json", profile)
+	if err != nil {
+		return err
+	}
+
+	receivedQuestions, err := s.questionsRepository.GetUnansweredQuestionsForUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	err = writeNDJSONEntry(zipWriter, "questions_received.ndjson", receivedQuestions)
+	if err != nil {
+		return err
+	}
+
+	answers, err := s.questionsRepository.GetAnsweredQuestionsForUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	err = writeNDJSONEntry(zipWriter, "answers.ndjson", answers)
+	if err != nil {
+		return err
+	}
+
+	// Anonymous questions are left out on purpose, the archive could end up in the hands of someone else
+	askedQuestions, err := s.questionsRepository.GetQuestionsAskedByUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	nonAnonymousQuestions := []models.AskedQuestion{}
+	for _, q := range askedQuestions {
+		if !q.IsAnon {
+			nonAnonymousQuestions = append(nonAnonymousQuestions, q)
+		}
+	}
+	err = writeNDJSONEntry(zipWriter, "questions_asked.ndjson", nonAnonymousQuestions)
+	if err != nil {
+		return err
+	}
+
+	followers, err := s.usersRepository.FindFollowersOfUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	err = writeNDJSONEntry(zipWriter, "followers.ndjson", usernamesOf(followers))
+	if err != nil {
+		return err
+	}
+
+	following, err := s.usersRepository.FindFollowingOfUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	err = writeNDJSONEntry(zipWriter, "following.ndjson", usernamesOf(following))
+	if err != nil {
+		return err
+	}
+
+	// Likes are only counted per q&a, so what can be exported are the likes the answers of the user received
+	type likesOfAnswer struct {
+		QuestionId uuid.UUID `json:"questionId"`
+		Likes      int64     `json:"likes"`
+	}
+	likes := []likesOfAnswer{}
+	for _, a := range answers {
+		count, err := s.likesRepository.GetLikesForQAndA(ctx, a.QuestionId)
+		if err != nil {
+			return err
+		}
+		likes = append(likes, likesOfAnswer{QuestionId: a.QuestionId, Likes: count})
+	}
+	err = writeNDJSONEntry(zipWriter, "likes.ndjson", likes)
+	if err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+func writeJSONEntry(zipWriter *zip.Writer, name string, v any) error {
+	entry, err := zipWriter.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func writeNDJSONEntry[T any](zipWriter *zip.Writer, name string, lines []T) error {
+	entry, err := zipWriter.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	for _, line := range lines {
+		err := encoder.Encode(line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func usernamesOf(users []models.User) []map[string]string {
+	usernames := make([]map[string]string, 0, len(users))
+	for _, u := range users {
+		usernames = append(usernames, map[string]string{"username": u.Username})
+	}
+	return usernames
+}
+
+func archivePath(exportId uuid.UUID) string {
+	return filepath.Join(exportsDirectory, exportId.String()+".zip")
+}
+
+func removeExpiredArchives() {
+	entries, err := os.ReadDir(exportsDirectory)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > exportLinkValidity {
+			os.Remove(filepath.Join(exportsDirectory, entry.Name()))
+		}
+	}
+}
+
+func signedDownloadUrl(exportId uuid.UUID, expiresOn time.Time) string {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresOn.Unix(), 10))
+	query.Set("signature", signDownload(exportId, expiresOn.Unix()))
+	return fmt.Sprintf("/users/exports/%s?%s", exportId, query.Encode())
+}
+
+func signDownload(exportId uuid.UUID, expiresInUnix int64) string {
+	mac := hmac.New(sha256.New, []byte(exportSigningKey))
+	mac.Write([]byte(fmt.Sprintf("%s:%d", exportId, expiresInUnix)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
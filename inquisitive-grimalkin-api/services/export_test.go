@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func useTestExports(t *testing.T, signingKey string) {
+	t.Helper()
+	previousKey, previousDirectory := exportSigningKey, exportsDirectory
+	exportSigningKey, exportsDirectory = signingKey, t.TempDir()
+	t.Cleanup(func() { exportSigningKey, exportsDirectory = previousKey, previousDirectory })
+}
+
+// Splits a download link into what the router hands to OpenArchive
+func parseDownloadUrl(t *testing.T, downloadUrl string) (string, string, string) {
+	t.Helper()
+	parsed, err := url.Parse(downloadUrl)
+	if err != nil {
+		t.Fatalf("the download link is not a url %s", err)
+	}
+	return strings.TrimPrefix(parsed.Path, "/users/exports/"), parsed.Query().Get("expires"), parsed.Query().Get("signature")
+}
+
+func TestOpenArchive(t *testing.T) {
+	useTestExports(t, "the signing key")
+	exportId := uuid.New()
+	err := os.WriteFile(archivePath(exportId), []byte("archive"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := ExportService{}
+	archive, err := s.OpenArchive(parseDownloadUrl(t, signedDownloadUrl(exportId, time.Now().Add(time.Hour))))
+	if err != nil {
+		t.Fatalf("a link signed with the key was refused %s", err)
+	}
+	archive.Close()
+
+	_, err = s.OpenArchive(parseDownloadUrl(t, signedDownloadUrl(exportId, time.Now().Add(-time.Second))))
+	if !errors.Is(err, ErrDownloadLinkExpired) {
+		t.Errorf("got %v for an expired link, want ErrDownloadLinkExpired", err)
+	}
+}
+
+func TestOpenArchiveForgedLinks(t *testing.T) {
+	useTestExports(t, "the signing key")
+	exportId := uuid.New()
+	err := os.WriteFile(archivePath(exportId), []byte("archive"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresOn := time.Now().Add(time.Hour)
+
+	signedWith := func(key string) string {
+		exportSigningKey = key
+		defer func() { exportSigningKey = "the signing key" }()
+		return signedDownloadUrl(exportId, expiresOn)
+	}
+	tests := []struct {
+		name        string
+		downloadUrl string
+	}{
+		{name: "another key", downloadUrl: signedWith("another key")},
+		{name: "empty key", downloadUrl: signedWith("")},
+		{name: "another export", downloadUrl: strings.Replace(signedDownloadUrl(exportId, expiresOn), exportId.String(), uuid.NewString(), 1)},
+		{name: "later expiry", downloadUrl: strings.Replace(signedDownloadUrl(exportId, expiresOn), "expires=", "expires=9", 1)},
+		{name: "no signature", downloadUrl: "/users/exports/" + exportId.String() + "?expires=" + strconv.FormatInt(expiresOn.Unix(), 10)},
+	}
+	s := ExportService{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.OpenArchive(parseDownloadUrl(t, tt.downloadUrl))
+			if !errors.Is(err, ErrInvalidDownloadLink) {
+				t.Errorf("got %v, want ErrInvalidDownloadLink", err)
+			}
+		})
+	}
+}
+
+func TestExportsWithoutSigningKey(t *testing.T) {
+	useTestExports(t, "")
+	exportId := uuid.New()
+	err := os.WriteFile(archivePath(exportId), []byte("archive"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a key the link signed with the empty key would match, nothing is served at all
+	s := ExportService{}
+	_, err = s.OpenArchive(parseDownloadUrl(t, signedDownloadUrl(exportId, time.Now().Add(time.Hour))))
+	if !errors.Is(err, ErrDataExportsDisabled) {
+		t.Errorf("got %v for a link signed with the empty key, want ErrDataExportsDisabled", err)
+	}
+	_, err = s.RequestExport(context.Background(), "grimalkin")
+	if !errors.Is(err, ErrDataExportsDisabled) {
+		t.Errorf("got %v when requesting an export, want ErrDataExportsDisabled", err)
+	}
+	_, err = s.GetExport(context.Background(), "grimalkin", exportId.String())
+	if !errors.Is(err, ErrDataExportsDisabled) {
+		t.Errorf("got %v when fetching an export, want ErrDataExportsDisabled", err)
+	}
+}
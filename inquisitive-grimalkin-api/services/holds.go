@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/utils"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+const maxHoldsPerPage = 50
+
+/*
+ * Goes through what the content moderator held back, approving a hold publishes the content as if it had never been held and rejecting it drops it
+ */
+type ModerationService struct {
+	moderationRepository data.CassandraModerationRepository
+	questionsService     QuestionsService
+	reportsRepository    data.CassandraReportsRepository
+}
+
+func (s *ModerationService) GetHolds(ctx context.Context) ([]models.HeldContent, error) {
+	return s.moderationRepository.GetHolds(ctx, maxHoldsPerPage)
+}
+
+/*
+ * The hold is released before anything is published, so that a hold approved twice at the same time is only published once
+ */
+func (s *ModerationService) ApproveHold(ctx context.Context, moderator string, holdIdInString string) (models.HeldContent, error) {
+	h, err := s.releaseHold(ctx, holdIdInString)
+	if err != nil {
+		return models.HeldContent{}, err
+	}
+
+	switch h.Kind {
+	case models.ContentQuestion:
+		var q models.Question
+		err = json.Unmarshal(h.Payload, &q)
+		if err == nil {
+			_, err = s.questionsService.deliverQuestion(ctx, q)
+		}
+	case models.ContentAnswer:
+		var qAndA models.QAndA
+		err = json.Unmarshal(h.Payload, &qAndA)
+		if err == nil {
+			_, err = s.questionsService.publishAnswer(ctx, qAndA.QuestionId, qAndA)
+		}
+	case models.ContentAnswerUpdate:
+		var qAndA models.QAndA
+		err = json.Unmarshal(h.Payload, &qAndA)
+		if err == nil {
+			_, err = s.questionsService.applyAnswerUpdate(ctx, qAndA)
+		}
+	case models.ContentProfile:
+		// The account was created or updated when the profile was held, approving only takes it off the list
+	}
+	if err != nil {
+		// The hold is put back so that the approval can be tried again
+		restoreErr := s.moderationRepository.SaveHold(ctx, h)
+		if restoreErr != nil {
+			log.Printf("failed to restore the hold %s after its approval failed %s\n", h.HoldId, restoreErr)
+		}
+		return models.HeldContent{}, fmt.Errorf("failed to publish the held %s %s", h.Kind, err)
+	}
+	log.Printf("moderator %s approved the held %s %s of %s\n", moderator, h.Kind, h.HoldId, h.Username)
+	return h, s.auditHold(ctx, moderator, models.ModerationActionApproveHold, h)
+}
+
+func (s *ModerationService) RejectHold(ctx context.Context, moderator string, holdIdInString string) (models.HeldContent, error) {
+	h, err := s.releaseHold(ctx, holdIdInString)
+	if err != nil {
+		return models.HeldContent{}, err
+	}
+	log.Printf("moderator %s rejected the held %s %s of %s\n", moderator, h.Kind, h.HoldId, h.Username)
+	return h, s.auditHold(ctx, moderator, models.ModerationActionRejectHold, h)
+}
+
+func (s *ModerationService) auditHold(ctx context.Context, moderator string, action string, h models.HeldContent) error {
+	return recordAudit(ctx, s.reportsRepository, models.AuditEntry{
+		Moderator:   moderator,
+		Action:      action,
+		TargetType:  h.Kind,
+		TargetId:    h.HoldId,
+		TargetOwner: h.Username,
+	})
+}
+
+func (s *ModerationService) releaseHold(ctx context.Context, holdIdInString string) (models.HeldContent, error) {
+	holdId, err := uuid.Parse(holdIdInString)
+	if err != nil {
+		return models.HeldContent{}, data.ErrHoldNotFound
+	}
+	h, err := s.moderationRepository.GetHold(ctx, holdId)
+	if err != nil {
+		return models.HeldContent{}, err
+	}
+	err = s.moderationRepository.ReleaseHold(ctx, holdId)
+	if err != nil {
+		return models.HeldContent{}, err
+	}
+	return h, nil
+}
+
+func holdForReview(ctx context.Context, repository data.CassandraModerationRepository, kind string, username string, payload any, verdict models.ModerationVerdict) error {
+	payloadInBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to hold the %s for review %s", kind, err)
+	}
+	holdId, err := uuid.NewUUID()
+	if err != nil {
+		return fmt.Errorf("failed to hold the %s for review %s", kind, err)
+	}
+	return repository.SaveHold(ctx, models.HeldContent{
+		HoldId:   holdId,
+		Kind:     kind,
+		Username: username,
+		Payload:  payloadInBytes,
+		Reasons:  verdict.Reasons,
+	})
+}
+
+/*
+ * The username and the names are moderated one by one so that a rejection points at the field that caused it, the profile is held when any of them
+ * is held
+ */
+func moderateProfile(ctx context.Context, username string, firstName string, lastName string) (models.ModerationVerdict, error) {
+	profileVerdict := models.ModerationVerdict{Outcome: models.ModerationAllow}
+	errs := utils.ValidationErrors{}
+	fields := []struct{ name, text string }{{"username", username}, {"firstName", firstName}, {"lastName", lastName}}
+	for _, field := range fields {
+		if field.text == "" {
+			continue
+		}
+		verdict, err := moderate(ctx, models.ContentProfile, field.name, field.text)
+		var rejection utils.ValidationErrors
+		if errors.As(err, &rejection) {
+			errs = append(errs, rejection...)
+			continue
+		}
+		if err != nil {
+			return models.ModerationVerdict{}, err
+		}
+		profileVerdict.Outcome = moreSevere(profileVerdict.Outcome, verdict.Outcome)
+		profileVerdict.Reasons = append(profileVerdict.Reasons, verdict.Reasons...)
+	}
+	if len(errs) > 0 {
+		return models.ModerationVerdict{Outcome: models.ModerationReject}, errs
+	}
+	return profileVerdict, nil
+}
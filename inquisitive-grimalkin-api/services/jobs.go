@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	maxJobAttempts     = 5
+	firstRetryDelay    = 30 * time.Second
+	jobQueueWorkers    = 4
+	jobQueueBufferSize = 256
+	jobTimeout         = 30 * time.Second
+)
+
+/*
+ * An error wrapped in permanentError tells the queue that retrying the job is pointless, a revoked token or a post rejected by the provider will not
+ * succeed the next time either
+ */
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
+
+/*
+ * A job is run until it succeeds, fails permanently or runs out of attempts. Done is called once with the outcome, attempts counts every run
+ * including the last one.
+ */
+type Job struct {
+	Name string
+	Run  func(ctx context.Context, attempt int) error
+	Done func(attempts int, err error)
+}
+
+type queuedJob struct {
+	Job
+	attempt int
+}
+
+/*
+ * The queue only lives in memory, the jobs that are still pending when the process stops are left as pending in their table. Retries wait
+ * 30s, 1m, 2m and 4m, a timer puts the job back in the queue so that no worker is blocked while waiting.
+ */
+type JobQueue struct {
+	jobs chan queuedJob
+}
+
+func NewJobQueue(workers int) *JobQueue {
+	q := &JobQueue{jobs: make(chan queuedJob, jobQueueBufferSize)}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue never blocks the request that adds the job, a full queue hands it over from another goroutine
+func (q *JobQueue) Enqueue(job Job) {
+	q.push(queuedJob{Job: job, attempt: 1})
+}
+
+func (q *JobQueue) push(job queuedJob) {
+	select {
+	case q.jobs <- job:
+	default:
+		go func() { q.jobs <- job }()
+	}
+}
+
+func (q *JobQueue) work() {
+	for job := range q.jobs {
+		q.run(job)
+	}
+}
+
+func (q *JobQueue) run(job queuedJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	err := job.Run(ctx, job.attempt)
+	cancel()
+
+	if err == nil || IsPermanent(err) || job.attempt >= maxJobAttempts {
+		if err != nil {
+			log.Printf("job %s failed after %d attempts %s\n", job.Name, job.attempt, err)
+		}
+		if job.Done != nil {
+			job.Done(job.attempt, err)
+		}
+		return
+	}
+
+	delay := firstRetryDelay << (job.attempt - 1)
+	log.Printf("job %s failed on attempt %d, retrying in %s %s\n", job.Name, job.attempt, delay, err)
+	job.attempt++
+	time.AfterFunc(delay, func() { q.push(job) })
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+type LikesService struct {
+	likesRepository         data.CassandraLikesRepository
+	questionsRepository     data.CassandraQuestionsRepository
+	notificationsRepository data.CassandraNotificationsRepository
+}
+
+/*
+ * The like is counted first, the user who answered the q&a is then looked up from its id and notified, a like of a q&a that is gone is counted without
+ * notifying anyone
+ */
+func (s *LikesService) LikeQAndA(ctx context.Context, liker string, qAndAUuid uuid.UUID) error {
+	err := s.likesRepository.LikeQAndA(ctx, qAndAUuid)
+	if err != nil {
+		return err
+	}
+
+	asked, err := s.questionsRepository.GetQAndAOwner(ctx, qAndAUuid)
+	if errors.Is(err, data.ErrQuestionNotFound) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("failed to find who answered the q&a %s to notify them of a like %s\n", qAndAUuid, err)
+		return nil
+	}
+	if asked == liker {
+		return nil
+	}
+	notify(ctx, s.notificationsRepository, models.Notification{
+		Username:   asked,
+		Kind:       models.NotificationLike,
+		Actor:      liker,
+		QuestionId: qAndAUuid,
+	})
+	publish(asked, models.EventLike, models.LikeEvent{QuestionId: qAndAUuid, Liker: liker})
+	return nil
+}
+
+/*
+ * Every unlike goes through here, whether it comes from the REST routes or from rpc, so both APIs count likes the same way
+ */
+func (s *LikesService) UnlikeQAndA(ctx context.Context, qAndAUuid uuid.UUID) error {
+	return s.likesRepository.UnlikeQAndA(ctx, qAndAUuid)
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+/*
+ * Body is the plain text of the mail, a mail with an HTMLBody is sent with both so that clients that do not show HTML still have something to show
+ */
+type Mail struct {
+	To       string
+	Subject  string
+	Body     string
+	HTMLBody string
+}
+
+type Mailer interface {
+	Send(context.Context, Mail) error
+}
+
+var mailer Mailer
+
+/*
+ * MAIL_TRANSPORT picks how the mails leave the server, smtp sends them through SMTP_HOST and anything else writes them to MAIL_DIRECTORY, which is meant
+ * for local development where nothing should ever reach a real inbox
+ */
+func init() {
+	godotenv.Load()
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@inquisitive-grimalkin.local"
+	}
+
+	switch os.Getenv("MAIL_TRANSPORT") {
+	case "smtp":
+		mailer = &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	default:
+		log.Printf("MAIL_TRANSPORT is not smtp, the mails are written to MAIL_DIRECTORY and the log instead of being sent\n")
+		mailer = &FileMailer{Directory: os.Getenv("MAIL_DIRECTORY"), From: from}
+	}
+}
+
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+/*
+ * net/smtp does not take a context, so the context is only checked before the mail is handed over to the server
+ */
+func (m *SMTPMailer) Send(ctx context.Context, mail Mail) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	err = smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{mail.To}, formatMail(m.From, mail))
+	if err != nil {
+		return fmt.Errorf("failed to send the mail to %s %s", mail.To, err)
+	}
+	return nil
+}
+
+type FileMailer struct {
+	// When empty the mails are only logged
+	Directory string
+	From      string
+}
+
+var unsafeFileNameCharacters = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+func (m *FileMailer) Send(_ context.Context, mail Mail) error {
+	log.Printf("mail to %s: %s\n%s\n", mail.To, mail.Subject, mail.Body)
+	if m.Directory == "" {
+		return nil
+	}
+
+	err := os.MkdirAll(m.Directory, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create the mail directory %s", err)
+	}
+	fileName := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), unsafeFileNameCharacters.ReplaceAllString(mail.To, "_"))
+	err = os.WriteFile(filepath.Join(m.Directory, fileName), formatMail(m.From, mail), 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write the mail to %s %s", mail.To, err)
+	}
+	return nil
+}
+
+func formatMail(from string, mail Mail) []byte {
+	headers := []string{
+		"From: " + from,
+		"To: " + mail.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", mail.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+	}
+	if mail.HTMLBody == "" {
+		headers = append(headers, "Content-Type: text/plain; charset=utf-8", "Content-Transfer-Encoding: 8bit")
+		return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + crlf(mail.Body) + "\r\n")
+	}
+
+	// The last part is the one the clients prefer, so the HTML goes after the text
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", mail.Body},
+		{"text/html; charset=utf-8", mail.HTMLBody},
+	} {
+		w, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		w.Write([]byte(crlf(part.content) + "\r\n"))
+	}
+	parts.Close()
+	headers = append(headers, "Content-Type: multipart/alternative; boundary="+parts.Boundary())
+	return append([]byte(strings.Join(headers, "\r\n")+"\r\n\r\n"), body.Bytes()...)
+}
+
+func crlf(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/utils"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	defaultHoldThreshold   = 0.7
+	defaultRejectThreshold = 0.9
+	moderationTimeout      = 5 * time.Second
+)
+
+var ErrHeldForReview = errors.New("the content was held for review by a moderator")
+
+/*
+ * A ContentModerator decides whether a piece of text can be published as is, has to be looked at by a moderator first or cannot be published at all.
+ * Kind is one of the models.Content* kinds, a moderator can be stricter with some kinds than others.
+ */
+type ContentModerator interface {
+	Moderate(ctx context.Context, kind string, text string) (models.ModerationVerdict, error)
+}
+
+var contentModerator ContentModerator
+
+/*
+ * The wordlist always runs, it needs nothing but MODERATION_WORDLIST, which falls back to a tiny built in list when it is not set. Setting
+ * PERSPECTIVE_API_KEY adds the scoring API on top of it.
+ */
+func init() {
+	godotenv.Load()
+	wordlist, err := NewWordlistModeratorFromFile(os.Getenv("MODERATION_WORDLIST"))
+	if err != nil {
+		log.Printf("failed to load MODERATION_WORDLIST, using the built in wordlist %s\n", err)
+		wordlist, _ = NewWordlistModerator(strings.NewReader(defaultWordlist))
+	}
+	moderators := ChainModerator{wordlist}
+
+	apiKey := os.Getenv("PERSPECTIVE_API_KEY")
+	if apiKey != "" {
+		perspective := NewPerspectiveModerator(os.Getenv("PERSPECTIVE_URL"), apiKey)
+		perspective.HoldThreshold = thresholdFromEnv("MODERATION_HOLD_THRESHOLD", defaultHoldThreshold)
+		perspective.RejectThreshold = thresholdFromEnv("MODERATION_REJECT_THRESHOLD", defaultRejectThreshold)
+		moderators = append(moderators, perspective)
+	}
+	contentModerator = moderators
+}
+
+func thresholdFromEnv(name string, fallback float64) float64 {
+	threshold, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil || threshold <= 0 || threshold > 1 {
+		return fallback
+	}
+	return threshold
+}
+
+/*
+ * A rejection comes back as ValidationErrors on the given field so that the client shows it like any other invalid input, a held text comes back
+ * with its verdict and no error, it is up to the caller to keep it away from everyone until a moderator approves it.
+ */
+func moderate(ctx context.Context, kind string, field string, text string) (models.ModerationVerdict, error) {
+	verdict, err := contentModerator.Moderate(ctx, kind, text)
+	if err != nil {
+		return models.ModerationVerdict{}, err
+	}
+	if verdict.Outcome == models.ModerationReject {
+		return verdict, utils.ValidationErrors{
+			{Field: field, Code: "rejected", Message: fmt.Sprintf("the %s cannot be published: %s", field, strings.Join(verdict.Reasons, ", "))},
+		}
+	}
+	return verdict, nil
+}
+
+func moreSevere(a string, b string) string {
+	severity := map[string]int{models.ModerationAllow: 0, models.ModerationHold: 1, models.ModerationReject: 2}
+	if severity[b] > severity[a] {
+		return b
+	}
+	return a
+}
+
+/*
+ * Every moderator of the chain is asked until one rejects, the most severe outcome wins and the reasons of all of them are kept. A moderator that
+ * fails is skipped, the others, and at least the wordlist, still had their say.
+ */
+type ChainModerator []ContentModerator
+
+func (c ChainModerator) Moderate(ctx context.Context, kind string, text string) (models.ModerationVerdict, error) {
+	verdict := models.ModerationVerdict{Outcome: models.ModerationAllow}
+	for _, moderator := range c {
+		v, err := moderator.Moderate(ctx, kind, text)
+		if err != nil {
+			log.Printf("a content moderator failed, carrying on without it %s\n", err)
+			continue
+		}
+		verdict.Outcome = moreSevere(verdict.Outcome, v.Outcome)
+		verdict.Reasons = append(verdict.Reasons, v.Reasons...)
+		if verdict.Outcome == models.ModerationReject {
+			break
+		}
+	}
+	return verdict, nil
+}
+
+// Kept short on purpose, the real list is meant to be maintained outside of the code in MODERATION_WORDLIST
+const defaultWordlist = `
+# outcome term, or outcome /regex/ to match the normalized text with a regular expression
+reject kill yourself
+reject kys
+hold /(?:https?://)?(?:bit\.ly|tinyurl\.com|t\.co)/\S+/
+`
+
+type wordlistRule struct {
+	outcome string
+	reason  string
+	pattern *regexp.Regexp
+}
+
+/*
+ * The text is matched twice, once lowercased and without accents and once more with the leetspeak undone, so that neither "Kÿs" nor "k.y.5" get
+ * through. A term matches whole words only, its letters can be repeated and separated by anything that is not a letter, "k y y s" matches "kys".
+ */
+type WordlistModerator struct {
+	rules []wordlistRule
+}
+
+var leetspeak = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b", "@", "a", "$", "s", "!", "i", "|", "l")
+
+func NewWordlistModeratorFromFile(path string) (*WordlistModerator, error) {
+	if path == "" {
+		return NewWordlistModerator(strings.NewReader(defaultWordlist))
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return NewWordlistModerator(file)
+}
+
+/*
+ * One rule per line, "reject term" or "hold term", a term between slashes is a regular expression. Empty lines and lines starting with # are skipped.
+ */
+func NewWordlistModerator(r io.Reader) (*WordlistModerator, error) {
+	moderator := &WordlistModerator{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		outcome, term, _ := strings.Cut(line, " ")
+		term = strings.TrimSpace(term)
+		if (outcome != models.ModerationHold && outcome != models.ModerationReject) || term == "" {
+			return nil, fmt.Errorf("line %d of the wordlist is not an outcome followed by a term", lineNumber)
+		}
+
+		var pattern *regexp.Regexp
+		var err error
+		if len(term) > 2 && strings.HasPrefix(term, "/") && strings.HasSuffix(term, "/") {
+			pattern, err = regexp.Compile(term[1 : len(term)-1])
+		} else {
+			pattern, err = termPattern(term)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d of the wordlist is not a valid pattern %s", lineNumber, err)
+		}
+		moderator.rules = append(moderator.rules, wordlistRule{outcome: outcome, reason: fmt.Sprintf("matched %q", term), pattern: pattern})
+	}
+	return moderator, scanner.Err()
+}
+
+func termPattern(term string) (*regexp.Regexp, error) {
+	letters := []string{}
+	for _, r := range utils.SearchKey(term) {
+		if r == ' ' {
+			continue
+		}
+		letters = append(letters, regexp.QuoteMeta(string(r))+"+")
+	}
+	if len(letters) == 0 {
+		return nil, fmt.Errorf("the term %q has no letters", term)
+	}
+	return regexp.Compile(`(?:^|[^\pL])` + strings.Join(letters, `[^\pL]*`) + `(?:$|[^\pL])`)
+}
+
+func (m *WordlistModerator) Moderate(_ context.Context, _ string, text string) (models.ModerationVerdict, error) {
+	normalized := utils.SearchKey(text)
+	withoutLeetspeak := leetspeak.Replace(normalized)
+
+	verdict := models.ModerationVerdict{Outcome: models.ModerationAllow}
+	for _, rule := range m.rules {
+		if rule.pattern.MatchString(normalized) || rule.pattern.MatchString(withoutLeetspeak) {
+			verdict.Outcome = moreSevere(verdict.Outcome, rule.outcome)
+			verdict.Reasons = append(verdict.Reasons, rule.reason)
+		}
+	}
+	return verdict, nil
+}
+
+/*
+ * Scores the text with a Perspective compatible API, the highest score of the requested attributes decides the outcome. The text is sent with
+ * doNotStore so that the API does not keep what our users wrote.
+ */
+type PerspectiveModerator struct {
+	Url             string
+	ApiKey          string
+	Attributes      []string
+	HoldThreshold   float64
+	RejectThreshold float64
+	Client          *http.Client
+}
+
+func NewPerspectiveModerator(url string, apiKey string) *PerspectiveModerator {
+	if url == "" {
+		url = "https://commentanalyzer.googleapis.com/v1alpha1/comments:analyze"
+	}
+	return &PerspectiveModerator{
+		Url:             url,
+		ApiKey:          apiKey,
+		Attributes:      []string{"TOXICITY", "SEVERE_TOXICITY", "IDENTITY_ATTACK", "THREAT"},
+		HoldThreshold:   defaultHoldThreshold,
+		RejectThreshold: defaultRejectThreshold,
+		Client:          &http.Client{Timeout: moderationTimeout},
+	}
+}
+
+func (m *PerspectiveModerator) Moderate(ctx context.Context, _ string, text string) (models.ModerationVerdict, error) {
+	requestedAttributes := map[string]struct{}{}
+	for _, attribute := range m.Attributes {
+		requestedAttributes[attribute] = struct{}{}
+	}
+	body, err := json.Marshal(map[string]any{
+		"comment":             map[string]string{"text": text},
+		"requestedAttributes": requestedAttributes,
+		"doNotStore":          true,
+	})
+	if err != nil {
+		return models.ModerationVerdict{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.Url+"?key="+m.ApiKey, bytes.NewReader(body))
+	if err != nil {
+		return models.ModerationVerdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := m.Client.Do(req)
+	if err != nil {
+		return models.ModerationVerdict{}, fmt.Errorf("failed to reach the scoring api %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return models.ModerationVerdict{}, fmt.Errorf("the scoring api answered with %d", res.StatusCode)
+	}
+
+	var scores struct {
+		AttributeScores map[string]struct {
+			SummaryScore struct {
+				Value float64 `json:"value"`
+			} `json:"summaryScore"`
+		} `json:"attributeScores"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&scores)
+	if err != nil {
+		return models.ModerationVerdict{}, fmt.Errorf("failed to parse the response of the scoring api %s", err)
+	}
+
+	verdict := models.ModerationVerdict{Outcome: models.ModerationAllow}
+	for attribute, score := range scores.AttributeScores {
+		outcome := models.ModerationAllow
+		switch {
+		case score.SummaryScore.Value >= m.RejectThreshold:
+			outcome = models.ModerationReject
+		case score.SummaryScore.Value >= m.HoldThreshold:
+			outcome = models.ModerationHold
+		}
+		if outcome != models.ModerationAllow {
+			verdict.Outcome = moreSevere(verdict.Outcome, outcome)
+			verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("%s scored %.2f", strings.ToLower(attribute), score.SummaryScore.Value))
+		}
+	}
+	return verdict, nil
+}
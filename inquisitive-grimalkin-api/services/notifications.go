@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultNotificationsPageSize = 20
+	MaxNotificationsPageSize     = 100
+)
+
+type NotificationService struct {
+	notificationsRepository data.CassandraNotificationsRepository
+}
+
+func (s *NotificationService) GetNotifications(ctx context.Context, username string, limit int) ([]models.Notification, error) {
+	if username == "" {
+		return nil, ErrUnauthenticated
+	}
+	if limit <= 0 {
+		limit = DefaultNotificationsPageSize
+	}
+	if limit > MaxNotificationsPageSize {
+		limit = MaxNotificationsPageSize
+	}
+	notifications, err := s.notificationsRepository.GetNotifications(ctx, username, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range notifications {
+		notifications[i].Message = notificationMessage(notifications[i])
+	}
+	return notifications, nil
+}
+
+func (s *NotificationService) GetUnreadCount(ctx context.Context, username string) (int64, error) {
+	if username == "" {
+		return 0, ErrUnauthenticated
+	}
+	return s.notificationsRepository.CountUnreadNotifications(ctx, username)
+}
+
+/*
+ * Marks the given notifications as read, or every notification that is still unread when none are given
+ */
+func (s *NotificationService) MarkRead(ctx context.Context, username string, notificationIds []uuid.UUID) error {
+	if username == "" {
+		return ErrUnauthenticated
+	}
+	if len(notificationIds) == 0 {
+		notifications, err := s.notificationsRepository.GetNotifications(ctx, username, MaxNotificationsPageSize)
+		if err != nil {
+			return err
+		}
+		for _, n := range notifications {
+			if !n.Read {
+				notificationIds = append(notificationIds, n.NotificationId)
+			}
+		}
+	}
+	return s.notificationsRepository.MarkNotificationsRead(ctx, username, notificationIds)
+}
+
+func notificationMessage(n models.Notification) string {
+	switch n.Kind {
+	case models.NotificationQuestion:
+		if n.Actor == "" {
+			return "Someone asked you an anonymous question"
+		}
+		return fmt.Sprintf("%s asked you a question", n.Actor)
+	case models.NotificationAnswer:
+		return fmt.Sprintf("%s answered your question", n.Actor)
+	case models.NotificationLike:
+		switch {
+		case n.Count <= 1:
+			return fmt.Sprintf("%s liked your answer", n.Actor)
+		case n.Count == 2:
+			return fmt.Sprintf("%s and 1 other liked your answer", n.Actor)
+		default:
+			return fmt.Sprintf("%s and %d others liked your answer", n.Actor, n.Count-1)
+		}
+	case models.NotificationFollow:
+		return fmt.Sprintf("%s started following you", n.Actor)
+	}
+	return ""
+}
+
+/*
+ * Notifying is never what the user asked for, so a notification that cannot be saved is only logged and never fails what caused it. Nobody is
+ * notified of what they did themselves.
+ *
+ * A like is gathered in the unread notification of the earlier likes of the same q&a, the notification is saved again under a new id so that it moves
+ * back to the top. The likers are counted once each, liking again after an unlike notifies nobody. Two likes gathered at the same time can count as
+ * one, which is fine for a notification.
+ *
+ * A saved notification is also pushed to the browsers of the user, see pushNotification.
+ */
+func notify(ctx context.Context, repository data.CassandraNotificationsRepository, n models.Notification) {
+	if n.Username == "" || n.Username == n.Actor {
+		return
+	}
+	notificationId, err := uuid.NewUUID()
+	if err != nil {
+		log.Printf("failed to notify %s of a %s %s\n", n.Username, n.Kind, err)
+		return
+	}
+	n.NotificationId = notificationId
+	n.Count = 1
+	n.Read = false
+	if n.Kind == models.NotificationLike {
+		n.Likers = []string{n.Actor}
+	}
+
+	var replacedId uuid.UUID
+	if n.Kind == models.NotificationLike {
+		previous, found, err := repository.GetLikeNotification(ctx, n.Username, n.QuestionId)
+		if err != nil {
+			log.Printf("failed to notify %s of a %s %s\n", n.Username, n.Kind, err)
+			return
+		}
+		if found && !previous.Read {
+			for _, liker := range previous.Likers {
+				if liker == n.Actor {
+					return
+				}
+			}
+			n.Likers = append(previous.Likers, n.Actor)
+			n.Count = len(n.Likers)
+			replacedId = previous.NotificationId
+		}
+	}
+
+	err = repository.SaveNotification(ctx, n, replacedId)
+	if err != nil {
+		log.Printf("failed to notify %s of a %s %s\n", n.Username, n.Kind, err)
+		return
+	}
+	n.Message = notificationMessage(n)
+	pushNotification(ctx, n)
+}
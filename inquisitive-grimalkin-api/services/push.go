@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/utils"
+	"log"
+	"time"
+)
+
+// The browser subscriptions of the authenticated user, see WebPushSender for the delivery
+type PushService struct {
+	pushRepository data.CassandraPushRepository
+}
+
+// The applicationServerKey the webapp has to subscribe with
+func (s *PushService) GetPublicKey() (string, error) {
+	if webPush == nil {
+		return "", ErrWebPushDisabled
+	}
+	return webPush.PublicKey(), nil
+}
+
+/*
+ * Subscribing the same endpoint again replaces its keys, the browser hands out new keys when it renews the subscription
+ */
+func (s *PushService) Subscribe(ctx context.Context, username string, sub models.PushSubscription) (models.PushSubscription, error) {
+	if username == "" {
+		return models.PushSubscription{}, ErrUnauthenticated
+	}
+	if webPush == nil {
+		return models.PushSubscription{}, ErrWebPushDisabled
+	}
+	err := utils.ValidatePushSubscription(sub)
+	if err != nil {
+		return models.PushSubscription{}, err
+	}
+	sub.Username = username
+	sub.CreatedOn = time.Now()
+	err = s.pushRepository.SavePushSubscription(ctx, sub)
+	if err != nil {
+		return models.PushSubscription{}, err
+	}
+	return sub, nil
+}
+
+func (s *PushService) Unsubscribe(ctx context.Context, username string, endpoint string) error {
+	if username == "" {
+		return ErrUnauthenticated
+	}
+	return s.pushRepository.DeletePushSubscription(ctx, username, endpoint)
+}
+
+/*
+ * Pushes a saved notification to the browsers of the user. A user with an event stream open already sees it in the app, so nothing is pushed then.
+ * The unread likes of a q&a share a topic, only the latest of them is shown by a browser that was offline while they came in.
+ */
+func pushNotification(ctx context.Context, n models.Notification) {
+	if webPush == nil || eventHub.IsConnected(n.Username) {
+		return
+	}
+	payload, err := json.Marshal(n)
+	if err != nil {
+		log.Printf("failed to push a %s to %s %s\n", n.Kind, n.Username, err)
+		return
+	}
+	var topic string
+	if n.Kind == models.NotificationLike {
+		topic = "like-" + base64.RawURLEncoding.EncodeToString(n.QuestionId[:])
+	}
+	err = webPush.Notify(ctx, n.Username, payload, topic)
+	if err != nil {
+		log.Printf("failed to push a %s to %s %s\n", n.Kind, n.Username, err)
+	}
+}
@@ -2,22 +2,248 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"inquisitive-grimalkin/data"
 	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/utils"
 	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+	"log"
+	"time"
 	// "sync"
 )
 
+const (
+	DefaultTimelinePageSize = 20
+	MaxTimelinePageSize     = 100
+)
+
+var (
+	// Returned when the context carries no user, the routers map it to 401
+	ErrUnauthenticated = errors.New("the request is not made by an authenticated user")
+
+	// Returned when the inbox settings of the asked user do not allow the question to be delivered, the routers map them to 403
+	ErrInboxPaused                   = errors.New("the user is not accepting any questions at the moment")
+	ErrAnonymousQuestionsNotAccepted = errors.New("the user does not accept anonymous questions")
+	ErrAskerNotFollowed              = errors.New("the user only accepts questions from people they follow")
+)
+
 type QuestionsService struct {
 	questionsRepository data.CassandraQuestionsRepository
 	usersRepository data.CassandraUsersRepository
 	likesRepository data.CassandraLikesRepository
+	settingsRepository data.CassandraSettingsRepository
+	moderationRepository data.CassandraModerationRepository
+	reportsRepository data.CassandraReportsRepository
+	spamRepository data.CassandraSpamRepository
+	spamDetector SpamDetector
+	notificationsRepository data.CassandraNotificationsRepository
 }
 
+/*
+ * The asker is always the authenticated user, whatever was sent in the body is overwritten, otherwise anyone could ask questions in the name of others
+ */
 func (s *QuestionsService) Ask(context context.Context, q models.Question) (models.Question, error) {
-	q, err := s.questionsRepository.Ask(context, q)	
-	return q, err
+	asker, ok := utils.UserFromContext(context)
+	if !ok {
+		return models.Question{}, ErrUnauthenticated
+	}
+	q.Asker = asker
+
+	q, err := utils.ValidateQuestion(q)
+	if err != nil {
+		return models.Question{}, err
+	}
+
+	askedExists, err := s.usersRepository.DoesUserExist(context, models.User{Username: q.Asked})
+	if err != nil {
+		return models.Question{}, fmt.Errorf("failed to ask the question, unable to look up %s %s", q.Asked, err)
+	}
+	if !askedExists {
+		return models.Question{}, utils.ValidationErrors{
+			{Field: "asked", Code: "not_found", Message: fmt.Sprintf("the user %s does not exist", q.Asked)},
+		}
+	}
+
+	err = s.checkInboxSettings(context, q)
+	if err != nil {
+		return models.Question{}, err
+	}
+
+	// Only questions that could be delivered are moderated, a held question would otherwise slip past the inbox settings once approved
+	verdict, err := moderate(context, models.ContentQuestion, "question", q.Question)
+	if err != nil {
+		return models.Question{}, err
+	}
+	if verdict.Outcome == models.ModerationHold {
+		err = holdForReview(context, s.moderationRepository, models.ContentQuestion, q.Asker, q, verdict)
+		if err != nil {
+			return models.Question{}, err
+		}
+		return q, ErrHeldForReview
+	}
+
+	// A detector that cannot be reached lets the question through, the recipient can still report it
+	reasons, err := s.spamDetector.Inspect(context, q, time.Now())
+	if err != nil {
+		log.Printf("failed to check the question of %s for spam, delivering it %s\n", q.Asker, err)
+	}
+	if len(reasons) > 0 {
+		return quarantine(context, s.spamRepository, q, reasons)
+	}
+
+	return s.deliverQuestion(context, q)
+}
+
+/*
+ * Puts the question in the inbox of the recipient and tells them about it, every question that reaches an inbox goes through here whether it was
+ * delivered straight away, released from the quarantine or approved by a moderator
+ */
+func (s *QuestionsService) deliverQuestion(context context.Context, q models.Question) (models.Question, error) {
+	q, err := s.questionsRepository.Ask(context, q)
+	if err != nil {
+		return models.Question{}, err
+	}
+	notify(context, s.notificationsRepository, models.Notification{
+		Username:   q.Asked,
+		Kind:       models.NotificationQuestion,
+		Actor:      visibleActor(q.IsAnon, q.Asker),
+		QuestionId: q.QuestionId,
+	})
+	publish(q.Asked, models.EventQuestion, q)
+	return q, nil
+}
+
+// The asker of an anonymous question is not even told to the user it was sent to
+func visibleActor(isAnon bool, asker string) string {
+	if isAnon {
+		return ""
+	}
+	return asker
+}
+
+/*
+ * The inbox of the authenticated user, questions asked by suspended or banned users are left out until the asker is reinstated. The askers of the
+ * anonymous questions are looked up for that check only, they are never added to the questions.
+ */
+func (s *QuestionsService) GetUnansweredQuestions(context context.Context) ([]models.Question, error) {
+	asked, ok := utils.UserFromContext(context)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	questions, err := s.questionsRepository.GetUnansweredQuestionsForUser(context, asked)
+	if err != nil {
+		return nil, err
+	}
+
+	anonymousQuestionIds := []uuid.UUID{}
+	for _, q := range questions {
+		if q.IsAnon {
+			anonymousQuestionIds = append(anonymousQuestionIds, q.QuestionId)
+		}
+	}
+	anonymousAskers, err := s.questionsRepository.RevealAnonymousAskers(context, anonymousQuestionIds)
+	if err != nil {
+		return nil, err
+	}
+
+	askers := map[string]struct{}{}
+	askerOf := func(q models.Question) string {
+		if q.IsAnon {
+			return anonymousAskers[q.QuestionId]
+		}
+		return q.Asker
+	}
+	for _, q := range questions {
+		askers[askerOf(q)] = struct{}{}
+	}
+	usernames := make([]string, 0, len(askers))
+	for asker := range askers {
+		if asker != "" {
+			usernames = append(usernames, asker)
+		}
+	}
+	statuses, err := s.usersRepository.GetAccountStatuses(context, usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	visibleQuestions := []models.Question{}
+	for _, q := range questions {
+		if statuses[askerOf(q)].IsSuspended(now) {
+			continue
+		}
+		visibleQuestions = append(visibleQuestions, q)
+	}
+	return visibleQuestions, nil
+}
+
+
+/*
+ * The timeline of the authenticated user, the likes of the q&as are independent of each other so they are all fetched in parallel. The asker of an
+ * anonymous question never reaches the timelines in the first place.
+ */
+func (s *QuestionsService) GetTimeline(context context.Context, limit int) ([]models.TimelineQAndA, error) {
+	follower, ok := utils.UserFromContext(context)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if limit <= 0 {
+		limit = DefaultTimelinePageSize
+	}
+	if limit > MaxTimelinePageSize {
+		limit = MaxTimelinePageSize
+	}
+	qAndAs, err := s.questionsRepository.GetHomefeed(context, follower, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	timeline := make([]models.TimelineQAndA, len(qAndAs))
+	likesGroup, likesCtx := errgroup.WithContext(context)
+	for i, qAndA := range qAndAs {
+		i, qAndA := i, qAndA
+		timeline[i].QAndA = qAndA
+		likesGroup.Go(func() error {
+			likes, err := s.likesRepository.GetLikesForQAndA(likesCtx, qAndA.QuestionId)
+			timeline[i].Likes = likes
+			return err
+		})
+	}
+	err = likesGroup.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return timeline, nil
+}
+
+/*
+ * The checks are ordered from the cheapest to the most expensive, the follow check needs an extra round trip so it is only done when the asked user
+ * restricted their inbox to the people they follow
+ */
+func (s *QuestionsService) checkInboxSettings(context context.Context, q models.Question) error {
+	settings, err := s.settingsRepository.GetSettings(context, q.Asked)
+	if err != nil {
+		return fmt.Errorf("failed to ask the question, unable to fetch the settings of %s %s", q.Asked, err)
+	}
+	if settings.InboxPaused {
+		return ErrInboxPaused
+	}
+	if q.IsAnon && !settings.AcceptAnonymousQuestions {
+		return ErrAnonymousQuestionsNotAccepted
+	}
+	if settings.AcceptQuestionsOnlyFromFollowed {
+		isFollowed, err := s.usersRepository.IsFollowing(context, q.Asked, q.Asker)
+		if err != nil {
+			return fmt.Errorf("failed to ask the question, unable to check if %s follows %s %s", q.Asked, q.Asker, err)
+		}
+		if !isFollowed {
+			return ErrAskerNotFollowed
+		}
+	}
+	return nil
 }
 
  /*
@@ -32,16 +258,46 @@ func (s *QuestionsService) Ask(context context.Context, q models.Question) (mode
   - 4) Since the counter tables do not allow insertion, we will just update the q&a of that like to be added and set to zero.
 */
 func (s *QuestionsService) AnswerQuestion(context context.Context,questionUuidInString string, qAndA models.QAndA) (models.QAndA, error) {
+	// Like the asker of a question, the one answering is always the authenticated user and only their own inbox can be answered from
+	asked, ok := utils.UserFromContext(context)
+	if !ok {
+		return models.QAndA{}, ErrUnauthenticated
+	}
+	qAndA.Asked = asked
+
+	parsedQuestionUuid, err := uuid.Parse(questionUuidInString)
+	if err != nil {
+		return models.QAndA{}, fmt.Errorf("failed to post answer to question with id %s %s", parsedQuestionUuid, err)
+	}
+	_, err = s.questionsRepository.GetUnansweredQuestion(context, asked, parsedQuestionUuid)
+	if err != nil {
+		return models.QAndA{}, err
+	}
+
+	verdict, err := moderate(context, models.ContentAnswer, "answer", qAndA.Answer)
+	if err != nil {
+		return models.QAndA{}, err
+	}
+	if verdict.Outcome == models.ModerationHold {
+		// The question stays in the inbox until the answer is approved
+		qAndA.QuestionId = parsedQuestionUuid
+		err = holdForReview(context, s.moderationRepository, models.ContentAnswer, qAndA.Asked, qAndA, verdict)
+		if err != nil {
+			return models.QAndA{}, err
+		}
+		return qAndA, ErrHeldForReview
+	}
+
+	return s.publishAnswer(context, parsedQuestionUuid, qAndA)
+}
+
+func (s *QuestionsService) publishAnswer(context context.Context, parsedQuestionUuid uuid.UUID, qAndA models.QAndA) (models.QAndA, error) {
 
 	// Updating the question with the answer and posting the answer to the followers timeline can be done in parallel
 	// wg := sync.WaitGroup{}
 	// wg.Add(2)
 
 	// Step 1 Answer the question and delete the question from the table and insert it to the q&a table	
-	parsedQuestionUuid, err := uuid.Parse(questionUuidInString)
-	if err != nil {
-		return models.QAndA{}, fmt.Errorf("failed to post answer to question with id %s %s", parsedQuestionUuid, err)
-	}
 	answeredQuestion, err := s.questionsRepository.AnswerQuestion(context, parsedQuestionUuid, qAndA)
 	if err != nil {
 		return models.QAndA{}, err
@@ -53,7 +309,7 @@ func (s *QuestionsService) AnswerQuestion(context context.Context,questionUuidIn
 	}
 
 	// Step 3 Post the answer to the followers fields
-	err = s.questionsRepository.PostAnswerToFollowersHomefeed(context, answeredQuestion, followers)
+	err = s.questionsRepository.PostAnswerToFollowersHomefeed(context, answeredQuestion, followers...)
 	if err != nil {
 		return models.QAndA{}, err
 	}
@@ -64,27 +320,158 @@ func (s *QuestionsService) AnswerQuestion(context context.Context,questionUuidIn
 		return models.QAndA{}, err
 	}
 
+	/*
+	 * Step 5 Let the asker know, the asker of an anonymous question is notified as well, they are the only one who sees it. The answered question
+	 * comes back without the asker of an anonymous question, it is read from where the repository keeps it.
+	 */
+	asker := answeredQuestion.Asker
+	if answeredQuestion.IsAnon {
+		anonymousAsker, err := s.questionsRepository.RevealAnonymousAsker(context, answeredQuestion.QuestionId)
+		if err != nil {
+			log.Printf("failed to find the asker of the anonymous question %s to notify them %s\n", answeredQuestion.QuestionId, err)
+		}
+		asker = anonymousAsker.Asker
+	}
+	notify(context, s.notificationsRepository, models.Notification{
+		Username:   asker,
+		Kind:       models.NotificationAnswer,
+		Actor:      answeredQuestion.Asked,
+		QuestionId: answeredQuestion.QuestionId,
+	})
+	for _, follower := range followers {
+		publish(follower.Username, models.EventAnswer, answeredQuestion)
+	}
+
 	return qAndA, nil
 }
 
+/*
+ * Only the answer can be changed, the rest of the q&a is read back from what was saved. The copies in the homefeeds of the followers are updated along
+ * with it.
+ */
+func (s *QuestionsService) UpdateAnswer(context context.Context, qAndAUuidInString string, qAndA models.QAndA) (models.QAndA, error) {
+	asked, ok := utils.UserFromContext(context)
+	if !ok {
+		return models.QAndA{}, ErrUnauthenticated
+	}
+	parsedQAndAUuid, err := uuid.Parse(qAndAUuidInString)
+	if err != nil {
+		return models.QAndA{}, data.ErrQuestionNotFound
+	}
+	updatedQAndA, err := s.questionsRepository.GetQAndA(context, asked, parsedQAndAUuid)
+	if err != nil {
+		return models.QAndA{}, err
+	}
+	updatedQAndA.Answer = qAndA.Answer
 
-func (q *QuestionsService) UpdateAnswer(context context.Context, qAndA models.QAndA) (models.QAndA, error) {
-
-
-	// TODO: Step 3 post all the answer to the asked's followers
-	// followers := q.usersRepository.FindFollowersForUsers(context, qAndA)
-	// q.questionsRepository.UpdateFollowersTimeline(context, qAndA, followers)
+	verdict, err := moderate(context, models.ContentAnswer, "answer", updatedQAndA.Answer)
+	if err != nil {
+		return models.QAndA{}, err
+	}
+	if verdict.Outcome == models.ModerationHold {
+		// The previous answer stays up until the new one is approved
+		err = holdForReview(context, s.moderationRepository, models.ContentAnswerUpdate, asked, updatedQAndA, verdict)
+		if err != nil {
+			return models.QAndA{}, err
+		}
+		return updatedQAndA, ErrHeldForReview
+	}
 
+	return s.applyAnswerUpdate(context, updatedQAndA)
+}
 
-	// TODO : Step 4 Add the Q&A to the likes counter table
-	updatedAnswer,err := q.questionsRepository.UpdateAnswer(context, qAndA)
+func (s *QuestionsService) applyAnswerUpdate(context context.Context, qAndA models.QAndA) (models.QAndA, error) {
+	updatedAnswer, err := s.questionsRepository.UpdateAnswer(context, qAndA.QuestionId, qAndA)
 	if err != nil {
-		return updatedAnswer, err
+		return models.QAndA{}, err
 	}
+	followers, err := s.usersRepository.FindFollowersOfUser(context, updatedAnswer.Asked)
+	if err != nil {
+		return models.QAndA{}, err
+	}
+	err = s.questionsRepository.UpdateAnswerToFollowersHomefeed(context, updatedAnswer, followers...)
+	if err != nil {
+		return models.QAndA{}, err
+	}
+	return updatedAnswer, nil
+}
 
+func (s *QuestionsService) GetQuarantinedQuestions(context context.Context) ([]models.QuarantinedQuestion, error) {
+	asked, ok := utils.UserFromContext(context)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	return s.spamRepository.GetQuarantinedQuestions(context, asked)
+}
 
+/*
+ * Moves the question into the inbox of the recipient, it is delivered as a new question. When the delivery fails the question goes back to the
+ * quarantine so that it can be released again.
+ */
+func (s *QuestionsService) ReleaseQuarantinedQuestion(context context.Context, questionUuidInString string) (models.Question, error) {
+	asked, ok := utils.UserFromContext(context)
+	if !ok {
+		return models.Question{}, ErrUnauthenticated
+	}
+	parsedQuestionUuid, err := uuid.Parse(questionUuidInString)
+	if err != nil {
+		return models.Question{}, data.ErrQuarantinedQuestionNotFound
+	}
+	quarantined, err := s.spamRepository.GetQuarantinedQuestion(context, asked, parsedQuestionUuid)
+	if err != nil {
+		return models.Question{}, err
+	}
+	err = s.spamRepository.DeleteQuarantinedQuestion(context, asked, parsedQuestionUuid)
+	if err != nil {
+		return models.Question{}, err
+	}
 
-	return qAndA, nil
+	q, err := s.deliverQuestion(context, quarantined.Question)
+	if err != nil {
+		restoreErr := s.spamRepository.Quarantine(context, quarantined)
+		if restoreErr != nil {
+			log.Printf("failed to put the question %s back in quarantine %s\n", parsedQuestionUuid, restoreErr)
+		}
+		return models.Question{}, err
+	}
+	return q, nil
 }
 
+func (s *QuestionsService) DiscardQuarantinedQuestion(context context.Context, questionUuidInString string) error {
+	asked, ok := utils.UserFromContext(context)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	parsedQuestionUuid, err := uuid.Parse(questionUuidInString)
+	if err != nil {
+		return data.ErrQuarantinedQuestionNotFound
+	}
+	return s.spamRepository.DeleteQuarantinedQuestion(context, asked, parsedQuestionUuid)
+}
 
+/*
+ * Revealing who is behind an anonymous question is only meant for moderators investigating abuse, every reveal is recorded in the moderation audit log
+ * with the moderator that asked for it
+ */
+func (s *QuestionsService) RevealAnonymousAsker(context context.Context, moderator string, questionUuidInString string) (models.AnonymousAsker, error) {
+	parsedQuestionUuid, err := uuid.Parse(questionUuidInString)
+	if err != nil {
+		return models.AnonymousAsker{}, fmt.Errorf("failed to reveal the asker of question with id %s %s", questionUuidInString, err)
+	}
+	anonymousAsker, err := s.questionsRepository.RevealAnonymousAsker(context, parsedQuestionUuid)
+	if err != nil {
+		return models.AnonymousAsker{}, err
+	}
+	// Nothing is revealed unless the reveal made it to the audit log
+	err = recordAudit(context, s.reportsRepository, models.AuditEntry{
+		Moderator:  moderator,
+		Action:     models.ModerationActionRevealAsker,
+		TargetType: models.ReportTargetQuestion,
+		TargetId:   parsedQuestionUuid,
+	})
+	if err != nil {
+		return models.AnonymousAsker{}, err
+	}
+	log.Printf("moderator %s revealed the asker of the anonymous question %s\n", moderator, parsedQuestionUuid)
+	return anonymousAsker, nil
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/utils"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const maxReportsPerPage = 50
+
+var ErrInvalidReportStatus = errors.New("reports can only be listed as open, claimed or resolved")
+
+type ReportsService struct {
+	reportsRepository   data.CassandraReportsRepository
+	questionsRepository data.CassandraQuestionsRepository
+	usersRepository     data.CassandraUsersRepository
+	likesRepository     data.CassandraLikesRepository
+}
+
+/*
+ * The reported content has to exist for the report to be taken. A pending question can only be seen by the user who received it, so it can only be
+ * reported by them.
+ */
+func (s *ReportsService) Report(ctx context.Context, reporter string, r models.Report) (models.Report, error) {
+	if reporter == "" {
+		return models.Report{}, ErrUnauthenticated
+	}
+	r.Reporter = reporter
+	if r.TargetType == models.ReportTargetQuestion {
+		r.TargetOwner = reporter
+	}
+	if r.TargetType == models.ReportTargetUser {
+		r.TargetId = uuid.UUID{}
+	}
+	err := utils.ValidateReport(r)
+	if err != nil {
+		return models.Report{}, err
+	}
+
+	switch r.TargetType {
+	case models.ReportTargetQAndA:
+		_, err = s.questionsRepository.GetQAndA(ctx, r.TargetOwner, r.TargetId)
+	case models.ReportTargetQuestion:
+		_, err = s.questionsRepository.GetUnansweredQuestion(ctx, r.TargetOwner, r.TargetId)
+	case models.ReportTargetUser:
+		var exists bool
+		exists, err = s.usersRepository.DoesUserExist(ctx, models.User{Username: r.TargetOwner})
+		if err == nil && !exists {
+			err = data.ErrUserNotFound
+		}
+	}
+	if err != nil {
+		return models.Report{}, err
+	}
+
+	r.ReportId, err = uuid.NewUUID()
+	if err != nil {
+		return models.Report{}, fmt.Errorf("failed to create the report %s", err)
+	}
+	r.Status = models.ReportOpen
+	r.CreatedOn = time.Now()
+	r.ClaimedBy, r.Resolution, r.Note = "", "", ""
+	r.ClaimedOn, r.ResolvedOn = time.Time{}, time.Time{}
+	err = s.reportsRepository.SaveReport(ctx, r)
+	if err != nil {
+		return models.Report{}, err
+	}
+	return r, nil
+}
+
+func (s *ReportsService) GetReports(ctx context.Context, status string) ([]models.Report, error) {
+	if status == "" {
+		status = models.ReportOpen
+	}
+	if status != models.ReportOpen && status != models.ReportClaimed && status != models.ReportResolved {
+		return nil, ErrInvalidReportStatus
+	}
+	return s.reportsRepository.GetReportsByStatus(ctx, status, maxReportsPerPage)
+}
+
+func (s *ReportsService) ClaimReport(ctx context.Context, moderator string, reportIdInString string) (models.Report, error) {
+	reportId, err := uuid.Parse(reportIdInString)
+	if err != nil {
+		return models.Report{}, data.ErrReportNotFound
+	}
+	return s.reportsRepository.ClaimReport(ctx, reportId, moderator)
+}
+
+/*
+ * The resolution is written on the report before its action is taken and the report is only resolved after it, so the action is never taken twice however
+ * often the request is retried. A retry after the action was taken only finishes resolving the report with the resolution that was written, an action
+ * that failed takes the resolution back so that the report stays claimed and can be resolved again. Warnings and suspensions go to whoever is
+ * responsible for the content, the one who answered a q&a, the one who asked a question or the reported user.
+ */
+func (s *ReportsService) ResolveReport(ctx context.Context, moderator string, reportIdInString string, resolution models.ReportResolution) (models.Report, error) {
+	reportId, err := uuid.Parse(reportIdInString)
+	if err != nil {
+		return models.Report{}, data.ErrReportNotFound
+	}
+	r, err := s.reportsRepository.GetReport(ctx, reportId)
+	if err != nil {
+		return models.Report{}, err
+	}
+	if r.Status != models.ReportClaimed || r.ClaimedBy != moderator {
+		return models.Report{}, data.ErrReportNotClaimed
+	}
+
+	if r.Resolution != "" {
+		resolution = models.ReportResolution{Action: r.Resolution, Note: r.Note}
+	} else {
+		err = utils.ValidateReportResolution(resolution, r.TargetType)
+		if err != nil {
+			return models.Report{}, err
+		}
+		r.Resolution = resolution.Action
+		r.Note = resolution.Note
+		recorded, err := s.reportsRepository.RecordResolution(ctx, r)
+		if err != nil {
+			return models.Report{}, err
+		}
+		if !recorded {
+			return models.Report{}, data.ErrReportBeingResolved
+		}
+
+		err = s.takeAction(ctx, r, resolution)
+		if err != nil {
+			clearErr := s.reportsRepository.ClearResolution(ctx, r)
+			if clearErr != nil {
+				log.Printf("failed to take back the resolution of the report %s after its action failed %s\n", r.ReportId, clearErr)
+			}
+			return models.Report{}, err
+		}
+	}
+
+	r, err = s.reportsRepository.ResolveReport(ctx, r)
+	if err != nil {
+		return models.Report{}, err
+	}
+
+	err = recordAudit(ctx, s.reportsRepository, models.AuditEntry{
+		Moderator:   moderator,
+		Action:      resolution.Action,
+		TargetType:  r.TargetType,
+		TargetId:    r.TargetId,
+		TargetOwner: r.TargetOwner,
+		ReportId:    r.ReportId,
+		Note:        resolution.Note,
+	})
+	if err != nil {
+		return models.Report{}, err
+	}
+	return r, nil
+}
+
+func (s *ReportsService) takeAction(ctx context.Context, r models.Report, resolution models.ReportResolution) error {
+	switch resolution.Action {
+	case models.ModerationActionDeleteContent:
+		return s.deleteReportedContent(ctx, r)
+	case models.ModerationActionWarn:
+		return s.warn(ctx, r, resolution.Note)
+	case models.ModerationActionSuspend:
+		return s.suspend(ctx, r, resolution)
+	}
+	return nil
+}
+
+func (s *ReportsService) GetAuditLog(ctx context.Context, day time.Time) ([]models.AuditEntry, error) {
+	return s.reportsRepository.GetAuditLog(ctx, day)
+}
+
+// Content that is already gone, deleted by its owner in the meantime, counts as deleted
+func (s *ReportsService) deleteReportedContent(ctx context.Context, r models.Report) error {
+	switch r.TargetType {
+	case models.ReportTargetQAndA:
+		qAndA, err := s.questionsRepository.GetQAndA(ctx, r.TargetOwner, r.TargetId)
+		if errors.Is(err, data.ErrQuestionNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		followers, err := s.usersRepository.FindFollowersOfUser(ctx, r.TargetOwner)
+		if err != nil {
+			return err
+		}
+		err = s.questionsRepository.DeleteAnswerFromFollowersHomefeed(ctx, qAndA, followers...)
+		if err != nil {
+			return err
+		}
+		err = s.likesRepository.DeleteQAndA(ctx, qAndA.QuestionId)
+		if err != nil {
+			return err
+		}
+		return s.questionsRepository.DeleteQAndA(ctx, qAndA)
+	case models.ReportTargetQuestion:
+		q, err := s.questionsRepository.GetUnansweredQuestion(ctx, r.TargetOwner, r.TargetId)
+		if errors.Is(err, data.ErrQuestionNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// The asker of an anonymous question is needed to remove it from the questions they asked as well
+		if q.IsAnon {
+			anonymousAsker, err := s.questionsRepository.RevealAnonymousAsker(ctx, q.QuestionId)
+			if err != nil {
+				return err
+			}
+			q.Asker = anonymousAsker.Asker
+		}
+		return s.questionsRepository.DeleteUnansweredQuestion(ctx, q)
+	}
+	return nil
+}
+
+func (s *ReportsService) offender(ctx context.Context, r models.Report) (string, error) {
+	if r.TargetType != models.ReportTargetQuestion {
+		return r.TargetOwner, nil
+	}
+	q, err := s.questionsRepository.GetUnansweredQuestion(ctx, r.TargetOwner, r.TargetId)
+	if err != nil {
+		return "", err
+	}
+	if !q.IsAnon {
+		return q.Asker, nil
+	}
+	anonymousAsker, err := s.questionsRepository.RevealAnonymousAsker(ctx, q.QuestionId)
+	if err != nil {
+		return "", err
+	}
+	return anonymousAsker.Asker, nil
+}
+
+func (s *ReportsService) warn(ctx context.Context, r models.Report, note string) error {
+	offender, err := s.offender(ctx, r)
+	if err != nil {
+		return err
+	}
+	u, err := s.usersRepository.GetUser(ctx, offender)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Hi %s,\n\nA moderator reviewed a report about your %s and found that it breaks the community guidelines (%s).\n",
+		u.FirstName, reportedContentName(r.TargetType), r.Reason)
+	if note != "" {
+		body += fmt.Sprintf("\nThe moderator added: %s\n", note)
+	}
+	body += "\nFurther violations can get your account suspended.\n"
+	return mailer.Send(ctx, Mail{To: u.Email, Subject: "A warning about your account", Body: body})
+}
+
+/*
+ * Suspends the offender for the days of the resolution, a week when none were given. Their tokens are rejected from then on, the status is cached by
+ * the middleware so it can take a little while to be enforced everywhere.
+ */
+func (s *ReportsService) suspend(ctx context.Context, r models.Report, resolution models.ReportResolution) error {
+	offender, err := s.offender(ctx, r)
+	if err != nil {
+		return err
+	}
+	days := defaultSuspensionDays
+	if resolution.SuspensionDays != nil {
+		days = *resolution.SuspensionDays
+	}
+	reason := resolution.Note
+	if reason == "" {
+		reason = r.Reason
+	}
+	until := time.Now().AddDate(0, 0, days)
+	err = suspendAccount(ctx, s.usersRepository, offender, until, reason)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s was suspended until %s after the report %s\n", offender, until.Format(time.RFC3339), r.ReportId)
+	return nil
+}
+
+func reportedContentName(targetType string) string {
+	switch targetType {
+	case models.ReportTargetQAndA:
+		return "answer"
+	case models.ReportTargetQuestion:
+		return "question"
+	default:
+		return "profile"
+	}
+}
+
+func recordAudit(ctx context.Context, repository data.CassandraReportsRepository, e models.AuditEntry) error {
+	entryId, err := uuid.NewUUID()
+	if err != nil {
+		return fmt.Errorf("failed to record the %s of %s in the audit log %s", e.Action, e.Moderator, err)
+	}
+	e.EntryId = entryId
+	e.CreatedOn = time.Now()
+	return repository.RecordAuditEntry(ctx, e)
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+)
+
+const (
+	maxTweetQuestionLength = 200
+	maxPreviewTitleLength  = 70
+	maxPreviewAnswerLength = 200
+)
+
+var publicBaseUrl string
+
+func init() {
+	godotenv.Load()
+	publicBaseUrl = strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if publicBaseUrl == "" {
+		publicBaseUrl = "http://localhost:8080"
+	}
+}
+
+/*
+ * Everything the public page of a shared q&a needs, the asker of an anonymous question is already removed from it
+ */
+type SharePreview struct {
+	QAndA       models.QAndA
+	Permalink   string
+	ImageUrl    string
+	ProfileUrl  string
+	Title       string
+	Description string
+}
+
+type ShareService struct {
+	questionsRepository data.CassandraQuestionsRepository
+	shareRepository     data.CassandraShareRepository
+}
+
+/*
+ * Any q&a can be shared by anyone who can see it, asked is whoever answered it and is the caller when they share their own answer
+ */
+func (s *ShareService) Share(ctx context.Context, caller string, asked string, qAndAIdInString string) (models.SharedQAndA, error) {
+	if caller == "" {
+		return models.SharedQAndA{}, ErrUnauthenticated
+	}
+	if asked == "" {
+		asked = caller
+	}
+	qAndAId, err := uuid.Parse(qAndAIdInString)
+	if err != nil {
+		return models.SharedQAndA{}, data.ErrQuestionNotFound
+	}
+
+	_, err = s.questionsRepository.GetQAndA(ctx, asked, qAndAId)
+	if err != nil {
+		return models.SharedQAndA{}, err
+	}
+	shared, err := s.shareRepository.SaveShare(ctx, models.SharedQAndA{
+		QuestionId: qAndAId,
+		Asked:      asked,
+		SharedOn:   time.Now(),
+	})
+	if err != nil {
+		return models.SharedQAndA{}, err
+	}
+	shared.Permalink = permalink(shared.QuestionId)
+	return shared, nil
+}
+
+/*
+ * Only the question and the link go in the tweet, never the asker, the permalink page shows them when the question was not anonymous
+ */
+func (s *ShareService) TweetIntent(ctx context.Context, caller string, asked string, qAndAIdInString string) (models.TweetIntent, error) {
+	shared, err := s.Share(ctx, caller, asked, qAndAIdInString)
+	if err != nil {
+		return models.TweetIntent{}, err
+	}
+	qAndA, err := s.questionsRepository.GetQAndA(ctx, shared.Asked, shared.QuestionId)
+	if err != nil {
+		return models.TweetIntent{}, err
+	}
+
+	query := url.Values{}
+	query.Set("text", fmt.Sprintf("%q answered by %s", truncate(qAndA.Question, maxTweetQuestionLength), qAndA.Asked))
+	query.Set("url", shared.Permalink)
+	return models.TweetIntent{Url: "https://twitter.com/intent/tweet?" + query.Encode()}, nil
+}
+
+/*
+ * Only q&as that were shared have a public page, knowing the id of a q&a is not enough to see it without being logged in
+ */
+func (s *ShareService) GetSharePreview(ctx context.Context, qAndAIdInString string) (SharePreview, error) {
+	qAndAId, err := uuid.Parse(qAndAIdInString)
+	if err != nil {
+		return SharePreview{}, data.ErrQuestionNotFound
+	}
+	shared, err := s.shareRepository.GetShare(ctx, qAndAId)
+	if err != nil {
+		return SharePreview{}, err
+	}
+	qAndA, err := s.questionsRepository.GetQAndA(ctx, shared.Asked, shared.QuestionId)
+	if err != nil {
+		return SharePreview{}, err
+	}
+	if qAndA.IsAnon {
+		qAndA.Asker = ""
+	}
+
+	return SharePreview{
+		QAndA:       qAndA,
+		Permalink:   permalink(qAndA.QuestionId),
+		ImageUrl:    permalink(qAndA.QuestionId) + ".png",
+		ProfileUrl:  fmt.Sprintf("%s/%s", appBaseUrl, url.PathEscape(qAndA.Asked)),
+		Title:       truncate(fmt.Sprintf("%s answered: %s", qAndA.Asked, qAndA.Question), maxPreviewTitleLength),
+		Description: truncate(qAndA.Answer, maxPreviewAnswerLength),
+	}, nil
+}
+
+// The card is only rendered for shared q&as, like the public page it is drawn over
+func (s *ShareService) GetShareCard(ctx context.Context, qAndAIdInString string) ([]byte, string, error) {
+	preview, err := s.GetSharePreview(ctx, qAndAIdInString)
+	if err != nil {
+		return nil, "", err
+	}
+	return renderCachedShareCard(preview)
+}
+
+func permalink(qAndAId uuid.UUID) string {
+	return fmt.Sprintf("%s/share/%s", publicBaseUrl, qAndAId)
+}
+
+// Cuts the text to at most max characters, on a word boundary when there is one
+func truncate(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	runes := []rune(text)[:max-1]
+	cut := string(runes)
+	if i := strings.LastIndex(cut, " "); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+/*
+ * The card follows the size social networks expect for large previews. The Go fonts are compiled into the binary, so the card looks the same wherever
+ * the server runs and no font has to be installed next to it.
+ */
+const (
+	shareCardWidth         = 1200
+	shareCardHeight        = 630
+	shareCardPadding       = 64
+	maxQuestionLinesOnCard = 3
+	maxAnswerLinesOnCard   = 5
+	maxCachedShareCards    = 256
+)
+
+var (
+	shareCardBackground = color.RGBA{R: 0x2b, G: 0x1f, B: 0x3d, A: 0xff}
+	shareCardAccent     = color.RGBA{R: 0xf2, G: 0xa5, B: 0x41, A: 0xff}
+	shareCardText       = color.RGBA{R: 0xf7, G: 0xf3, B: 0xee, A: 0xff}
+	shareCardMutedText  = color.RGBA{R: 0xb9, G: 0xae, B: 0xc9, A: 0xff}
+)
+
+var regularFont, boldFont *opentype.Font
+
+func init() {
+	var err error
+	regularFont, err = opentype.Parse(goregular.TTF)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse the embedded regular font %s", err))
+	}
+	boldFont, err = opentype.Parse(gobold.TTF)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse the embedded bold font %s", err))
+	}
+}
+
+/*
+ * Cards are cached by what is drawn on them, so an edited answer gets a new card while everyone fetching the same q&a shares one. The oldest card is
+ * dropped once the cache is full.
+ */
+var shareCardCache = struct {
+	sync.Mutex
+	cards map[string][]byte
+	order []string
+}{cards: map[string][]byte{}}
+
+// Returns the card as a PNG together with a key that changes whenever the card does, which can be used as an ETag
+func renderCachedShareCard(preview SharePreview) ([]byte, string, error) {
+	key := shareCardKey(preview)
+
+	shareCardCache.Lock()
+	card, ok := shareCardCache.cards[key]
+	shareCardCache.Unlock()
+	if ok {
+		return card, key, nil
+	}
+
+	card, err := renderShareCard(preview)
+	if err != nil {
+		return nil, "", err
+	}
+
+	shareCardCache.Lock()
+	defer shareCardCache.Unlock()
+	if _, ok := shareCardCache.cards[key]; !ok {
+		shareCardCache.cards[key] = card
+		shareCardCache.order = append(shareCardCache.order, key)
+		if len(shareCardCache.order) > maxCachedShareCards {
+			delete(shareCardCache.cards, shareCardCache.order[0])
+			shareCardCache.order = shareCardCache.order[1:]
+		}
+	}
+	return card, key, nil
+}
+
+func shareCardKey(preview SharePreview) string {
+	hash := sha256.New()
+	for _, part := range []string{preview.QAndA.QuestionId.String(), preview.QAndA.Asked, preview.QAndA.Asker, preview.QAndA.Question, preview.QAndA.Answer} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/*
+ * The card is laid out from top to bottom:
+ * 1) Who asked, or that the question was anonymous.
+ * 2) The question in bold, wrapped on up to maxQuestionLinesOnCard lines.
+ * 3) The answer, wrapped on up to maxAnswerLinesOnCard lines.
+ * 4) Who answered and the name of the site at the bottom.
+ * Text that does not fit is cut and ends with an ellipsis.
+ */
+func renderShareCard(preview SharePreview) ([]byte, error) {
+	faces := map[string]font.Face{}
+	for name, options := range map[string]struct {
+		font *opentype.Font
+		size float64
+	}{
+		"small":    {regularFont, 28},
+		"question": {boldFont, 48},
+		"answer":   {regularFont, 36},
+		"footer":   {boldFont, 30},
+	} {
+		face, err := opentype.NewFace(options.font, &opentype.FaceOptions{Size: options.size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the font of the share card %s", err)
+		}
+		defer face.Close()
+		faces[name] = face
+	}
+
+	card := image.NewRGBA(image.Rect(0, 0, shareCardWidth, shareCardHeight))
+	draw.Draw(card, card.Bounds(), &image.Uniform{shareCardBackground}, image.Point{}, draw.Src)
+	draw.Draw(card, image.Rect(0, 0, 16, shareCardHeight), &image.Uniform{shareCardAccent}, image.Point{}, draw.Src)
+
+	textWidth := fixed.I(shareCardWidth - 2*shareCardPadding)
+	y := shareCardPadding
+
+	askedBy := "Someone asked anonymously"
+	if preview.QAndA.Asker != "" {
+		askedBy = preview.QAndA.Asker + " asked"
+	}
+	y = drawLines(card, faces["small"], shareCardMutedText, []string{askedBy}, y) + 16
+
+	questionLines := wrapText(faces["question"], preview.QAndA.Question, textWidth, maxQuestionLinesOnCard)
+	y = drawLines(card, faces["question"], shareCardText, questionLines, y) + 24
+
+	answerLines := wrapText(faces["answer"], preview.QAndA.Answer, textWidth, maxAnswerLinesOnCard)
+	drawLines(card, faces["answer"], shareCardText, answerLines, y)
+
+	footerFace := faces["footer"]
+	footerY := shareCardHeight - shareCardPadding - footerFace.Metrics().Height.Ceil()
+	drawLines(card, footerFace, shareCardAccent, []string{preview.QAndA.Asked}, footerY)
+	siteName := "Inquisitive Grimalkin"
+	siteNameWidth := font.MeasureString(footerFace, siteName).Ceil()
+	drawText(card, footerFace, shareCardMutedText, siteName, shareCardWidth-shareCardPadding-siteNameWidth, footerY)
+
+	var encoded bytes.Buffer
+	err := png.Encode(&encoded, card)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the share card %s", err)
+	}
+	return encoded.Bytes(), nil
+}
+
+// Draws every line below the previous one starting at y, which is the top of the first line, and returns the y below the last line
+func drawLines(dst draw.Image, face font.Face, c color.Color, lines []string, y int) int {
+	lineHeight := face.Metrics().Height.Ceil()
+	for _, line := range lines {
+		drawText(dst, face, c, line, shareCardPadding, y)
+		y += lineHeight
+	}
+	return y
+}
+
+func drawText(dst draw.Image, face font.Face, c color.Color, text string, x int, top int) {
+	drawer := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, top+face.Metrics().Ascent.Ceil()),
+	}
+	drawer.DrawString(text)
+}
+
+/*
+ * Breaks the text into lines that fit in the width, words are only split when a single word is wider than a line. Whatever does not fit in maxLines is
+ * cut, and the last line ends with an ellipsis to show that something is missing.
+ */
+func wrapText(face font.Face, text string, width fixed.Int26_6, maxLines int) []string {
+	lines := []string{}
+	current := ""
+	truncated := false
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if font.MeasureString(face, candidate) <= width {
+			current = candidate
+			continue
+		}
+
+		if current != "" {
+			lines = append(lines, current)
+			current = ""
+		}
+		// A word wider than a whole line is split wherever it has to be
+		for font.MeasureString(face, word) > width {
+			fitting := []rune(word)
+			for len(fitting) > 1 && font.MeasureString(face, string(fitting)) > width {
+				fitting = fitting[:len(fitting)-1]
+			}
+			lines = append(lines, string(fitting))
+			word = string([]rune(word)[len(fitting):])
+		}
+		current = word
+
+		if len(lines) >= maxLines {
+			truncated = true
+			break
+		}
+	}
+	if current != "" && !truncated {
+		lines = append(lines, current)
+	}
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		truncated = true
+	}
+
+	if truncated && len(lines) > 0 {
+		last := []rune(strings.TrimSpace(lines[len(lines)-1]))
+		for len(last) > 0 && font.MeasureString(face, string(last)+"…") > width {
+			last = last[:len(last)-1]
+		}
+		lines[len(lines)-1] = strings.TrimSpace(string(last)) + "…"
+	}
+	return lines
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/models"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	maxTweetLength       = 280
+	tweetUrlLength       = 23
+	maxMastodonLength    = 500
+	mastodonUrlLength    = 23
+	sharerRequestTimeout = 15 * time.Second
+)
+
+var ErrLinkedAccountUnauthorized = errors.New("the linked account no longer authorizes posting, it has to be linked again")
+
+var twitterClientId string
+
+func init() {
+	godotenv.Load()
+	twitterClientId = os.Getenv("TWITTER_CLIENT_ID")
+}
+
+type SharePost struct {
+	Text string
+	Url  string
+	// The same key is sent on every attempt of a job so that a provider supporting it does not post twice
+	IdempotencyKey string
+}
+
+/*
+ * A Sharer posts to one network on behalf of a linked account and comes back with the url of the post. Errors that retrying cannot fix are wrapped
+ * with Permanent.
+ */
+type Sharer interface {
+	Provider() string
+	Compose(question string, answeredBy string, link string) SharePost
+	Share(ctx context.Context, account models.LinkedAccount, post SharePost) (string, error)
+}
+
+// Implemented by the sharers whose access tokens expire and can be renewed with the refresh token
+type Refresher interface {
+	Refresh(ctx context.Context, account models.LinkedAccount) (models.LinkedAccount, error)
+}
+
+type TwitterSharer struct {
+	BaseUrl string
+	Client  *http.Client
+}
+
+func NewTwitterSharer() *TwitterSharer {
+	return &TwitterSharer{BaseUrl: "https://api.twitter.com", Client: &http.Client{Timeout: sharerRequestTimeout}}
+}
+
+func (s *TwitterSharer) Provider() string {
+	return models.ProviderTwitter
+}
+
+// Twitter counts every link as 23 characters whatever its length
+func (s *TwitterSharer) Compose(question string, answeredBy string, link string) SharePost {
+	return SharePost{Text: composePost(question, answeredBy, link, maxTweetLength, tweetUrlLength), Url: link}
+}
+
+func (s *TwitterSharer) Share(ctx context.Context, account models.LinkedAccount, post SharePost) (string, error) {
+	body, err := json.Marshal(map[string]string{"text": post.Text})
+	if err != nil {
+		return "", Permanent(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseUrl+"/2/tweets", strings.NewReader(string(body)))
+	if err != nil {
+		return "", Permanent(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+account.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	var tweet struct {
+		Data struct {
+			Id string `json:"id"`
+		} `json:"data"`
+	}
+	err = doSharerRequest(s.Client, req, &tweet)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://twitter.com/%s/status/%s", url.PathEscape(account.Handle), tweet.Data.Id), nil
+}
+
+func (s *TwitterSharer) Refresh(ctx context.Context, account models.LinkedAccount) (models.LinkedAccount, error) {
+	if account.RefreshToken == "" {
+		return models.LinkedAccount{}, Permanent(ErrLinkedAccountUnauthorized)
+	}
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", account.RefreshToken)
+	form.Set("client_id", twitterClientId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseUrl+"/2/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return models.LinkedAccount{}, Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+	err = doSharerRequest(s.Client, req, &token)
+	if err != nil {
+		return models.LinkedAccount{}, err
+	}
+	account.AccessToken = token.AccessToken
+	if token.RefreshToken != "" {
+		account.RefreshToken = token.RefreshToken
+	}
+	account.ExpiresOn = time.Time{}
+	if token.ExpiresIn > 0 {
+		account.ExpiresOn = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return account, nil
+}
+
+/*
+ * Mastodon is federated, every account posts to the instance it was linked from
+ */
+type MastodonSharer struct {
+	Client *http.Client
+}
+
+func NewMastodonSharer() *MastodonSharer {
+	return &MastodonSharer{Client: &http.Client{Timeout: sharerRequestTimeout}}
+}
+
+func (s *MastodonSharer) Provider() string {
+	return models.ProviderMastodon
+}
+
+func (s *MastodonSharer) Compose(question string, answeredBy string, link string) SharePost {
+	return SharePost{Text: composePost(question, answeredBy, link, maxMastodonLength, mastodonUrlLength), Url: link}
+}
+
+func (s *MastodonSharer) Share(ctx context.Context, account models.LinkedAccount, post SharePost) (string, error) {
+	if account.InstanceUrl == "" {
+		return "", Permanent(fmt.Errorf("the mastodon account %s has no instance", account.Handle))
+	}
+	form := url.Values{}
+	form.Set("status", post.Text)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(account.InstanceUrl, "/")+"/api/v1/statuses", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", Permanent(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+account.AccessToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if post.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", post.IdempotencyKey)
+	}
+
+	var status struct {
+		Url string `json:"url"`
+	}
+	err = doSharerRequest(s.Client, req, &status)
+	if err != nil {
+		return "", err
+	}
+	return status.Url, nil
+}
+
+/*
+ * 401 means the token was revoked or expired, any other 4xx but 429 means the provider will never accept the post, both are permanent. Rate limits,
+ * server errors and network failures are worth retrying.
+ */
+func doSharerRequest(client *http.Client, req *http.Request, v any) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s %s", req.URL.Host, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read the response of %s %s", req.URL.Host, err)
+	}
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		return Permanent(ErrLinkedAccountUnauthorized)
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return fmt.Errorf("%s answered with %d %s", req.URL.Host, res.StatusCode, truncate(string(body), 200))
+	case res.StatusCode >= 400:
+		return Permanent(fmt.Errorf("%s rejected the post with %d %s", req.URL.Host, res.StatusCode, truncate(string(body), 200)))
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to parse the response of %s %s", req.URL.Host, err))
+	}
+	return nil
+}
+
+// Fits the question in what is left of the limit once the attribution and the link are counted
+func composePost(question string, answeredBy string, link string, maxLength int, urlLength int) string {
+	attribution := fmt.Sprintf(" answered by %s ", answeredBy)
+	available := maxLength - urlLength - utf8.RuneCountInString(attribution) - 2
+	if available < 1 {
+		return link
+	}
+	return fmt.Sprintf("\"%s\"%s%s", truncate(question, available), attribution, link)
+}
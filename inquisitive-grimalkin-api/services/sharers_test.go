@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"inquisitive-grimalkin/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestTwitterSharerShare(t *testing.T) {
+	var received *http.Request
+	var tweet map[string]string
+	twitter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		json.NewDecoder(r.Body).Decode(&tweet)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"data":{"id":"1445880548472328192","text":"hi"}}`))
+	}))
+	defer twitter.Close()
+
+	sharer := &TwitterSharer{BaseUrl: twitter.URL, Client: twitter.Client()}
+	account := models.LinkedAccount{Handle: "grimalkin", AccessToken: "access-token"}
+	remoteUrl, err := sharer.Share(context.Background(), account, SharePost{Text: "the post", Url: "https://example.com/q/1"})
+	if err != nil {
+		t.Fatalf("failed to share %s", err)
+	}
+
+	if received.Method != http.MethodPost || received.URL.Path != "/2/tweets" {
+		t.Errorf("twitter got %s %s, want POST /2/tweets", received.Method, received.URL.Path)
+	}
+	if got := received.Header.Get("Authorization"); got != "Bearer access-token" {
+		t.Errorf("the authorization is %q, want the access token of the account", got)
+	}
+	if tweet["text"] != "the post" {
+		t.Errorf("the tweet is %q, want %q", tweet["text"], "the post")
+	}
+	if remoteUrl != "https://twitter.com/grimalkin/status/1445880548472328192" {
+		t.Errorf("the url of the tweet is %s", remoteUrl)
+	}
+}
+
+func TestTwitterSharerRefresh(t *testing.T) {
+	twitterClientId = "client-id"
+	var form map[string]string
+	twitter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/2/oauth2/token" {
+			t.Errorf("twitter got %s %s, want POST /2/oauth2/token", r.Method, r.URL.Path)
+		}
+		r.ParseForm()
+		form = map[string]string{}
+		for name := range r.PostForm {
+			form[name] = r.PostForm.Get(name)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"token_type":"bearer","access_token":"new-access-token","refresh_token":"new-refresh-token","expires_in":7200}`))
+	}))
+	defer twitter.Close()
+
+	sharer := &TwitterSharer{BaseUrl: twitter.URL, Client: twitter.Client()}
+	account := models.LinkedAccount{
+		Handle:       "grimalkin",
+		AccessToken:  "expired-access-token",
+		RefreshToken: "refresh-token",
+		ExpiresOn:    time.Now().Add(-time.Minute),
+	}
+	refreshed, err := sharer.Refresh(context.Background(), account)
+	if err != nil {
+		t.Fatalf("failed to refresh %s", err)
+	}
+
+	want := map[string]string{"grant_type": "refresh_token", "refresh_token": "refresh-token", "client_id": "client-id"}
+	for name, value := range want {
+		if form[name] != value {
+			t.Errorf("%s is %q, want %q", name, form[name], value)
+		}
+	}
+	if refreshed.AccessToken != "new-access-token" || refreshed.RefreshToken != "new-refresh-token" {
+		t.Errorf("the tokens were not replaced, got %q and %q", refreshed.AccessToken, refreshed.RefreshToken)
+	}
+	if until := time.Until(refreshed.ExpiresOn); until < 7190*time.Second || until > 7200*time.Second {
+		t.Errorf("the token expires in %s, want about 2h", until)
+	}
+}
+
+func TestTwitterSharerRefreshWithoutRefreshToken(t *testing.T) {
+	sharer := &TwitterSharer{BaseUrl: "http://127.0.0.1:0", Client: http.DefaultClient}
+	_, err := sharer.Refresh(context.Background(), models.LinkedAccount{AccessToken: "expired-access-token"})
+	if !errors.Is(err, ErrLinkedAccountUnauthorized) || !IsPermanent(err) {
+		t.Errorf("got %v, want a permanent ErrLinkedAccountUnauthorized", err)
+	}
+}
+
+func TestMastodonSharerShare(t *testing.T) {
+	var received *http.Request
+	mastodon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		r.ParseForm()
+		w.Write([]byte(`{"id":"103704874086360371","url":"https://mastodon.example/@grimalkin/103704874086360371"}`))
+	}))
+	defer mastodon.Close()
+
+	sharer := &MastodonSharer{Client: mastodon.Client()}
+	account := models.LinkedAccount{Handle: "grimalkin", InstanceUrl: mastodon.URL + "/", AccessToken: "access-token"}
+	post := SharePost{Text: "the post", Url: "https://example.com/q/1", IdempotencyKey: "job-1"}
+	remoteUrl, err := sharer.Share(context.Background(), account, post)
+	if err != nil {
+		t.Fatalf("failed to share %s", err)
+	}
+
+	if received.Method != http.MethodPost || received.URL.Path != "/api/v1/statuses" {
+		t.Errorf("mastodon got %s %s, want POST /api/v1/statuses", received.Method, received.URL.Path)
+	}
+	if got := received.Header.Get("Authorization"); got != "Bearer access-token" {
+		t.Errorf("the authorization is %q, want the access token of the account", got)
+	}
+	if got := received.Header.Get("Idempotency-Key"); got != "job-1" {
+		t.Errorf("the idempotency key is %q, want job-1", got)
+	}
+	if got := received.PostForm.Get("status"); got != "the post" {
+		t.Errorf("the status is %q, want %q", got, "the post")
+	}
+	if remoteUrl != "https://mastodon.example/@grimalkin/103704874086360371" {
+		t.Errorf("the url of the status is %s", remoteUrl)
+	}
+}
+
+func TestSharerFailures(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		permanent    bool
+		unauthorized bool
+	}{
+		{name: "revoked token", status: http.StatusUnauthorized, permanent: true, unauthorized: true},
+		{name: "rate limited", status: http.StatusTooManyRequests},
+		{name: "provider down", status: http.StatusBadGateway},
+		{name: "duplicate post", status: http.StatusForbidden, body: `{"detail":"duplicate content"}`, permanent: true},
+		{name: "unreadable response", status: http.StatusOK, body: `not json`, permanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer provider.Close()
+
+			sharers := []Sharer{
+				&TwitterSharer{BaseUrl: provider.URL, Client: provider.Client()},
+				&MastodonSharer{Client: provider.Client()},
+			}
+			account := models.LinkedAccount{Handle: "grimalkin", InstanceUrl: provider.URL, AccessToken: "access-token"}
+			for _, sharer := range sharers {
+				_, err := sharer.Share(context.Background(), account, SharePost{Text: "the post"})
+				if err == nil {
+					t.Fatalf("%s took a %d as posted", sharer.Provider(), tt.status)
+				}
+				if IsPermanent(err) != tt.permanent {
+					t.Errorf("%s: permanent is %t, want %t for %s", sharer.Provider(), IsPermanent(err), tt.permanent, err)
+				}
+				if errors.Is(err, ErrLinkedAccountUnauthorized) != tt.unauthorized {
+					t.Errorf("%s: unauthorized is %t, want %t for %s", sharer.Provider(), errors.Is(err, ErrLinkedAccountUnauthorized), tt.unauthorized, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMastodonSharerWithoutInstance(t *testing.T) {
+	_, err := NewMastodonSharer().Share(context.Background(), models.LinkedAccount{Handle: "grimalkin"}, SharePost{Text: "the post"})
+	if err == nil || !IsPermanent(err) {
+		t.Errorf("got %v, want a permanent error", err)
+	}
+}
+
+// The link counts as the fixed length of the network whatever its real length, the question is what gets cut
+func TestCompose(t *testing.T) {
+	link := "https://example.com/" + strings.Repeat("q", 100)
+	question := strings.Repeat("é", 400)
+	tests := []struct {
+		sharer    Sharer
+		maxLength int
+		urlLength int
+	}{
+		{sharer: NewTwitterSharer(), maxLength: maxTweetLength, urlLength: tweetUrlLength},
+		{sharer: NewMastodonSharer(), maxLength: maxMastodonLength, urlLength: mastodonUrlLength},
+	}
+	for _, tt := range tests {
+		post := tt.sharer.Compose(question, "grimalkin", link)
+		if !strings.HasSuffix(post.Text, " answered by grimalkin "+link) {
+			t.Errorf("%s: the post does not end with the attribution and the link %q", tt.sharer.Provider(), post.Text)
+		}
+		length := utf8.RuneCountInString(strings.TrimSuffix(post.Text, link)) + tt.urlLength
+		if length > tt.maxLength {
+			t.Errorf("%s: the post counts %d characters, over %d", tt.sharer.Provider(), length, tt.maxLength)
+		}
+		if post.Url != link {
+			t.Errorf("%s: the url is %s, want %s", tt.sharer.Provider(), post.Url, link)
+		}
+
+		short := tt.sharer.Compose("why?", "grimalkin", link)
+		if short.Text != "\"why?\" answered by grimalkin "+link {
+			t.Errorf("%s: a short question was changed %q", tt.sharer.Provider(), short.Text)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/utils"
+	"math/bits"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// How long the questions of an asker are remembered, every window below has to fit in it
+	spamLookback = time.Hour
+
+	duplicateWindow      = time.Hour
+	duplicateRecipients  = 5
+	duplicateMaxDistance = 6
+
+	burstWindow = 2 * time.Minute
+	burstSize   = 10
+
+	maxLinks             = 3
+	minWordsAroundLinks  = 3
+	simHashShingleLength = 3
+)
+
+var linkPattern = regexp.MustCompile(`(?i)(?:https?://|www\.)\S+|\b[a-z0-9-]+\.(?:com|net|org|io|ly|co|me|xyz|info|biz|ru|gg|link|site|top)\b(?:/\S*)?`)
+
+/*
+ * Looks for the three kinds of spam the inboxes get, mostly through anonymous questions: the same question, give or take a few characters, sent to
+ * many users, many questions in a row from one asker and questions that are mostly links. Only the questions of the same asker are compared, a
+ * common question asked by many different users is not spam.
+ */
+type SpamDetector struct {
+	repository data.CassandraSpamRepository
+}
+
+/*
+ * Remembers the question and tells why it looks like spam, a question without reasons does not. The question is remembered first so that it counts
+ * towards the bursts and the duplicates along with the earlier ones.
+ */
+func (d *SpamDetector) Inspect(ctx context.Context, q models.Question, now time.Time) ([]string, error) {
+	fingerprint := simHash(q.Question)
+	err := d.repository.RecordQuestion(ctx, models.RecentQuestion{Asker: q.Asker, Asked: q.Asked, AskedOn: now, Fingerprint: fingerprint}, spamLookback)
+	if err != nil {
+		return nil, err
+	}
+	recentQuestions, err := d.repository.GetRecentQuestions(ctx, q.Asker, now.Add(-spamLookback))
+	if err != nil {
+		return nil, err
+	}
+
+	burst := 0
+	recipients := map[string]struct{}{}
+	for _, recent := range recentQuestions {
+		if recent.AskedOn.After(now.Add(-burstWindow)) {
+			burst++
+		}
+		if recent.AskedOn.After(now.Add(-duplicateWindow)) && bits.OnesCount64(recent.Fingerprint^fingerprint) <= duplicateMaxDistance {
+			recipients[recent.Asked] = struct{}{}
+		}
+	}
+
+	reasons := []string{}
+	if len(recipients) >= duplicateRecipients {
+		reasons = append(reasons, fmt.Sprintf("the same question was sent to %d users within %s", len(recipients), duplicateWindow))
+	}
+	if burst >= burstSize {
+		reasons = append(reasons, fmt.Sprintf("%d questions were asked within %s", burst, burstWindow))
+	}
+	links := linkPattern.FindAllString(q.Question, -1)
+	wordsAroundLinks := len(strings.Fields(linkPattern.ReplaceAllString(q.Question, " ")))
+	if len(links) >= maxLinks || (len(links) > 0 && wordsAroundLinks < minWordsAroundLinks) {
+		reasons = append(reasons, fmt.Sprintf("the question is mostly links, %d of them", len(links)))
+	}
+	return reasons, nil
+}
+
+/*
+ * The SimHash of the text over its shingles of a few characters, case, accents, spaces and punctuation left out. Texts that differ by a few characters
+ * end up a few bits apart.
+ */
+func simHash(text string) uint64 {
+	runes := []rune{}
+	for _, r := range utils.SearchKey(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			runes = append(runes, r)
+		}
+	}
+	shingles := []string{string(runes)}
+	if len(runes) > simHashShingleLength {
+		shingles = shingles[:0]
+		for i := 0; i+simHashShingleLength <= len(runes); i++ {
+			shingles = append(shingles, string(runes[i:i+simHashShingleLength]))
+		}
+	}
+
+	weights := [64]int{}
+	for _, shingle := range shingles {
+		hash := fnv.New64a()
+		hash.Write([]byte(shingle))
+		sum := hash.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint
+}
+
+/*
+ * The question gets an id as if it had been delivered, the asker is not told that it was quarantined
+ */
+func quarantine(ctx context.Context, repository data.CassandraSpamRepository, q models.Question, reasons []string) (models.Question, error) {
+	questionId, err := uuid.NewUUID()
+	if err != nil {
+		return models.Question{}, fmt.Errorf("failed to quarantine the question %s", err)
+	}
+	q.QuestionId = questionId
+	err = repository.Quarantine(ctx, models.QuarantinedQuestion{Question: q, Reasons: reasons})
+	if err != nil {
+		return models.Question{}, err
+	}
+	return q, nil
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/utils"
+	"time"
+)
+
+const defaultSuspensionDays = 7
+
+type SuspensionService struct {
+	usersRepository   data.CassandraUsersRepository
+	reportsRepository data.CassandraReportsRepository
+}
+
+func (s *SuspensionService) GetAccountStatus(ctx context.Context, username string) (models.AccountStatus, error) {
+	return s.usersRepository.GetAccountStatus(ctx, username)
+}
+
+/*
+ * Replaces the status of the account, an empty status reinstates it. Every change is recorded in the audit log as a suspension, a ban or a
+ * reinstatement depending on what the account is left with.
+ */
+func (s *SuspensionService) SetAccountStatus(ctx context.Context, admin string, username string, status models.AccountStatus) (models.AccountStatus, error) {
+	if admin == "" {
+		return models.AccountStatus{}, ErrUnauthenticated
+	}
+	status.Username = username
+	err := utils.ValidateAccountStatus(status, time.Now())
+	if err != nil {
+		return models.AccountStatus{}, err
+	}
+	err = s.usersRepository.SetAccountStatus(ctx, status)
+	if err != nil {
+		return models.AccountStatus{}, err
+	}
+
+	action := models.ModerationActionReinstate
+	switch {
+	case status.Banned:
+		action = models.ModerationActionBan
+	case !status.SuspendedUntil.IsZero():
+		action = models.ModerationActionSuspend
+	}
+	err = recordAudit(ctx, s.reportsRepository, models.AuditEntry{
+		Moderator:   admin,
+		Action:      action,
+		TargetType:  models.ReportTargetUser,
+		TargetOwner: username,
+		Note:        status.Reason,
+	})
+	if err != nil {
+		return models.AccountStatus{}, err
+	}
+	return status, nil
+}
+
+/*
+ * Suspends the account until the given time unless it is already banned or suspended for longer, a suspension never shortens a harsher one
+ */
+func suspendAccount(ctx context.Context, repository data.CassandraUsersRepository, username string, until time.Time, reason string) error {
+	status, err := repository.GetAccountStatus(ctx, username)
+	if err != nil {
+		return err
+	}
+	if status.Banned || status.SuspendedUntil.After(until) {
+		return nil
+	}
+	status.SuspendedUntil = until
+	status.Reason = reason
+	return repository.SetAccountStatus(ctx, status)
+}
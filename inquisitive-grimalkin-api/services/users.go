@@ -2,20 +2,286 @@ package services
 
 import (
 	"context"
+	"errors"
 	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"inquisitive-grimalkin/utils"
+	"log"
+	"sort"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	DefaultSearchPageSize = 10
+	MaxSearchPageSize     = 50
 )
 
 type UsersService struct {
 	userRepostory data.CassandraUsersRepository
+	sessionsRepository data.CassandraSessionsRepository
+	accountService AccountService
+	questionsRepository data.CassandraQuestionsRepository
+	moderationRepository data.CassandraModerationRepository
+	notificationsRepository data.CassandraNotificationsRepository
+}
+
+
+/*
+ * Offensive usernames and names are refused before the account is created. A held profile still gets its account, it is only put in front of a
+ * moderator who can act on the account afterwards.
+ */
+func (s *UsersService) Register(context context.Context, u models.User) (models.User, error) {
+	verdict, err := moderateProfile(context, u.Username, u.FirstName, u.LastName)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	registeredUser, err := s.userRepostory.Register(context, u)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if verdict.Outcome == models.ModerationHold {
+		err = holdForReview(context, s.moderationRepository, models.ContentProfile, registeredUser.Username, publicUser(registeredUser), verdict)
+		if err != nil {
+			log.Printf("failed to hold the profile of %s for review %s\n", registeredUser.Username, err)
+		}
+	}
+	return registeredUser, nil
 }
 
+/*
+ * Hands the user back, with their roles, when the password is theirs. Whether the username or the password was wrong is not told apart, both come back
+ * as data.ErrWrongPassword so that nobody can find out who has an account.
+ */
+func (s *UsersService) Login(context context.Context, u models.User) (models.User, error) {
+	errs := utils.ValidationErrors{}
+	if u.Username == "" {
+		errs.Add("username", "required", "username cannot be empty")
+	}
+	if u.Password == "" {
+		errs.Add("password", "required", "password cannot be empty")
+	}
+	if len(errs) > 0 {
+		return models.User{}, errs
+	}
+
+	loggedIn, err := s.userRepostory.Login(context, u)
+	if errors.Is(err, data.ErrUserNotFound) {
+		return models.User{}, data.ErrWrongPassword
+	}
+	return loggedIn, err
+}
+
+func publicUser(u models.User) models.PublicUser {
+	return models.PublicUser{Username: u.Username, FirstName: u.FirstName, LastName: u.LastName}
+}
 
 func (s *UsersService) Follow(context context.Context, follower string, following string) error {
-	s.userRepostory.Follow(context, follower, following)
+	err := s.userRepostory.Follow(context, follower, following)
+	if err != nil {
+		return err
+	}
+	notify(context, s.notificationsRepository, models.Notification{
+		Username: following,
+		Kind:     models.NotificationFollow,
+		Actor:    follower,
+	})
 	return nil
 }
 
 func (s *UsersService) Unfollow(context context.Context, follower string, following string) error {
-	s.userRepostory.Follow(context, follower, following)
+	return s.userRepostory.Unfollow(context, follower, following)
+}
+
+/*
+ * Everything on the profile other than the user themselves is independent, so it is all fetched in parallel once the user is known to exist
+ */
+func (s *UsersService) GetProfile(ctx context.Context, caller string, username string) (models.PublicProfile, error) {
+	u, err := s.userRepostory.GetUser(ctx, username)
+	if err != nil {
+		return models.PublicProfile{}, err
+	}
+
+	profile := models.PublicProfile{
+		Username:  u.Username,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		JoinedOn:  u.JoinedOn,
+	}
+	profileGroup, profileCtx := errgroup.WithContext(ctx)
+	profileGroup.Go(func() error {
+		followers, following, err := s.userRepostory.GetFollowCounts(profileCtx, username)
+		profile.Followers, profile.Following = followers, following
+		return err
+	})
+	profileGroup.Go(func() error {
+		answers, err := s.questionsRepository.CountAnswersForUser(profileCtx, username)
+		profile.Answers = answers
+		return err
+	})
+	if caller != "" && caller != username {
+		profileGroup.Go(func() error {
+			followedByYou, err := s.userRepostory.IsFollowing(profileCtx, caller, username)
+			profile.FollowedByYou = followedByYou
+			return err
+		})
+		profileGroup.Go(func() error {
+			followsYou, err := s.userRepostory.IsFollowing(profileCtx, username, caller)
+			profile.FollowsYou = followsYou
+			return err
+		})
+	}
+	err = profileGroup.Wait()
+	if err != nil {
+		return models.PublicProfile{}, err
+	}
+	return profile, nil
+}
+
+/*
+ * Users whose username or full name is exactly what was searched for come first, then the ones found without a typo before the ones found with typos,
+ * each ranked by how many followers they have. The ranking needs every candidate at once, so the pages are cut from the ranked candidates instead of
+ * being fetched page by page.
+ */
+func (s *UsersService) SearchUsers(context context.Context, term string, page int, pageSize int) (models.UserSearchPage, error) {
+	key := utils.SearchKey(term)
+	if key == "" {
+		errs := utils.ValidationErrors{}
+		errs.Add("username", "required", "search cannot be empty")
+		return models.UserSearchPage{}, errs
+	}
+	if page < 0 {
+		page = 0
+	}
+	if pageSize <= 0 || pageSize > MaxSearchPageSize {
+		pageSize = DefaultSearchPageSize
+	}
+
+	candidates, err := s.userRepostory.SearchForUsername(context, key)
+	if err != nil {
+		return models.UserSearchPage{}, err
+	}
+
+	exactness := func(u models.PublicUser) int {
+		switch key {
+		case utils.SearchKey(u.Username):
+			return 2
+		case utils.SearchKey(u.FirstName + " " + u.LastName):
+			return 1
+		}
+		return 0
+	}
+	distance := func(u models.PublicUser) int {
+		d, _ := utils.SearchDistance(key, u.Username, u.FirstName, u.LastName)
+		return d
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if exactness(candidates[i]) != exactness(candidates[j]) {
+			return exactness(candidates[i]) > exactness(candidates[j])
+		}
+		if distance(candidates[i]) != distance(candidates[j]) {
+			return distance(candidates[i]) < distance(candidates[j])
+		}
+		if candidates[i].Followers != candidates[j].Followers {
+			return candidates[i].Followers > candidates[j].Followers
+		}
+		return candidates[i].Username < candidates[j].Username
+	})
+
+	start := page * pageSize
+	if start > len(candidates) {
+		start = len(candidates)
+	}
+	end := start + pageSize
+	if end > len(candidates) {
+		end = len(candidates)
+	}
+	return models.UserSearchPage{
+		Users:    candidates[start:end],
+		Page:     page,
+		PageSize: pageSize,
+		HasMore:  end < len(candidates),
+	}, nil
+}
+
+/*
+ * A new email is only staged, the user keeps logging in and receiving mails on the current one until the new one is verified
+ */
+func (s *UsersService) UpdateProfile(context context.Context, username string, update models.ProfileUpdate) (models.User, error) {
+	err := utils.ValidateProfileUpdate(update)
+	if err != nil {
+		return models.User{}, err
+	}
+	firstName, lastName := "", ""
+	if update.FirstName != nil {
+		firstName = *update.FirstName
+	}
+	if update.LastName != nil {
+		lastName = *update.LastName
+	}
+	verdict, err := moderateProfile(context, "", firstName, lastName)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	u := models.User{Username: username}
+	if update.Email != nil {
+		u.Email = *update.Email
+	}
+	if update.FirstName != nil {
+		u.FirstName = *update.FirstName
+	}
+	if update.LastName != nil {
+		u.LastName = *update.LastName
+	}
+	updatedUser, err := s.userRepostory.UpdateLoginDetails(context, u)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if verdict.Outcome == models.ModerationHold {
+		err = holdForReview(context, s.moderationRepository, models.ContentProfile, username, publicUser(updatedUser), verdict)
+		if err != nil {
+			log.Printf("failed to hold the profile of %s for review %s\n", username, err)
+		}
+	}
+
+	// The profile is already updated, the user can ask for the verification again if the mail does not go out
+	if update.Email != nil && updatedUser.PendingEmail != "" {
+		err := s.accountService.SendEmailVerification(context, username)
+		if err != nil {
+			log.Printf("failed to send the verification of the new email of %s %s\n", username, err)
+		}
+	}
+	return updatedUser, nil
+}
+
+/*
+ * Changing the password revokes every session of the user, including the one that changed it, whoever got hold of the old password or of a token
+ * issued with it is logged out. The caller has to hand out a new token for the session to carry on.
+ */
+func (s *UsersService) ChangePassword(context context.Context, username string, change models.PasswordChange) error {
+	u, err := s.userRepostory.GetUser(context, username)
+	if err != nil {
+		return err
+	}
+	err = utils.ValidatePasswordChange(change, u.Username, u.Email)
+	if err != nil {
+		return err
+	}
+
+	err = s.userRepostory.UpdatePassword(context, username, change.CurrentPassword, change.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	err = s.sessionsRepository.RevokeSessions(context, username, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("the password of %s was changed and all of their sessions were revoked\n", username)
 	return nil
-}
\ No newline at end of file
+}
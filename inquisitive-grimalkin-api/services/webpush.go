@@ -0,0 +1,300 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"inquisitive-grimalkin/data"
+	"inquisitive-grimalkin/models"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/joho/godotenv"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	pushRecordSize     = 4096
+	pushMessageTTL     = 24 * time.Hour
+	vapidTokenValidity = 12 * time.Hour
+	pushRequestTimeout = 10 * time.Second
+	// The whole message is a single record, which also holds the 86 bytes of header, the 16 bytes of tag and the delimiter
+	maxPushPayloadSize = pushRecordSize - 86 - 16 - 1
+)
+
+var (
+	ErrWebPushDisabled      = errors.New("web push is not configured on this server")
+	ErrPushSubscriptionGone = errors.New("the push subscription expired or was revoked by the browser")
+	ErrPushPayloadTooLarge  = errors.New("the push message is too large to be encrypted in a single record")
+)
+
+// Nil when VAPID_PRIVATE_KEY is not set, nothing is pushed then
+var webPush *WebPushSender
+
+var (
+	pushQueue     *JobQueue
+	pushQueueOnce sync.Once
+)
+
+func sharedPushQueue() *JobQueue {
+	pushQueueOnce.Do(func() {
+		pushQueue = NewJobQueue(jobQueueWorkers)
+	})
+	return pushQueue
+}
+
+/*
+ * VAPID_PRIVATE_KEY is the base64url encoded P-256 private key the push services know this server by, its public key is the applicationServerKey the
+ * webapp subscribes with. VAPID_SUBJECT is how the push services can reach us, a mailto: or an https: url.
+ */
+func init() {
+	godotenv.Load()
+	privateKey := os.Getenv("VAPID_PRIVATE_KEY")
+	if privateKey == "" {
+		log.Printf("VAPID_PRIVATE_KEY is not set, web push is disabled\n")
+		return
+	}
+	subject := os.Getenv("VAPID_SUBJECT")
+	if subject == "" {
+		subject = "mailto:no-reply@inquisitive-grimalkin.local"
+	}
+	sender, err := NewWebPushSender(privateKey, subject)
+	if err != nil {
+		log.Printf("failed to load VAPID_PRIVATE_KEY, web push is disabled %s\n", err)
+		return
+	}
+	webPush = sender
+}
+
+/*
+ * Delivers push messages with the Web Push protocol, RFC 8030, encrypted as RFC 8291 asks and signed with VAPID, RFC 8292
+ */
+type WebPushSender struct {
+	Subject    string
+	Client     *http.Client
+	privateKey *ecdsa.PrivateKey
+	publicKey  string
+	repository data.CassandraPushRepository
+}
+
+func NewWebPushSender(privateKeyInBase64 string, subject string) (*WebPushSender, error) {
+	d, err := decodeBase64Url(privateKeyInBase64)
+	if err != nil {
+		return nil, fmt.Errorf("the private key is not base64url encoded %s", err)
+	}
+	ecdhKey, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("the private key is not a P-256 key %s", err)
+	}
+	publicKey := ecdhKey.PublicKey().Bytes()
+	return &WebPushSender{
+		Subject: subject,
+		Client:  &http.Client{Timeout: pushRequestTimeout},
+		privateKey: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(publicKey[1:33]),
+				Y:     new(big.Int).SetBytes(publicKey[33:]),
+			},
+			D: new(big.Int).SetBytes(d),
+		},
+		publicKey: base64.RawURLEncoding.EncodeToString(publicKey),
+	}, nil
+}
+
+func (s *WebPushSender) PublicKey() string {
+	return s.publicKey
+}
+
+/*
+ * Queues the payload for every browser of the user, a subscription the push service says is gone is deleted. Messages with the same topic replace
+ * each other while they wait in the push service, the topic has to be 32 base64url characters at most.
+ */
+func (s *WebPushSender) Notify(ctx context.Context, username string, payload []byte, topic string) error {
+	subs, err := s.repository.GetPushSubscriptions(ctx, username)
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		sub := sub
+		sharedPushQueue().Enqueue(Job{
+			Name: fmt.Sprintf("push to %s", username),
+			Run: func(ctx context.Context, _ int) error {
+				return s.Send(ctx, sub, payload, topic)
+			},
+			Done: func(_ int, err error) {
+				if !errors.Is(err, ErrPushSubscriptionGone) {
+					return
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+				defer cancel()
+				err = s.repository.DeletePushSubscription(ctx, username, sub.Endpoint)
+				if err != nil {
+					log.Printf("failed to delete the expired push subscription of %s %s\n", username, err)
+				}
+			},
+		})
+	}
+	return nil
+}
+
+func (s *WebPushSender) Send(ctx context.Context, sub models.PushSubscription, payload []byte, topic string) error {
+	body, err := encryptPushPayload(sub, payload)
+	if err != nil {
+		return Permanent(err)
+	}
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil {
+		return Permanent(fmt.Errorf("the endpoint of the push subscription is not a url %s", err))
+	}
+	token, err := s.vapidToken(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(pushMessageTTL.Seconds())))
+	req.Header.Set("Urgency", "normal")
+	req.Header.Set("Authorization", fmt.Sprintf("vapid t=%s, k=%s", token, s.publicKey))
+	if topic != "" {
+		req.Header.Set("Topic", topic)
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s %s", endpoint.Host, err)
+	}
+	defer res.Body.Close()
+	responseBody, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return Permanent(ErrPushSubscriptionGone)
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return fmt.Errorf("%s answered with %d %s", endpoint.Host, res.StatusCode, truncate(string(responseBody), 200))
+	case res.StatusCode >= 400:
+		return Permanent(fmt.Errorf("%s rejected the push message with %d %s", endpoint.Host, res.StatusCode, truncate(string(responseBody), 200)))
+	}
+	return nil
+}
+
+// The audience is the origin of the push service, a token is only good for the service it was made for
+func (s *WebPushSender) vapidToken(audience string) (string, error) {
+	claims := jwt.MapClaims{
+		`aud`: audience,
+		`exp`: time.Now().Add(vapidTokenValidity).Unix(),
+		`sub`: s.Subject,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(s.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign the vapid token %s", err)
+	}
+	return token, nil
+}
+
+func encryptPushPayload(sub models.PushSubscription, payload []byte) ([]byte, error) {
+	if len(payload) > maxPushPayloadSize {
+		return nil, ErrPushPayloadTooLarge
+	}
+	uaPublicKeyInBytes, err := decodeBase64Url(sub.Keys.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("the p256dh key of the subscription is not base64url encoded %s", err)
+	}
+	authSecret, err := decodeBase64Url(sub.Keys.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("the auth secret of the subscription is not base64url encoded %s", err)
+	}
+	uaPublicKey, err := ecdh.P256().NewPublicKey(uaPublicKeyInBytes)
+	if err != nil {
+		return nil, fmt.Errorf("the p256dh key of the subscription is not a P-256 key %s", err)
+	}
+	asPrivateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	return encryptPushRecord(payload, uaPublicKey, authSecret, asPrivateKey, salt)
+}
+
+/*
+ * The aes128gcm encoding of RFC 8291, a fresh key pair and salt are used for every message. The key and the nonce come from the shared secret of the
+ * two key pairs mixed with the auth secret of the browser, the result is a single record preceded by the salt, the record size and our public key.
+ */
+func encryptPushRecord(payload []byte, uaPublicKey *ecdh.PublicKey, authSecret []byte, asPrivateKey *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	ecdhSecret, err := asPrivateKey.ECDH(uaPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to agree on a secret with the browser %s", err)
+	}
+	asPublicKey := asPrivateKey.PublicKey().Bytes()
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublicKey.Bytes()...)
+	keyInfo = append(keyInfo, asPublicKey...)
+	ikm := make([]byte, 32)
+	_, err = io.ReadFull(hkdf.New(sha256.New, ecdhSecret, authSecret, keyInfo), ikm)
+	if err != nil {
+		return nil, err
+	}
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	contentEncryptionKey := make([]byte, 16)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), contentEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(salt)+5+len(asPublicKey))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, pushRecordSize)
+	header = append(header, byte(len(asPublicKey)))
+	header = append(header, asPublicKey...)
+
+	// 0x02 marks the last, and only, record
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+// The browsers hand the keys out without padding, some libraries add it
+func decodeBase64Url(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"inquisitive-grimalkin/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/hkdf"
+)
+
+// A browser subscribed to push messages, it holds the private key and the auth secret the push service never sees
+type fakeBrowser struct {
+	privateKey *ecdh.PrivateKey
+	authSecret []byte
+}
+
+func newFakeBrowser(t *testing.T) fakeBrowser {
+	t.Helper()
+	privateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate the key of the browser %s", err)
+	}
+	authSecret := make([]byte, 16)
+	_, err = rand.Read(authSecret)
+	if err != nil {
+		t.Fatalf("failed to generate the auth secret of the browser %s", err)
+	}
+	return fakeBrowser{privateKey: privateKey, authSecret: authSecret}
+}
+
+func (b fakeBrowser) subscription(endpoint string) models.PushSubscription {
+	return models.PushSubscription{
+		Endpoint: endpoint,
+		Keys: models.PushSubscriptionKeys{
+			P256dh: base64.RawURLEncoding.EncodeToString(b.privateKey.PublicKey().Bytes()),
+			Auth:   base64.RawURLEncoding.EncodeToString(b.authSecret),
+		},
+	}
+}
+
+// Decrypts an aes128gcm body the way a browser does, RFC 8291 section 3.4, from the other side than encryptPushRecord
+func (b fakeBrowser) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+	if len(body) < 21 {
+		t.Fatalf("the body is too short to hold the header, %d bytes", len(body))
+	}
+	salt := body[:16]
+	recordSize := binary.BigEndian.Uint32(body[16:20])
+	keyIdLength := int(body[20])
+	asPublicKeyInBytes := body[21 : 21+keyIdLength]
+	ciphertext := body[21+keyIdLength:]
+	if recordSize != pushRecordSize {
+		t.Errorf("the record size is %d, want %d", recordSize, pushRecordSize)
+	}
+
+	asPublicKey, err := ecdh.P256().NewPublicKey(asPublicKeyInBytes)
+	if err != nil {
+		t.Fatalf("the key id is not a P-256 public key %s", err)
+	}
+	ecdhSecret, err := b.privateKey.ECDH(asPublicKey)
+	if err != nil {
+		t.Fatalf("failed to agree on a secret with the server %s", err)
+	}
+	keyInfo := append([]byte("WebPush: info\x00"), b.privateKey.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, asPublicKeyInBytes...)
+	ikm := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, ecdhSecret, b.authSecret, keyInfo), ikm)
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	contentEncryptionKey := make([]byte, 16)
+	io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), contentEncryptionKey)
+	nonce := make([]byte, 12)
+	io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce)
+
+	block, err := aes.NewCipher(contentEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("failed to decrypt the push message %s", err)
+	}
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 0x02 {
+		t.Fatalf("the record does not end with the delimiter of the last record")
+	}
+	return plaintext[:len(plaintext)-1]
+}
+
+func newTestWebPushSender(t *testing.T, client *http.Client) *WebPushSender {
+	t.Helper()
+	vapidKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate the vapid key %s", err)
+	}
+	sender, err := NewWebPushSender(base64.RawURLEncoding.EncodeToString(vapidKey.Bytes()), "mailto:push@example.com")
+	if err != nil {
+		t.Fatalf("failed to create the sender %s", err)
+	}
+	sender.Client = client
+	return sender
+}
+
+func TestWebPushSenderSend(t *testing.T) {
+	var received *http.Request
+	var receivedBody []byte
+	pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer pushService.Close()
+
+	sender := newTestWebPushSender(t, pushService.Client())
+	browser := newFakeBrowser(t)
+	payload := []byte(`{"kind":"answer","message":"alice answered your question"}`)
+
+	err := sender.Send(context.Background(), browser.subscription(pushService.URL+"/push/abc"), payload, "answer-1")
+	if err != nil {
+		t.Fatalf("failed to send the push message %s", err)
+	}
+
+	if received.Method != http.MethodPost || received.URL.Path != "/push/abc" {
+		t.Errorf("the push service got %s %s, want POST /push/abc", received.Method, received.URL.Path)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":     "application/octet-stream",
+		"Content-Encoding": "aes128gcm",
+		"TTL":              "86400",
+		"Topic":            "answer-1",
+	} {
+		if got := received.Header.Get(header); got != want {
+			t.Errorf("%s is %q, want %q", header, got, want)
+		}
+	}
+
+	if got := browser.decrypt(t, receivedBody); !bytes.Equal(got, payload) {
+		t.Errorf("the browser decrypted %q, want %q", got, payload)
+	}
+
+	// The token has to be signed by the key the server hands out as its public key, for the origin of the push service
+	authorization := received.Header.Get("Authorization")
+	var token, key string
+	for _, param := range strings.Split(strings.TrimPrefix(authorization, "vapid "), ", ") {
+		name, value, _ := strings.Cut(param, "=")
+		switch name {
+		case "t":
+			token = value
+		case "k":
+			key = value
+		}
+	}
+	if key != sender.PublicKey() {
+		t.Errorf("the authorization carries the key %q, want %q", key, sender.PublicKey())
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodES256 {
+			return nil, errors.New("the vapid token is not signed with ES256")
+		}
+		return &sender.privateKey.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatalf("the vapid token is not valid %s", err)
+	}
+	if claims["aud"] != pushService.URL {
+		t.Errorf("the audience is %v, want %s", claims["aud"], pushService.URL)
+	}
+	if claims["sub"] != "mailto:push@example.com" {
+		t.Errorf("the subject is %v, want mailto:push@example.com", claims["sub"])
+	}
+}
+
+func TestWebPushSenderSendFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		permanent bool
+		gone      bool
+	}{
+		{name: "expired subscription", status: http.StatusGone, permanent: true, gone: true},
+		{name: "unknown subscription", status: http.StatusNotFound, permanent: true, gone: true},
+		{name: "throttled", status: http.StatusTooManyRequests},
+		{name: "push service down", status: http.StatusServiceUnavailable},
+		{name: "rejected message", status: http.StatusBadRequest, permanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer pushService.Close()
+
+			sender := newTestWebPushSender(t, pushService.Client())
+			err := sender.Send(context.Background(), newFakeBrowser(t).subscription(pushService.URL), []byte("hi"), "")
+			if err == nil {
+				t.Fatalf("a %d was taken as delivered", tt.status)
+			}
+			if IsPermanent(err) != tt.permanent {
+				t.Errorf("permanent is %t, want %t for %s", IsPermanent(err), tt.permanent, err)
+			}
+			if errors.Is(err, ErrPushSubscriptionGone) != tt.gone {
+				t.Errorf("gone is %t, want %t for %s", errors.Is(err, ErrPushSubscriptionGone), tt.gone, err)
+			}
+		})
+	}
+}
+
+func TestEncryptPushPayloadTooLarge(t *testing.T) {
+	sub := newFakeBrowser(t).subscription("https://push.example.com")
+	_, err := encryptPushPayload(sub, make([]byte, maxPushPayloadSize))
+	if err != nil {
+		t.Errorf("the largest payload was refused %s", err)
+	}
+	_, err = encryptPushPayload(sub, make([]byte, maxPushPayloadSize+1))
+	if !errors.Is(err, ErrPushPayloadTooLarge) {
+		t.Errorf("got %v for a payload over the limit, want ErrPushPayloadTooLarge", err)
+	}
+}
+
+// The example of RFC 8291 appendix A
+func TestEncryptPushRecordRFC8291(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := decodeBase64Url(s)
+		if err != nil {
+			t.Fatalf("failed to decode %s %s", s, err)
+		}
+		return b
+	}
+	asPrivateKey, err := ecdh.P256().NewPrivateKey(decode("yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uaPublicKey, err := ecdh.P256().NewPublicKey(decode("BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := encryptPushRecord([]byte("When I grow up, I want to be a watermelon"), uaPublicKey, decode("BTBZMqHH6r4Tts7J_aSIgg"), asPrivateKey,
+		decode("DGv6ra1nlYgDCS1FRnbzlw"))
+	if err != nil {
+		t.Fatalf("failed to encrypt %s", err)
+	}
+	want := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPT" +
+		"pK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	if base64.RawURLEncoding.EncodeToString(got) != want {
+		t.Errorf("got %s, want %s", base64.RawURLEncoding.EncodeToString(got), want)
+	}
+}
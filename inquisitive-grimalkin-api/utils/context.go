@@ -7,7 +7,7 @@ type ctxKey int
 
 const (
 	userCtxKey ctxKey = iota
-	
+	rolesCtxKey
 )
 
 func ContextWithUsername(ctx context.Context, username string) context.Context {
@@ -17,4 +17,22 @@ func ContextWithUsername(ctx context.Context, username string) context.Context {
 func UserFromContext(context context.Context) (string, bool) {
 	user, ok := context.Value(userCtxKey).(string)
 	return user, ok	
-}
\ No newline at end of file
+}
+
+func ContextWithRoles(ctx context.Context, roles []string) context.Context {
+	return context.WithValue(ctx, rolesCtxKey, roles)
+}
+
+func RolesFromContext(context context.Context) []string {
+	roles, _ := context.Value(rolesCtxKey).([]string)
+	return roles
+}
+
+func HasRole(context context.Context, role string) bool {
+	for _, r := range RolesFromContext(context) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Longer prefixes are not indexed, searches for longer terms are narrowed down after the lookup instead
+const MaxSearchPrefixLength = 20
+
+/*
+ * Usernames and names are searched without caring about case, accents or repeated spaces, so "jose" finds "José". Everything indexed for the search and
+ * every search term goes through here first.
+ */
+func SearchKey(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+/*
+ * Every prefix a user can be found by, the prefixes of the username, of the first and the last name and of the full name
+ */
+func SearchPrefixes(username string, firstName string, lastName string) []string {
+	seen := map[string]bool{}
+	prefixes := []string{}
+	for _, key := range []string{SearchKey(username), SearchKey(firstName), SearchKey(lastName), SearchKey(firstName + " " + lastName)} {
+		runes := []rune(key)
+		for i := 1; i <= len(runes) && i <= MaxSearchPrefixLength; i++ {
+			prefix := string(runes[:i])
+			if seen[prefix] || strings.HasSuffix(prefix, " ") {
+				continue
+			}
+			seen[prefix] = true
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// The key is cut to the length of the longest indexed prefix so that it can be looked up
+func SearchPrefix(key string) string {
+	runes := []rune(key)
+	if len(runes) > MaxSearchPrefixLength {
+		runes = runes[:MaxSearchPrefixLength]
+	}
+	return string(runes)
+}
+
+// Keys shorter than this are matched as typed, the typos allowed grow with the key, see MaxSearchEdits
+const fuzzySearchMinLength = 4
+
+// A typo is only looked for after the first characters, the candidates are read from the partition of those characters
+const fuzzySearchAnchorLength = 2
+
+// How many typos a key may hold and still find a user, one from 4 characters and two from 8
+func MaxSearchEdits(key string) int {
+	length := len([]rune(key))
+	switch {
+	case length < fuzzySearchMinLength:
+		return 0
+	case length < 2*fuzzySearchMinLength:
+		return 1
+	}
+	return 2
+}
+
+/*
+ * The prefix whose users_by_prefix partition holds every user the key can find, typos included. Short keys are looked up as they are, longer ones by
+ * their first characters so that a typo after them still finds the user.
+ */
+func SearchCandidatesPrefix(key string) string {
+	if MaxSearchEdits(key) == 0 {
+		return SearchPrefix(key)
+	}
+	return string([]rune(key)[:fuzzySearchAnchorLength])
+}
+
+/*
+ * The fewest edits, insertions, deletions or substitutions of a character, that turn the key into the start of the username, a name or the full name.
+ * A user is only found when it is at most MaxSearchEdits(key), 0 is a plain prefix match.
+ */
+func SearchDistance(key string, username string, firstName string, lastName string) (int, bool) {
+	best := -1
+	for _, searchable := range []string{username, firstName, lastName, firstName + " " + lastName} {
+		distance := prefixEditDistance([]rune(key), []rune(SearchKey(searchable)))
+		if best == -1 || distance < best {
+			best = distance
+		}
+	}
+	return best, best <= MaxSearchEdits(key)
+}
+
+// The Levenshtein distance between the key and the closest prefix of s
+func prefixEditDistance(key []rune, s []rune) int {
+	previous := make([]int, len(s)+1)
+	current := make([]int, len(s)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(key); i++ {
+		current[0] = i
+		for j := 1; j <= len(s); j++ {
+			substitution := previous[j-1]
+			if key[i-1] != s[j-1] {
+				substitution++
+			}
+			current[j] = minOf(substitution, previous[j]+1, current[j-1]+1)
+		}
+		previous, current = current, previous
+	}
+	closest := previous[0]
+	for _, distance := range previous {
+		if distance < closest {
+			closest = distance
+		}
+	}
+	return closest
+}
+
+func minOf(first int, others ...int) int {
+	for _, o := range others {
+		if o < first {
+			first = o
+		}
+	}
+	return first
+}
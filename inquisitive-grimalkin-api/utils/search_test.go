@@ -0,0 +1,38 @@
+package utils
+
+import "testing"
+
+func TestSearchDistance(t *testing.T) {
+	tests := []struct {
+		key      string
+		distance int
+		found    bool
+	}{
+		{key: "jose", distance: 0, found: true},
+		{key: "gri", distance: 0, found: true},
+		{key: "grimalkin", distance: 0, found: true},
+		{key: "jose garcia", distance: 0, found: true},
+		{key: "grimalkni", distance: 1, found: true},
+		{key: "grymalkin", distance: 1, found: true},
+		{key: "grmalkin", distance: 1, found: true},
+		{key: "garcai", distance: 1, found: true},
+		{key: "grn", distance: 1, found: false},
+		{key: "tabby", distance: 4, found: false},
+		{key: "jsoe garcia", distance: 2, found: true},
+	}
+	for _, tt := range tests {
+		distance, found := SearchDistance(tt.key, "Grimalkin", "José", "García")
+		if distance != tt.distance || found != tt.found {
+			t.Errorf("%q: got %d %t, want %d %t", tt.key, distance, found, tt.distance, tt.found)
+		}
+	}
+}
+
+// A typo after the first characters still reads the partition the user is in
+func TestSearchCandidatesPrefix(t *testing.T) {
+	for key, want := range map[string]string{"gr": "gr", "gri": "gri", "grymalkin": "gr", "grimalkingrimalkingrimalkin": "gr"} {
+		if got := SearchCandidatesPrefix(key); got != want {
+			t.Errorf("%q: got %q, want %q", key, got, want)
+		}
+	}
+}
@@ -1,39 +1,433 @@
 package utils
 
 import (
-	"errors"
+	"encoding/base64"
+	"fmt"
 	"inquisitive-grimalkin/models"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	maxQuestionLength       = 300
+	minUsernameLength       = 3
+	maxUsernameLength       = 12
+	maxNameLength           = 50
+	minPasswordLength       = 8
+	maxPasswordLength       = 36
+	strongPasswordLength    = 12
+	minPasswordStrength     = 2
+	maxEmailLength          = 254
+	maxEmailLocalPartLength = 64
+	maxReportDetailsLength  = 1000
+	maxModerationNoteLength = 1000
+	maxSuspensionDays       = 365
+	maxPushEndpointLength   = 2048
+	// Shorter usernames and local parts are found in too many passwords to tell anything about them
+	minGuessablePartLength = 4
 )
 
-func ValidateQuestion(q models.Question) error {
-	return nil
+var (
+	usernameRegex       = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+	emailLocalPartRegex = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+/=?^_`{|}~.-]+$")
+	emailDomainRegex    = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)+[A-Za-z]{2,63}$`)
+)
+
+var reportReasons = map[string]bool{
+	models.ReportSpam:          true,
+	models.ReportHarassment:    true,
+	models.ReportHateSpeech:    true,
+	models.ReportSexualContent: true,
+	models.ReportSelfHarm:      true,
+	models.ReportImpersonation: true,
+	models.ReportOther:         true,
+}
+
+/*
+ * Usernames that would clash with the routes under /users or that could be used to impersonate the staff
+ */
+var reservedUsernames = map[string]bool{
+	"me":        true,
+	"admin":     true,
+	"root":      true,
+	"moderator": true,
+	"mod":       true,
+	"support":   true,
+	"staff":     true,
+	"system":    true,
+	"anonymous": true,
+	"register":  true,
+	"login":     true,
+	"logout":    true,
+	"validate":  true,
+	"follow":    true,
+	"unfollow":  true,
+	"search":    true,
+	"settings":  true,
+	"api":       true,
+	"grimalkin": true,
 }
 
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+/*
+ * ValidationErrors carries every field that failed validation instead of only the first one, so that the client can highlight all of them at once.
+ * The routers serialize it as is and answer with 400.
+ */
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, e := range v {
+		messages = append(messages, fmt.Sprintf("%s: %s", e.Field, e.Message))
+	}
+	return strings.Join(messages, ", ")
+}
+
+func (v *ValidationErrors) Add(field string, code string, message string) {
+	*v = append(*v, FieldError{Field: field, Code: code, Message: message})
+}
+
+func (v ValidationErrors) OrNil() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+/*
+ * The question text is normalized to NFC before it is measured or stored, so that the same question typed on different keyboards ends up identical and
+ * the length limit is applied on what the user actually sees. The normalized question is returned and should be used from there on.
+ */
+func ValidateQuestion(q models.Question) (models.Question, error) {
+	errs := ValidationErrors{}
+
+	q.Question = strings.TrimSpace(norm.NFC.String(q.Question))
+	q.Asked = strings.TrimSpace(q.Asked)
+
+	if q.Asked == "" {
+		errs.Add("asked", "required", "the question must be asked to someone")
+	}
+	if q.Asker == "" {
+		errs.Add("asker", "required", "the asker of the question is unknown")
+	}
+	if q.Asked != "" && q.Asked == q.Asker {
+		errs.Add("asked", "self", "you cannot ask yourself a question")
+	}
+	if q.Question == "" {
+		errs.Add("question", "required", "question cannot be empty")
+	}
+	if utf8.RuneCountInString(q.Question) > maxQuestionLength {
+		errs.Add("question", "too_long", fmt.Sprintf("question cannot be longer than %d characters", maxQuestionLength))
+	}
+
+	return q, errs.OrNil()
+}
+
+/*
+ * Every field is checked and all the failures are returned together as ValidationErrors, lengths are counted in runes and not in bytes so that names
+ * written in non latin scripts are not cut short.
+ */
 func ValidateRegistration(u models.User) error {
-	if u.Username == "" {
-		return errors.New("username cannot be empty")
+	errs := ValidationErrors{}
+
+	switch {
+	case u.Username == "":
+		errs.Add("username", "required", "username cannot be empty")
+	case utf8.RuneCountInString(u.Username) < minUsernameLength:
+		errs.Add("username", "too_short", fmt.Sprintf("username cannot be shorter than %d characters", minUsernameLength))
+	case utf8.RuneCountInString(u.Username) > maxUsernameLength:
+		errs.Add("username", "too_long", fmt.Sprintf("username cannot be longer than %d characters", maxUsernameLength))
+	case !usernameRegex.MatchString(u.Username):
+		errs.Add("username", "invalid_charset", "username can only contain latin letters, digits, underscores and dots")
+	case IsReservedUsername(u.Username):
+		errs.Add("username", "reserved", fmt.Sprintf("the username %s is reserved", u.Username))
 	}
-	if u.Password == "" {
-		return errors.New("password cannot be empty")
+
+	validateEmail(&errs, "email", u.Email)
+	validateName(&errs, "firstName", "first name", u.FirstName)
+	validateName(&errs, "lastName", "last name", u.LastName)
+	validatePassword(&errs, "password", u.Password, u.Username, u.Email)
+
+	return errs.OrNil()
+}
+
+/*
+ * Unlike the registration every field of the profile update is optional, but a field that is sent has to follow the same rules as when registering
+ */
+func ValidateProfileUpdate(p models.ProfileUpdate) error {
+	errs := ValidationErrors{}
+
+	if p.Email == nil && p.FirstName == nil && p.LastName == nil {
+		errs.Add("profile", "required", "at least one of email, first name or last name has to be changed")
+	}
+	if p.Email != nil {
+		validateEmail(&errs, "email", *p.Email)
+	}
+	if p.FirstName != nil {
+		validateName(&errs, "firstName", "first name", *p.FirstName)
+	}
+	if p.LastName != nil {
+		validateName(&errs, "lastName", "last name", *p.LastName)
+	}
+
+	return errs.OrNil()
+}
+
+func ValidatePasswordChange(c models.PasswordChange, username string, email string) error {
+	errs := ValidationErrors{}
+
+	if c.CurrentPassword == "" {
+		errs.Add("currentPassword", "required", "current password cannot be empty")
 	}
-	// https://stackoverflow.com/questions/201323/how-can-i-validate-an-email-address-using-a-regular-expression
-	if u.Email == "" {
-		return errors.New("email cannot be empty")
+	validatePassword(&errs, "newPassword", c.NewPassword, username, email)
+	if c.NewPassword != "" && c.NewPassword == c.CurrentPassword {
+		errs.Add("newPassword", "unchanged", "new password has to be different from the current one")
 	}
-	if u.FirstName == "" {
-		return errors.New("first name cannot be empty")
+
+	return errs.OrNil()
+}
+
+func ValidateNewPassword(password string, username string, email string) error {
+	errs := ValidationErrors{}
+	validatePassword(&errs, "newPassword", password, username, email)
+	return errs.OrNil()
+}
+
+/*
+ * Mastodon accounts must come with the https url of their instance, the token is sent there and must never travel in the clear
+ */
+func ValidateLinkedAccount(a models.LinkedAccount) error {
+	errs := ValidationErrors{}
+
+	if a.Provider != models.ProviderTwitter && a.Provider != models.ProviderMastodon {
+		errs.Add("provider", "unsupported", fmt.Sprintf("accounts on %s cannot be linked", a.Provider))
 	}
-	if u.LastName == "" {
-		return errors.New("last name cannot be empty")
+	if strings.TrimSpace(a.Handle) == "" {
+		errs.Add("handle", "required", "the handle of the account cannot be empty")
 	}
-	if len(u.Username) > 12 {
-		return errors.New("username cannot be longer than 12 characters")
+	if a.AccessToken == "" {
+		errs.Add("accessToken", "required", "the access token of the account cannot be empty")
 	}
-	if len(u.Password) > 36 {
-		return errors.New("password cannot be longer than 36 characters")
+	if a.Provider == models.ProviderMastodon {
+		instanceUrl, err := url.Parse(a.InstanceUrl)
+		if a.InstanceUrl == "" {
+			errs.Add("instanceUrl", "required", "the instance of the mastodon account cannot be empty")
+		} else if err != nil || instanceUrl.Scheme != "https" || instanceUrl.Host == "" {
+			errs.Add("instanceUrl", "invalid", "the instance of the mastodon account must be an https url")
+		}
 	}
-	
-	// TODO: Use Prespective API to remove offensive and bad username, emails, first and last name from being able to be entered into the system
 
-	return nil
-}
\ No newline at end of file
+	return errs.OrNil()
+}
+
+/*
+ * The endpoint is called by the server, it has to be an https url. The keys are checked for their length only, p256dh is an uncompressed P-256 point
+ * and auth a 16 byte secret.
+ */
+func ValidatePushSubscription(sub models.PushSubscription) error {
+	errs := ValidationErrors{}
+
+	endpoint, err := url.Parse(sub.Endpoint)
+	if sub.Endpoint == "" {
+		errs.Add("endpoint", "required", "the endpoint of the subscription cannot be empty")
+	} else if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		errs.Add("endpoint", "invalid", "the endpoint of the subscription must be an https url")
+	} else if len(sub.Endpoint) > maxPushEndpointLength {
+		errs.Add("endpoint", "too_long", fmt.Sprintf("the endpoint cannot be longer than %d characters", maxPushEndpointLength))
+	}
+	p256dh, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.Keys.P256dh, "="))
+	if err != nil || len(p256dh) != 65 {
+		errs.Add("keys.p256dh", "invalid", "p256dh must be an uncompressed P-256 public key encoded in base64url")
+	}
+	auth, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.Keys.Auth, "="))
+	if err != nil || len(auth) != 16 {
+		errs.Add("keys.auth", "invalid", "auth must be a 16 byte secret encoded in base64url")
+	}
+
+	return errs.OrNil()
+}
+
+/*
+ * A q&a or a question is reported by its id and a user by their username, which goes in targetOwner. "other" is too vague to act on without details.
+ */
+func ValidateReport(r models.Report) error {
+	errs := ValidationErrors{}
+
+	switch r.TargetType {
+	case models.ReportTargetQAndA, models.ReportTargetQuestion:
+		if r.TargetId == (uuid.UUID{}) {
+			errs.Add("targetId", "required", fmt.Sprintf("the id of the reported %s cannot be empty", strings.ReplaceAll(r.TargetType, "_", " ")))
+		}
+	case models.ReportTargetUser:
+		if r.TargetOwner == r.Reporter {
+			errs.Add("targetOwner", "self", "you cannot report yourself")
+		}
+	default:
+		errs.Add("targetType", "invalid", "only a q&a, a question or a user can be reported")
+	}
+	if r.TargetOwner == "" {
+		errs.Add("targetOwner", "required", "the user the reported content belongs to cannot be empty")
+	}
+	if !reportReasons[r.Reason] {
+		errs.Add("reason", "invalid", fmt.Sprintf("%s is not a reason a report can be made for", r.Reason))
+	}
+	if r.Reason == models.ReportOther && strings.TrimSpace(r.Details) == "" {
+		errs.Add("details", "required", "the details cannot be empty when the reason is other")
+	}
+	if utf8.RuneCountInString(r.Details) > maxReportDetailsLength {
+		errs.Add("details", "too_long", fmt.Sprintf("the details cannot be longer than %d characters", maxReportDetailsLength))
+	}
+
+	return errs.OrNil()
+}
+
+// Deleting the content of a reported user makes no sense, everything else can be done whatever was reported
+func ValidateReportResolution(resolution models.ReportResolution, targetType string) error {
+	errs := ValidationErrors{}
+
+	switch resolution.Action {
+	case models.ModerationActionDismiss, models.ModerationActionWarn, models.ModerationActionSuspend:
+	case models.ModerationActionDeleteContent:
+		if targetType == models.ReportTargetUser {
+			errs.Add("action", "invalid", "a reported user has no content to delete, suspend them instead")
+		}
+	default:
+		errs.Add("action", "invalid", fmt.Sprintf("%s is not an action a moderator can take", resolution.Action))
+	}
+	if resolution.Action == models.ModerationActionSuspend && resolution.SuspensionDays != nil &&
+		(*resolution.SuspensionDays < 1 || *resolution.SuspensionDays > maxSuspensionDays) {
+		errs.Add("suspensionDays", "out_of_range", fmt.Sprintf("a suspension lasts between 1 and %d days, ban the user for longer", maxSuspensionDays))
+	}
+	if utf8.RuneCountInString(resolution.Note) > maxModerationNoteLength {
+		errs.Add("note", "too_long", fmt.Sprintf("the note cannot be longer than %d characters", maxModerationNoteLength))
+	}
+
+	return errs.OrNil()
+}
+
+func ValidateAccountStatus(status models.AccountStatus, now time.Time) error {
+	errs := ValidationErrors{}
+
+	if !status.SuspendedUntil.IsZero() && !status.SuspendedUntil.After(now) {
+		errs.Add("suspendedUntil", "in_the_past", "a suspension has to end in the future, leave it empty to lift it")
+	}
+	if utf8.RuneCountInString(status.Reason) > maxModerationNoteLength {
+		errs.Add("reason", "too_long", fmt.Sprintf("the reason cannot be longer than %d characters", maxModerationNoteLength))
+	}
+
+	return errs.OrNil()
+}
+
+func validateEmail(errs *ValidationErrors, field string, email string) {
+	switch {
+	case email == "":
+		errs.Add(field, "required", "email cannot be empty")
+	case !IsValidEmail(email):
+		errs.Add(field, "invalid_format", "email is not a valid email address")
+	}
+}
+
+func validateName(errs *ValidationErrors, field string, description string, name string) {
+	switch {
+	case name == "":
+		errs.Add(field, "required", fmt.Sprintf("%s cannot be empty", description))
+	case utf8.RuneCountInString(name) > maxNameLength:
+		errs.Add(field, "too_long", fmt.Sprintf("%s cannot be longer than %d characters", description, maxNameLength))
+	}
+}
+
+func validatePassword(errs *ValidationErrors, field string, password string, username string, email string) {
+	switch {
+	case password == "":
+		errs.Add(field, "required", "password cannot be empty")
+	case utf8.RuneCountInString(password) < minPasswordLength:
+		errs.Add(field, "too_short", fmt.Sprintf("password cannot be shorter than %d characters", minPasswordLength))
+	case utf8.RuneCountInString(password) > maxPasswordLength:
+		errs.Add(field, "too_long", fmt.Sprintf("password cannot be longer than %d characters", maxPasswordLength))
+	case PasswordStrength(password, username, email) < minPasswordStrength:
+		errs.Add(field, "too_weak", "password is too easy to guess, mix upper and lower case letters, digits and symbols")
+	}
+}
+
+/*
+ * A lighter take on RFC 5322, quoted local parts, comments and ip literals are not accepted, nobody registers with those and they are mostly used to
+ * smuggle things past validators
+ * https://stackoverflow.com/questions/201323/how-can-i-validate-an-email-address-using-a-regular-expression
+ */
+func IsValidEmail(email string) bool {
+	if len(email) > maxEmailLength {
+		return false
+	}
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at > maxEmailLocalPartLength {
+		return false
+	}
+	localPart, domain := email[:at], email[at+1:]
+	if strings.HasPrefix(localPart, ".") || strings.HasSuffix(localPart, ".") || strings.Contains(localPart, "..") {
+		return false
+	}
+	return emailLocalPartRegex.MatchString(localPart) && emailDomainRegex.MatchString(domain)
+}
+
+func IsReservedUsername(username string) bool {
+	return reservedUsernames[strings.ToLower(username)]
+}
+
+/*
+ * The score goes from 0 to 4, a point for the length and a point for each class of characters used beyond the first one. Passwords that contain the
+ * username or the local part of the email are always scored 0, as long as those are at least minGuessablePartLength characters long.
+ */
+func PasswordStrength(password string, username string, email string) int {
+	lowered := strings.ToLower(password)
+	guessableParts := []string{username}
+	if at := strings.Index(email, "@"); at > 0 {
+		guessableParts = append(guessableParts, email[:at])
+	}
+	for _, part := range guessableParts {
+		if utf8.RuneCountInString(part) >= minGuessablePartLength && strings.Contains(lowered, strings.ToLower(part)) {
+			return 0
+		}
+	}
+
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	classes := 0
+	for _, has := range []bool{hasLower, hasUpper, hasDigit, hasSymbol} {
+		if has {
+			classes++
+		}
+	}
+
+	score := classes - 1
+	if utf8.RuneCountInString(password) >= strongPasswordLength {
+		score++
+	}
+	if score < 0 {
+		return 0
+	}
+	return score
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"inquisitive-grimalkin/models"
+	"testing"
+)
+
+func TestValidateReportResolutionSuspensionDays(t *testing.T) {
+	days := func(d int) *int { return &d }
+	tests := []struct {
+		name           string
+		suspensionDays *int
+		valid          bool
+	}{
+		{name: "left out", suspensionDays: nil, valid: true},
+		{name: "a day", suspensionDays: days(1), valid: true},
+		{name: "a year", suspensionDays: days(maxSuspensionDays), valid: true},
+		{name: "no days", suspensionDays: days(0), valid: false},
+		{name: "negative", suspensionDays: days(-3), valid: false},
+		{name: "over a year", suspensionDays: days(maxSuspensionDays + 1), valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolution := models.ReportResolution{Action: models.ModerationActionSuspend, SuspensionDays: tt.suspensionDays}
+			err := ValidateReportResolution(resolution, models.ReportTargetUser)
+			if (err == nil) != tt.valid {
+				t.Errorf("got %v, want valid %t", err, tt.valid)
+			}
+		})
+	}
+}
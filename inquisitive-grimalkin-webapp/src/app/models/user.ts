@@ -12,4 +12,17 @@ export enum Rank {
     NORMIE,
     MODERATOR,
     ADMIN
-}
\ No newline at end of file
+}
+export interface PublicUser {
+    username : string,
+    firstName : string,
+    lastName : string,
+    followers : number,
+}
+
+export interface UserSearchPage {
+    users : PublicUser[],
+    page : number,
+    pageSize : number,
+    hasMore : boolean,
+}
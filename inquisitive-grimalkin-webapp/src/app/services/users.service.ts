@@ -1,7 +1,7 @@
 import { HttpClient } from '@angular/common/http';
 import { Injectable } from '@angular/core';
 import { Observable } from 'rxjs';
-import { User } from '../models/user';
+import { UserSearchPage } from '../models/user';
 
 @Injectable({
   providedIn: 'root'
@@ -22,8 +22,8 @@ export class UsersService {
     return this.http.post<string>(followUrl, null)
   }
 
-  public searchForUser(username : string) : Observable<User> {
-    const searchUrl = this.usersApiUrl + `search/` + username;
-    return this.http.get<User>(searchUrl) 
+  public searchForUser(username : string, page : number = 0) : Observable<UserSearchPage> {
+    const searchUrl = this.usersApiUrl + `search/` + encodeURIComponent(username) + `?page=` + page;
+    return this.http.get<UserSearchPage>(searchUrl) 
   }
 }